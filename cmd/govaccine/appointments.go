@@ -0,0 +1,136 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"io"
+	"os"
+)
+
+type appointmentsArguments struct {
+	configArguments
+	upcomingOnly bool
+}
+
+func parseAppointmentsArgs(flagSet *flag.FlagSet, args []string, appointmentsArgs *appointmentsArguments) error {
+	appointmentsArgs.registerConfigFlags(flagSet)
+	appointmentsArgs.registerAccountFlags(flagSet)
+	flagSet.BoolVar(&appointmentsArgs.upcomingOnly, "upcoming", false, "Only show the upcoming appointments")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if appointmentsArgs.configFilepath == "" && appointmentsArgs.doctolibUsername == "" {
+		return fmt.Errorf("either a configuration file (-c flag) or a Doctolib username (-u flag) is required")
+	}
+
+	return appointmentsArgs.check()
+}
+
+func formatAppointment(appointment *doctolib.Appointment) string {
+	details := "ID " + appointment.Id
+	if appointment.LinkedAppointmentId != "" {
+		details += ", linked to " + appointment.LinkedAppointmentId
+	}
+	if appointment.Status != "" {
+		details += ", " + appointment.Status
+	}
+
+	return fmt.Sprintf("%s  %s - %s, %s (%s)", appointment.StartDate, appointment.VisitMotiveName,
+		appointment.ProfileName, appointment.Address, details)
+}
+
+func printAppointments(w io.Writer, title string, appointments []doctolib.Appointment) {
+	_, _ = fmt.Fprintf(w, "    %s:\n", title)
+	if len(appointments) == 0 {
+		_, _ = fmt.Fprintf(w, "      none\n")
+	}
+	for _, appointment := range appointments {
+		_, _ = fmt.Fprintf(w, "      %s\n", formatAppointment(&appointment))
+	}
+}
+
+func printPatientsAppointments(w io.Writer, username string, patientsAppointments []govaccine.PatientAppointments,
+	upcomingOnly bool) {
+	_, _ = fmt.Fprintf(w, "%s:\n", username)
+	for _, patientAppointments := range patientsAppointments {
+		_, _ = fmt.Fprintf(w, "  %d %s %s\n", patientAppointments.MasterPatient.Id,
+			patientAppointments.MasterPatient.FirstName, patientAppointments.MasterPatient.LastName)
+		printAppointments(w, "Upcoming", patientAppointments.Upcoming)
+		if !upcomingOnly {
+			printAppointments(w, "Past", patientAppointments.Past)
+		}
+	}
+}
+
+func runAppointmentsCommand(args []string) error {
+	flagSet := newFlagSet("appointments", "Show the upcoming and past appointments of every patient of every "+
+		"Doctolib account.")
+	var appointmentsArgs appointmentsArguments
+	if err := parseAppointmentsArgs(flagSet, args, &appointmentsArgs); err != nil {
+		return err
+	}
+
+	config, err := appointmentsArgs.load(flagSet, nil)
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateWithoutCenters(); err != nil {
+		return err
+	}
+	if err := appointmentsArgs.loadCredentials(config); err != nil {
+		return err
+	}
+	defer config.WipeCredentials()
+
+	for i := range config.Accounts {
+		if i > 0 {
+			_, _ = fmt.Fprintln(os.Stdout)
+		}
+
+		// Each account gets its own client, hence its own session
+		doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout,
+			logging.Discard())
+		if err != nil {
+			return err
+		}
+		session, err := govaccine.NewAccountSession(doctolibClient, &config.Accounts[i])
+		if err != nil {
+			return err
+		}
+		patientsAppointments, err := session.PatientsAppointments()
+		if err != nil {
+			return err
+		}
+		printPatientsAppointments(os.Stdout, config.Accounts[i].Username, patientsAppointments,
+			appointmentsArgs.upcomingOnly)
+	}
+
+	return nil
+}
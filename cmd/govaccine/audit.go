@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"io"
+	"os"
+	"strings"
+)
+
+type auditArguments struct {
+	auditLogFilepath string
+	runId            string
+	center           string
+	worker           string
+	failedOnly       bool
+	jsonOutput       bool
+}
+
+func parseAuditArgs(args []string, auditArgs *auditArguments) error {
+	flagSet := newFlagSet("audit", "Show the booking attempts recorded in the audit log.")
+	flagSet.StringVar(&auditArgs.auditLogFilepath, "a", govaccine.DefaultAuditLogFilepath,
+		"Filepath of the audit log to read")
+	flagSet.StringVar(&auditArgs.runId, "r", "", "Only show the booking run with this ID")
+	flagSet.StringVar(&auditArgs.center, "c", "",
+		"Only show the booking runs of vaccination centers containing this string")
+	flagSet.StringVar(&auditArgs.worker, "w", "", "Only show the booking runs of this worker")
+	flagSet.BoolVar(&auditArgs.failedOnly, "failed", false, "Only show failed booking runs")
+	flagSet.BoolVar(&auditArgs.jsonOutput, "json", false, "Output the matching records as JSONL")
+
+	return flagSet.Parse(args)
+}
+
+// groupAuditRuns groups records by booking run, in order of appearance.
+func groupAuditRuns(records []govaccine.AuditRecord) ([]string, map[string][]govaccine.AuditRecord) {
+	var runIds []string
+	runs := make(map[string][]govaccine.AuditRecord)
+	for _, record := range records {
+		if _, ok := runs[record.RunId]; !ok {
+			runIds = append(runIds, record.RunId)
+		}
+		runs[record.RunId] = append(runs[record.RunId], record)
+	}
+
+	return runIds, runs
+}
+
+func auditRunFailed(records []govaccine.AuditRecord) bool {
+	for _, record := range records {
+		if record.Event == govaccine.AuditRunFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printAuditRun(w io.Writer, records []govaccine.AuditRecord) {
+	first := records[0]
+	_, _ = fmt.Fprintf(w, "Run %s - %s - %s (%s)\n", first.RunId, first.Time.Format("2006-01-02 15:04:05"),
+		first.Center, first.Worker)
+
+	for _, record := range records {
+		timestamp := record.Time.Format("15:04:05.000")
+		switch record.Event {
+		case govaccine.AuditRunStarted:
+			_, _ = fmt.Fprintf(w, "  %s  started: slot %s, profile %d, visit motives %v, agendas %v, practices %v\n",
+				timestamp, record.Slot, record.ProfileId, record.VisitMotiveIds, record.AgendaIds, record.PracticeIds)
+		case govaccine.AuditApiCall:
+			status := fmt.Sprintf("%d", record.Call.StatusCode)
+			if record.Call.Error != "" {
+				status = "error: " + record.Call.Error
+			}
+			_, _ = fmt.Fprintf(w, "  %s  %-6s %-26s %s (%d ms, request %s)\n", timestamp, record.Call.Method,
+				record.Call.Endpoint, status, record.Call.DurationMs, record.Call.RequestId)
+			if len(record.Call.Payload) > 0 {
+				_, _ = fmt.Fprintf(w, "                payload: %s\n", record.Call.Payload)
+			}
+		case govaccine.AuditRunSucceeded:
+			_, _ = fmt.Fprintf(w, "  %s  succeeded: appointment %s confirmed\n", timestamp, record.AppointmentId)
+		case govaccine.AuditRunDryRun:
+			_, _ = fmt.Fprintf(w, "  %s  dry run: appointment %s released instead of confirmed\n", timestamp,
+				record.AppointmentId)
+		case govaccine.AuditRunFailed:
+			_, _ = fmt.Fprintf(w, "  %s  failed (appointment %s): %s\n", timestamp, record.AppointmentId,
+				record.Error)
+		}
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+func runAuditCommand(args []string) error {
+	var auditArgs auditArguments
+	if err := parseAuditArgs(args, &auditArgs); err != nil {
+		return err
+	}
+
+	file, err := os.Open(auditArgs.auditLogFilepath)
+	if err != nil {
+		return fmt.Errorf("main.runAuditCommand(): failed to open file %s: %s", auditArgs.auditLogFilepath, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	records, err := govaccine.ReadAuditRecords(file, func(record *govaccine.AuditRecord) bool {
+		return (auditArgs.runId == "" || record.RunId == auditArgs.runId) &&
+			(auditArgs.center == "" || strings.Contains(record.Center, auditArgs.center)) &&
+			(auditArgs.worker == "" || record.Worker == auditArgs.worker)
+	})
+	if err != nil {
+		return fmt.Errorf("main.runAuditCommand(): failed to read audit log %s: %w", auditArgs.auditLogFilepath, err)
+	}
+
+	runIds, runs := groupAuditRuns(records)
+	encoder := json.NewEncoder(os.Stdout)
+	for _, runId := range runIds {
+		if auditArgs.failedOnly && !auditRunFailed(runs[runId]) {
+			continue
+		}
+
+		if !auditArgs.jsonOutput {
+			printAuditRun(os.Stdout, runs[runId])
+			continue
+		}
+
+		for i := range runs[runId] {
+			if err := encoder.Encode(&runs[runId][i]); err != nil {
+				return fmt.Errorf("main.runAuditCommand(): failed to write record: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
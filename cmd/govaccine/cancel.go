@@ -0,0 +1,142 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+type cancelArguments struct {
+	configArguments
+	appointmentId string
+	yes           bool
+}
+
+func parseCancelArgs(flagSet *flag.FlagSet, args []string, cancelArgs *cancelArguments) error {
+	cancelArgs.registerConfigFlags(flagSet)
+	cancelArgs.registerAccountFlags(flagSet)
+	flagSet.StringVar(&cancelArgs.appointmentId, "id", "",
+		"ID of the upcoming appointment to cancel, as shown by the appointments command")
+	flagSet.BoolVar(&cancelArgs.yes, "y", false, "Cancel without asking for confirmation")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if cancelArgs.configFilepath == "" && cancelArgs.doctolibUsername == "" {
+		return errors.New("either a configuration file (-c flag) or a Doctolib username (-u flag) is required")
+	}
+	if cancelArgs.appointmentId == "" {
+		return errors.New("appointment ID (-id flag) is required")
+	}
+
+	return cancelArgs.check()
+}
+
+// confirmCancellation asks on the terminal whether to cancel appointment.
+func confirmCancellation(appointment *doctolib.Appointment) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("standard input is not a terminal, use -y to cancel without confirmation")
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Cancel %s? [y/N] ", formatAppointment(appointment))
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("main.confirmCancellation(): cannot read answer: %w", err)
+	}
+
+	return strings.EqualFold(strings.TrimSpace(answer), "y"), nil
+}
+
+func runCancelCommand(args []string) error {
+	flagSet := newFlagSet("cancel", "Cancel an upcoming appointment of a patient of a Doctolib account, and the "+
+		"appointment linked to it (e.g. its second shot).")
+	var cancelArgs cancelArguments
+	if err := parseCancelArgs(flagSet, args, &cancelArgs); err != nil {
+		return err
+	}
+
+	config, err := cancelArgs.load(flagSet, nil)
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateWithoutCenters(); err != nil {
+		return err
+	}
+	if err := cancelArgs.loadCredentials(config); err != nil {
+		return err
+	}
+	defer config.WipeCredentials()
+
+	for i := range config.Accounts {
+		doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout,
+			logging.Discard())
+		if err != nil {
+			return err
+		}
+		session, err := govaccine.NewAccountSession(doctolibClient, &config.Accounts[i])
+		if err != nil {
+			return err
+		}
+		patientsAppointments, err := session.PatientsAppointments()
+		if err != nil {
+			return err
+		}
+
+		for _, patientAppointments := range patientsAppointments {
+			for _, appointment := range patientAppointments.Upcoming {
+				if appointment.Id != cancelArgs.appointmentId {
+					continue
+				}
+
+				if !cancelArgs.yes {
+					confirmed, err := confirmCancellation(&appointment)
+					if err != nil {
+						return err
+					}
+					if !confirmed {
+						return errors.New("appointment not canceled")
+					}
+				}
+				if err := session.CancelAppointment(&appointment); err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintf(os.Stdout, "Canceled %s for %s %s\n", formatAppointment(&appointment),
+					patientAppointments.MasterPatient.FirstName, patientAppointments.MasterPatient.LastName)
+
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no upcoming appointment with ID %s", cancelArgs.appointmentId)
+}
@@ -0,0 +1,198 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"golang.org/x/term"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// configArguments are the flags shared by the commands loading the configuration and the credentials.
+type configArguments struct {
+	configFilepath             string
+	vaccinationCentersFilepath string
+	requestsTimeout            uint
+	doctolibUsername           string
+	doctolibPassword           string
+	passwordSource             string
+	verbose                    bool
+	quiet                      bool
+	logFormat                  string
+}
+
+// registerConfigFlags registers the configuration file and requests timeout flags (-c and -t).
+func (a *configArguments) registerConfigFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&a.configFilepath, "c", "",
+		"Filepath of a YAML configuration file (see assets/govaccine.example.yaml), overridden by the other flags")
+	flagSet.UintVar(&a.requestsTimeout, "t", 5, "Number of seconds after which a request times out")
+}
+
+// registerCentersFlag registers the vaccination centers file flag (-f).
+func (a *configArguments) registerCentersFlag(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&a.vaccinationCentersFilepath, "f", "",
+		"Filepath of a file containing the desired vaccination centers (1 URL per line, or .csv or .json file)")
+}
+
+// registerAccountFlags registers the Doctolib account flags (-u, -p and -P).
+func (a *configArguments) registerAccountFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&a.doctolibUsername, "u", "", "Doctolib username (email)")
+	flagSet.StringVar(&a.doctolibPassword, "p", "",
+		"Doctolib password (insecure: visible in the shell history and the process list, prefer -P)")
+	flagSet.StringVar(&a.passwordSource, "P", "", fmt.Sprintf(
+		"Doctolib password source: \"env:NAME\", \"file:PATH\", \"command:COMMAND\" or \"prompt\" "+
+			"(default \"env:%s\" if set, \"prompt\" otherwise)", govaccine.DefaultPasswordEnv))
+}
+
+// registerLoggingFlags registers the logging flags (-v, -q and -l).
+func (a *configArguments) registerLoggingFlags(flagSet *flag.FlagSet) {
+	flagSet.BoolVar(&a.verbose, "v", false, "Verbose: also log debug messages (e.g. every Doctolib request)")
+	flagSet.BoolVar(&a.quiet, "q", false, "Quiet: only log warnings and errors")
+	flagSet.StringVar(&a.logFormat, "l", "text", "Log format: \"text\" or \"json\"")
+}
+
+// check checks the combinations of flags once parsed.
+func (a *configArguments) check() error {
+	if a.verbose && a.quiet {
+		return errors.New("-v and -q flags are mutually exclusive")
+	}
+	if a.doctolibPassword != "" && a.passwordSource != "" {
+		return errors.New("-p and -P flags are mutually exclusive")
+	}
+
+	return nil
+}
+
+// checkRequired checks that the account and the vaccination centers are given when there is no configuration file.
+func (a *configArguments) checkRequired() error {
+	if a.configFilepath != "" {
+		return nil
+	}
+	if a.doctolibUsername == "" {
+		return errors.New("Doctolib username (-u flag) is required")
+	}
+	if a.vaccinationCentersFilepath == "" {
+		return errors.New("Vaccination centers filepath (-f flag) is required")
+	}
+
+	return nil
+}
+
+// load loads the configuration file (if any) and overrides it with the flags explicitly set on flagSet. The flags
+// specific to a command are applied by override, if not nil. The configuration isn't validated.
+func (a *configArguments) load(flagSet *flag.FlagSet,
+	override func(config *govaccine.Config, flagName string) error) (*govaccine.Config, error) {
+	config := govaccine.DefaultConfig()
+	if a.configFilepath != "" {
+		var err error
+		if config, err = govaccine.LoadConfig(a.configFilepath); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	flagSet.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "u":
+			account := govaccine.AccountConfig{Username: a.doctolibUsername}
+			for _, configAccount := range config.Accounts {
+				if configAccount.Username == a.doctolibUsername {
+					account = configAccount
+				}
+			}
+			config.Accounts = []govaccine.AccountConfig{account}
+		case "f":
+			config.Centers = govaccine.CentersConfig{Files: []string{a.vaccinationCentersFilepath}}
+		case "t":
+			config.Scheduling.RequestsTimeout = time.Duration(a.requestsTimeout) * time.Second
+		case "v":
+			config.Logging.Level = "debug"
+		case "q":
+			config.Logging.Level = "warn"
+		case "l":
+			config.Logging.Format = a.logFormat
+		default:
+			if override != nil && err == nil {
+				err = override(config, f.Name)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Applied after -u so that the password is set on the account it selected
+	if a.doctolibPassword != "" || a.passwordSource != "" {
+		for i := range config.Accounts {
+			config.Accounts[i].Password = a.doctolibPassword
+			config.Accounts[i].PasswordSource = a.passwordSource
+		}
+	}
+
+	return config, nil
+}
+
+// loadCredentials reads the passwords of the accounts of config, prompting for them if needed.
+func (a *configArguments) loadCredentials(config *govaccine.Config) error {
+	if a.doctolibPassword != "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Warning: the -p flag exposes the password in the shell history and the "+
+			"process list, prefer -P")
+	}
+
+	return config.LoadCredentials(promptPassword)
+}
+
+// promptPassword asks for a password on the terminal without echoing it.
+func promptPassword(username string) ([]byte, error) {
+	stdinFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdinFd) {
+		return nil, errors.New("standard input is not a terminal, use -P or password_source to set a password source")
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Doctolib password for %s: ", username)
+	password, err := term.ReadPassword(stdinFd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("main.promptPassword(): cannot read password: %w", err)
+	}
+
+	return password, nil
+}
+
+// newLogger creates the logger configured in config, writing to output.
+func newLogger(config *govaccine.Config, output io.Writer) (*slog.Logger, error) {
+	logLevel, err := logging.ParseLevel(config.Logging.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	return logging.NewLogger(output, config.Logging.Format, logLevel)
+}
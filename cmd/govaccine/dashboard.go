@@ -0,0 +1,377 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dashboardRefreshPeriod = 500 * time.Millisecond
+	// dashboardLogLines is the number of log lines kept to be shown at the bottom of the dashboard.
+	dashboardLogLines = 100
+
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l"
+	ansiBold       = "\x1b[1m"
+	ansiReverse    = "\x1b[7m"
+	ansiRed        = "\x1b[31m"
+	ansiGreen      = "\x1b[32m"
+	ansiReset      = "\x1b[0m"
+
+	keyUp   = "up"
+	keyDown = "down"
+)
+
+// logBuffer keeps the last log lines while the dashboard is shown, then writes the next ones to its output.
+type logBuffer struct {
+	mutex  sync.Mutex
+	lines  []string
+	output io.Writer
+}
+
+// dashboard shows the state of the search in the terminal, and lets the user pause centers or quit.
+type dashboard struct {
+	scheduler   *govaccine.Scheduler
+	coordinator *govaccine.BookingCoordinator
+	ranking     *govaccine.CenterRanking
+	monitor     *govaccine.Monitor
+	logs        *logBuffer
+	centers     []govaccine.ScheduledCenter
+	selected    int
+	status      string
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.output != nil {
+		return l.output.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+	if len(l.lines) > dashboardLogLines {
+		l.lines = l.lines[len(l.lines)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+// last returns the last n log lines.
+func (l *logBuffer) last(n int) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if n > len(l.lines) {
+		n = len(l.lines)
+	}
+	return append([]string(nil), l.lines[len(l.lines)-n:]...)
+}
+
+// detach writes the next log lines to output instead of keeping them.
+func (l *logBuffer) detach(output io.Writer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.output = output
+}
+
+// readKeys sends the keys pressed on stdin to keys. Arrow keys are sent as keyUp and keyDown.
+func readKeys(keys chan<- string) {
+	buffer := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if err != nil {
+			return
+		}
+		input := string(buffer[:n])
+		switch input {
+		case "\x1b[A":
+			keys <- keyUp
+		case "\x1b[B":
+			keys <- keyDown
+		default:
+			for _, r := range input {
+				keys <- string(r)
+			}
+		}
+	}
+}
+
+// fit pads or truncates s to width runes.
+func fit(s string, width int) string {
+	length := utf8.RuneCountInString(s)
+	if length > width {
+		runes := []rune(s)
+		if width <= 1 {
+			return string(runes[:width])
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-length)
+}
+
+func formatClock(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("15:04:05")
+}
+
+// handleKey applies the action of key.
+func (d *dashboard) handleKey(key string) {
+	var center *govaccine.ScheduledCenter
+	if d.selected < len(d.centers) {
+		center = &d.centers[d.selected]
+	}
+
+	var err error
+	switch key {
+	case "q", "\x03": // Ctrl+C doesn't send SIGINT in raw mode
+		d.status = "Quitting once the bookings in progress are over..."
+		go d.coordinator.Shutdown()
+	case keyUp, "k":
+		if d.selected > 0 {
+			d.selected--
+		}
+	case keyDown, "j":
+		if d.selected < len(d.centers)-1 {
+			d.selected++
+		}
+	case "p":
+		paused := !d.scheduler.Status().Paused
+		if err = d.scheduler.Pause(paused); err == nil {
+			d.status = map[bool]string{true: "Paused polling", false: "Resumed polling"}[paused]
+		}
+	case " ":
+		if center != nil {
+			if err = d.scheduler.PauseCenter(center.Center.Name, !center.Paused); err == nil {
+				d.status = fmt.Sprintf("%s %s", map[bool]string{true: "Paused", false: "Resumed"}[!center.Paused],
+					center.Center)
+			}
+		}
+	case "c":
+		if center != nil {
+			if err = d.scheduler.CheckNow(center.Center.Name); err == nil {
+				d.status = fmt.Sprintf("Checking %s right away", center.Center)
+			}
+		}
+	}
+	if err != nil {
+		d.status = err.Error()
+	}
+}
+
+func (d *dashboard) render(out io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 120, 40
+	}
+	if centers, err := d.scheduler.Centers(); err == nil {
+		d.centers = centers
+	}
+	if d.selected >= len(d.centers) {
+		d.selected = max(len(d.centers)-1, 0)
+	}
+
+	var screen bytes.Buffer
+	lines := 0
+	line := func(style string, format string, a ...interface{}) {
+		if lines >= height-1 {
+			return
+		}
+		text := fit(fmt.Sprintf(format, a...), width)
+		if style != "" {
+			text = style + text + ansiReset
+		}
+		screen.WriteString(text + "\r\n")
+		lines++
+	}
+
+	status := d.scheduler.Status()
+	session := d.monitor.Session()
+	polling := "running"
+	if status.Paused {
+		polling = "PAUSED"
+	}
+	line(ansiBold, "govaccine  polling %s  workers %d (%d-%d)  backlog %d  session re-logins %d (last %s)  "+
+		"throttled %d (last %s)", polling, status.Workers, status.MinWorkers, status.MaxWorkers, status.Backlog,
+		session.Relogins, formatClock(session.LastRelogin), session.Throttled, formatClock(session.LastThrottled))
+	line("", "")
+
+	workers := d.monitor.Workers()
+	line(ansiBold, "WORKERS")
+	for _, worker := range workers {
+		job := "waiting"
+		if worker.Center != "" {
+			job = "checking " + worker.Center
+		}
+		line("", "  %-10s %-30s %s for %s", worker.Name, worker.Account, job,
+			time.Since(worker.Since).Truncate(time.Second))
+	}
+	line("", "")
+
+	bookings := d.monitor.Bookings()
+	sightings := d.monitor.Sightings()
+	logs := d.logs.last(5)
+	// The centers take the room left by the other panels
+	centerLines := height - 1 - lines - 6 - len(bookings) - min(len(sightings), 5) - len(logs) - 2
+	line(ansiBold, "  %-32s %-7s %-10s %-6s %-9s %s", "CENTERS", "STATE", "CHECKED", "SLOTS", "DISTANCE",
+		"LAST ERROR")
+	first := 0
+	if d.selected >= centerLines {
+		first = d.selected - centerLines + 1
+	}
+	for i := first; i < len(d.centers) && i < first+max(centerLines, 1); i++ {
+		center := d.centers[i]
+		state := d.monitor.Center(center.Center.Name)
+		pausedLabel := "active"
+		if center.Paused {
+			pausedLabel = "paused"
+		}
+		distance := "-"
+		if km, ok := d.ranking.Distance(center.Center); ok {
+			distance = fmt.Sprintf("%.1f km", km)
+		}
+		lastError := ""
+		if state.LastError != "" {
+			lastError = formatClock(state.LastErrorTime) + " " + state.LastError
+		}
+		style := ""
+		if i == d.selected {
+			style = ansiReverse
+		} else if lastError != "" && state.LastErrorTime.After(state.LastCheck.Add(-time.Second)) {
+			style = ansiRed
+		}
+		line(style, "  %-32s %-7s %-10s %-6d %-9s %s", fit(center.Center.String(), 32), pausedLabel,
+			formatClock(state.LastCheck), state.SlotsSeen, distance, lastError)
+	}
+	line("", "")
+
+	line(ansiBold, "BOOKINGS")
+	for _, booking := range bookings {
+		stages := make([]string, len(booking.Stages))
+		for i, stage := range booking.Stages {
+			stages[i] = stage.Name + " ok"
+			if stage.Error != "" {
+				stages[i] = stage.Name + " failed"
+			}
+		}
+		outcome, style := "in progress", ""
+		if booking.Done && booking.Error != "" {
+			outcome, style = "failed: "+booking.Error, ansiRed
+		} else if booking.Done {
+			outcome, style = "booked", ansiGreen
+		}
+		line(style, "  %s %s %s on %s: %s → %s", formatClock(booking.Started), booking.Worker, booking.Center,
+			booking.SlotStart, strings.Join(stages, " → "), outcome)
+	}
+	line("", "")
+
+	line(ansiBold, "SLOTS FOUND")
+	for i := 0; i < len(sightings) && i < 5; i++ {
+		sighting := sightings[i]
+		slotStart := sighting.SlotStart
+		if slotStart == "" {
+			slotStart = "none within the time window"
+		}
+		line("", "  %s %s %s: %d slot(s) for %s, first %s", formatClock(sighting.Time), sighting.Worker,
+			sighting.Center, sighting.Total, sighting.Motive, slotStart)
+	}
+	line("", "")
+
+	line(ansiBold, "LOGS")
+	for _, logLine := range logs {
+		line("", "  %s", logLine)
+	}
+
+	for lines < height-1 {
+		line("", "")
+	}
+	help := "↑/↓ select center  space pause/resume center  c check now  p pause/resume all  q quit"
+	if d.status != "" {
+		help = d.status + "  |  " + help
+	}
+	screen.WriteString(ansiReverse + fit(help, width) + ansiReset)
+
+	_, _ = io.WriteString(out, ansiClear+screen.String())
+}
+
+// run shows the dashboard until the stop channel is closed, then restores the terminal.
+func (d *dashboard) run(stop chan bool) error {
+	stdinFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdinFd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("main.dashboard.run(): the dashboard needs a terminal")
+	}
+	oldState, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		return fmt.Errorf("main.dashboard.run(): failed to set up terminal: %w", err)
+	}
+	_, _ = io.WriteString(os.Stdout, ansiAltScreen)
+	defer func() {
+		_, _ = io.WriteString(os.Stdout, ansiMainScreen)
+		_ = term.Restore(stdinFd, oldState)
+	}()
+
+	keys := make(chan string, 16)
+	go readKeys(keys)
+	ticker := time.NewTicker(dashboardRefreshPeriod)
+	defer ticker.Stop()
+
+	d.render(os.Stdout)
+	for {
+		select {
+		case key := <-keys:
+			d.handleKey(key)
+			d.render(os.Stdout)
+		case <-ticker.C:
+			d.render(os.Stdout)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func newDashboard(scheduler *govaccine.Scheduler, coordinator *govaccine.BookingCoordinator,
+	ranking *govaccine.CenterRanking, monitor *govaccine.Monitor, logs *logBuffer) *dashboard {
+	return &dashboard{
+		scheduler:   scheduler,
+		coordinator: coordinator,
+		ranking:     ranking,
+		monitor:     monitor,
+		logs:        logs,
+	}
+}
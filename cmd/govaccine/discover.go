@@ -0,0 +1,144 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type discoverArguments struct {
+	configArguments
+	city           string
+	postcode       string
+	radius         string
+	speciality     string
+	maxPages       int
+	outputFilepath string
+	format         string
+}
+
+func parseDiscoverArgs(flagSet *flag.FlagSet, args []string, discoverArgs *discoverArguments) error {
+	discoverArgs.registerConfigFlags(flagSet)
+	flagSet.StringVar(&discoverArgs.city, "city", "", "City around which to look for vaccination centers")
+	flagSet.StringVar(&discoverArgs.postcode, "postcode", "", "Postcode around which to look for vaccination centers")
+	flagSet.StringVar(&discoverArgs.radius, "radius", "",
+		"Only keep the vaccination centers within this distance of the city or postcode (e.g. 10km), all if empty")
+	flagSet.StringVar(&discoverArgs.speciality, "speciality", govaccine.VaccinationSpeciality,
+		"Doctolib speciality to search for")
+	flagSet.IntVar(&discoverArgs.maxPages, "pages", 10, "Maximum number of pages of search results to read")
+	flagSet.StringVar(&discoverArgs.outputFilepath, "o", "",
+		"Filepath of the vaccination centers file to write, the standard output if empty")
+	flagSet.StringVar(&discoverArgs.format, "format", "",
+		"Format of the vaccination centers file: \"text\", \"csv\" or \"json\" (default from the -o extension, "+
+			"\"text\" otherwise)")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if (discoverArgs.city == "") == (discoverArgs.postcode == "") {
+		return errors.New("exactly one of -city and -postcode is required")
+	}
+	if discoverArgs.maxPages < 1 {
+		return errors.New("-pages must be >= 1")
+	}
+	if discoverArgs.format == "" {
+		discoverArgs.format = strings.TrimPrefix(strings.ToLower(filepath.Ext(discoverArgs.outputFilepath)), ".")
+		if discoverArgs.format != "csv" && discoverArgs.format != "json" {
+			discoverArgs.format = "text"
+		}
+	}
+
+	return nil
+}
+
+func runDiscoverCommand(args []string) error {
+	flagSet := newFlagSet("discover", "Search Doctolib for the vaccination centers around a city or a postcode and "+
+		"write them as a vaccination centers file.")
+	var discoverArgs discoverArguments
+	if err := parseDiscoverArgs(flagSet, args, &discoverArgs); err != nil {
+		return err
+	}
+
+	config, err := discoverArgs.load(flagSet, nil)
+	if err != nil {
+		return err
+	}
+
+	query := &govaccine.DiscoveryQuery{
+		Speciality: discoverArgs.speciality,
+		Location:   discoverArgs.city + discoverArgs.postcode,
+		MaxPages:   discoverArgs.maxPages,
+		Sleep:      config.Scheduling.Sleep,
+	}
+	if discoverArgs.radius != "" {
+		if query.Radius, err = geo.ParseDistance(discoverArgs.radius); err != nil {
+			return err
+		}
+	}
+
+	doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout,
+		logging.Discard())
+	if err != nil {
+		return err
+	}
+	geocoder := geo.NewGeocoder(config.GeocodingUrl, config.Scheduling.RequestsTimeout)
+
+	startTime := time.Now()
+	centers, err := govaccine.DiscoverVaccinationCenters(doctolibClient, geocoder, query)
+	if err != nil {
+		return err
+	}
+	if len(centers) == 0 {
+		return fmt.Errorf("no vaccination center found around %s", query.Location)
+	}
+
+	output := os.Stdout
+	if discoverArgs.outputFilepath != "" {
+		if output, err = os.Create(discoverArgs.outputFilepath); err != nil {
+			return fmt.Errorf("main.runDiscoverCommand(): cannot create file %s: %s", discoverArgs.outputFilepath,
+				err)
+		}
+		defer func() {
+			_ = output.Close()
+		}()
+	}
+	if err := govaccine.WriteVaccinationCenters(output, centers, discoverArgs.format); err != nil {
+		return fmt.Errorf("main.runDiscoverCommand(): cannot write vaccination centers: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Found %d vaccination centers around %s in %s\n", len(centers), query.Location,
+		time.Since(startTime).Round(time.Millisecond))
+
+	return nil
+}
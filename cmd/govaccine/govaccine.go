@@ -24,156 +24,78 @@
 package main
 
 import (
-	"bufio"
-	"errors"
 	"flag"
 	"fmt"
-	"github.com/GuiTeK/govaccine/internal/app/govaccine"
-	"github.com/GuiTeK/govaccine/internal/pkg/utils"
-	"io"
 	"os"
 	"strings"
-	"sync"
-	"time"
 )
 
-func getVaccinationCenters(vaccinationCentersFilepath string) ([]string, error) {
-	file, err := os.Open(vaccinationCentersFilepath)
-	if err != nil {
-		return nil, fmt.Errorf("main.getVaccinationCenters(): failed to open file %s: %s",
-			vaccinationCentersFilepath, err)
-	}
-	defer func() {
-		_ = file.Close()
-	}()
-
-	var vaccinationCenters []string
-	reader := bufio.NewReader(file)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				return nil, fmt.Errorf(
-					"main.getVaccinationCenters(): encountered error while reading file %s: %s",
-					vaccinationCentersFilepath, err)
-			}
-
-			break
-		}
-
-		line = strings.Replace(line, "https://", "", -1)
-		line = strings.Replace(line, "http://", "", -1)
-		line = strings.Replace(line, "www.doctolib.fr/", "", -1)
-		line = strings.Replace(line, "doctolib.fr/", "", -1)
-		line = strings.Split(line, "?")[0]
-		urlParts := strings.Split(line, "/")
-
-		if len(urlParts) == 3 {
-			vaccinationCenterName := strings.Replace(strings.Replace(urlParts[2], "\r", "", -1),
-				"\n", "", -1)
-			vaccinationCenters = append(vaccinationCenters, vaccinationCenterName)
-		}
-	}
-
-	if len(vaccinationCenters) == 0 {
-		return nil, fmt.Errorf("main.getVaccinationCenters(): no vaccination center URL found in file %s",
-			vaccinationCentersFilepath)
-	}
-
-	return vaccinationCenters, nil
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
 }
 
-func parseArgs(doctolibUsername *string, doctolibPassword *string, vaccinationCentersFilepath *string,
-	workersNb *uint, sleepTime *uint, requestsTimeout *uint) error {
-	flag.StringVar(doctolibUsername, "u", "", "Doctolib username (email)")
-	flag.StringVar(doctolibPassword, "p", "", "Doctolib password")
-	flag.StringVar(vaccinationCentersFilepath, "f", "",
-		"Filepath of a file containing the URLs of the desired vaccination centers (1 URL per line)")
-	flag.UintVar(workersNb, "w", 4, "Number of workers checking for appointments concurrently")
-	flag.UintVar(sleepTime, "s", 1,
-		"Number of seconds between each appointment check for a single worker")
-	flag.UintVar(requestsTimeout, "t", 5, "Number of seconds after which a request times out")
-
-	flag.Parse()
-
-	if *doctolibUsername == "" {
-		return errors.New("Doctolib username (-u flag) is required")
-	}
+// commands are listed in this order by the usage. The first one is run when no command is given.
+var commands = []command{
+	{"book", "Check the vaccination centers and book an appointment for every patient", runBookCommand},
+	{"watch", "Check the vaccination centers and report the slots found without booking them", runWatchCommand},
+	{"validate", "Check that every vaccination center can be booked, without logging in", runValidateCommand},
+	{"login-test", "Log in with every account and check the configured patients", runLoginTestCommand},
+	{"appointments", "Show the appointments of the patients of every account", runAppointmentsCommand},
+	{"cancel", "Cancel an upcoming appointment and its second shot", runCancelCommand},
+	{"discover", "Search Doctolib for the vaccination centers around a city or a postcode", runDiscoverCommand},
+	{"history", "Show statistics on the slots seen", runHistoryCommand},
+	{"audit", "Show the booking attempts recorded in the audit log", runAuditCommand},
+}
 
-	if *doctolibPassword == "" {
-		return errors.New("Doctolib password (-p flag) is required")
+// newFlagSet creates the flag set of a command, whose help shows description and the flags.
+func newFlagSet(name string, description string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet("govaccine "+name, flag.ContinueOnError)
+	flagSet.Usage = func() {
+		_, _ = fmt.Fprintf(flagSet.Output(), "Usage: govaccine %s [FLAGS]\n\n%s\n\nFlags:\n", name, description)
+		flagSet.PrintDefaults()
 	}
 
-	if *vaccinationCentersFilepath == "" {
-		return errors.New("Vaccination centers filepath (-f flag) is required")
-	}
+	return flagSet
+}
 
-	if *workersNb == 0 || *workersNb > 16 {
-		return errors.New("number of workers should be >= 0 and <= 16")
+func printUsage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage: govaccine [COMMAND] [FLAGS]\n\nCommands:\n")
+	for _, command := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-14s %s\n", command.name, command.summary)
 	}
-
-	return nil
+	_, _ = fmt.Fprintf(os.Stderr, "\nThe %s command is run if none is given. Run \"govaccine COMMAND -h\" for "+
+		"the flags of a command.\n", commands[0].name)
 }
 
 func main() {
-	var doctolibUsername string
-	var doctolibPassword string
-	var vaccinationCentersFilepath string
-	var workersNb uint
-	var sleepTime uint
-	var requestsTimeout uint
-
-	if err := parseArgs(&doctolibUsername, &doctolibPassword, &vaccinationCentersFilepath, &workersNb, &sleepTime,
-		&requestsTimeout); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	vaccinationCenters, err := getVaccinationCenters(vaccinationCentersFilepath)
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "[ERROR] failed to read vaccination centers: %s\n", err)
-		os.Exit(1)
-	}
-
-	sleepTimeDuration := time.Duration(sleepTime) * time.Second
-	requestsTimeoutDuration := time.Duration(requestsTimeout) * time.Second
-	stop := make(chan bool)
-	mutex := &sync.Mutex{}
-	jobs := make(chan string, workersNb)
-	waitGroup := &sync.WaitGroup{}
-	for i := uint(0); i < workersNb; i++ {
-		botName := fmt.Sprintf("Worker %d", i+1)
-		vaccibot, err := govaccine.NewVaccibot(botName, doctolibUsername, doctolibPassword, jobs, stop, mutex,
-			sleepTimeDuration, requestsTimeoutDuration)
-		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "[ERROR] failed to create Vaccibot \"%s\": %s\n", botName, err)
-			os.Exit(1)
+	// Without a command, the flags are those of the first one, as before commands existed
+	run, args := commands[0].run, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name := args[0]
+		run, args = nil, args[1:]
+		for _, command := range commands {
+			if command.name == name {
+				run = command.run
+			}
 		}
 
-		waitGroup.Add(1)
-		go func(v *govaccine.Vaccibot) {
-			defer waitGroup.Done()
-			vaccibot.TryBookVaccine()
-		}(vaccibot)
-	}
-
-	i := 0
-	for {
-		if utils.IsBoolChannelClosed(stop) {
-			fmt.Printf("[INFO] Vaccibot orchestrator received stop signal\n")
-			close(jobs)
-			break
+		if run == nil && name == "help" {
+			printUsage()
+			return
 		}
-
-		if i == len(vaccinationCenters) {
-			i = 0
+		if run == nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Unknown command \"%s\"\n\n", name)
+			printUsage()
+			os.Exit(2)
 		}
-		jobs <- vaccinationCenters[i]
-
-		i = i + 1
 	}
 
-	fmt.Println("[INFO] Shutting down...")
-	waitGroup.Wait()
+	if err := run(args); err != nil {
+		if err != flag.ErrHelp {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,121 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type historyArguments struct {
+	historyFilepath string
+	center          string
+	since           time.Duration
+}
+
+// heatmapLevels are used to draw the time-of-day heatmap, from no release to the busiest hour.
+const heatmapLevels = " .:-=+*#%@"
+
+func parseHistoryArgs(args []string, historyArgs *historyArguments) error {
+	flagSet := newFlagSet("history", "Show statistics on the slots recorded in the history store: how many, how "+
+		"long they last and when they are released.")
+	flagSet.StringVar(&historyArgs.historyFilepath, "H", govaccine.DefaultHistoryFilepath, "Filepath of the history store")
+	flagSet.StringVar(&historyArgs.center, "c", "", "Only report vaccination centers starting with this name")
+	flagSet.DurationVar(&historyArgs.since, "since", 0,
+		"Only take into account the slots seen during this period (e.g. 168h), all of them if 0")
+
+	return flagSet.Parse(args)
+}
+
+func formatHeatmap(hourlyReleases [24]int) string {
+	maxReleases := 0
+	for _, releases := range hourlyReleases {
+		if releases > maxReleases {
+			maxReleases = releases
+		}
+	}
+
+	var heatmap strings.Builder
+	for _, releases := range hourlyReleases {
+		level := 0
+		if maxReleases > 0 {
+			level = (releases*(len(heatmapLevels)-1) + maxReleases - 1) / maxReleases
+		}
+		heatmap.WriteByte(heatmapLevels[level])
+	}
+
+	return heatmap.String()
+}
+
+func printHistoryReport(w io.Writer, report *govaccine.HistoryReport) {
+	if len(report.Centers) == 0 {
+		_, _ = fmt.Fprintln(w, "No slot recorded")
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "Slots seen from %s to %s\n\n", report.From.Local().Format("2006-01-02 15:04"),
+		report.To.Local().Format("2006-01-02 15:04"))
+	_, _ = fmt.Fprintf(w, "%-60s %7s %9s %12s  %s\n", "CENTER", "SLOTS", "PER DAY", "AVG LIFETIME",
+		"RELEASES BY HOUR (0h-23h)")
+	for _, center := range report.Centers {
+		_, _ = fmt.Fprintf(w, "%-60s %7d %9.1f %12s  |%s|\n", center.Center, center.SlotsNb, center.ReleasesPerDay,
+			center.AverageLifetime.Round(time.Second), formatHeatmap(center.HourlyReleases))
+	}
+}
+
+func runHistoryCommand(args []string) error {
+	var historyArgs historyArguments
+	if err := parseHistoryArgs(args, &historyArgs); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(historyArgs.historyFilepath); err != nil {
+		return fmt.Errorf("main.runHistoryCommand(): cannot read history store %s: %s", historyArgs.historyFilepath,
+			err)
+	}
+	history, err := govaccine.NewHistoryStore(historyArgs.historyFilepath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = history.Close()
+	}()
+
+	var since time.Time
+	if historyArgs.since > 0 {
+		since = time.Now().Add(-historyArgs.since)
+	}
+	observations, err := history.Observations(since, historyArgs.center)
+	if err != nil {
+		return err
+	}
+
+	printHistoryReport(os.Stdout, govaccine.BuildHistoryReport(observations))
+
+	return nil
+}
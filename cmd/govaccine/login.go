@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"io"
+	"os"
+)
+
+type loginTestArguments struct {
+	configArguments
+}
+
+func parseLoginTestArgs(flagSet *flag.FlagSet, args []string, loginTestArgs *loginTestArguments) error {
+	loginTestArgs.registerConfigFlags(flagSet)
+	loginTestArgs.registerAccountFlags(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if loginTestArgs.configFilepath == "" && loginTestArgs.doctolibUsername == "" {
+		return fmt.Errorf("either a configuration file (-c flag) or a Doctolib username (-u flag) is required")
+	}
+
+	return loginTestArgs.check()
+}
+
+func printAccountReport(w io.Writer, report *govaccine.AccountReport) {
+	if report.Err != nil {
+		_, _ = fmt.Fprintf(w, "%s: ERROR\n  Error: %s\n", report.Username, report.Err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "%s: logged in as %s (user %d)\n", report.Username, report.FullName, report.UserId)
+
+	_, _ = fmt.Fprintf(w, "  Patients of the account:\n")
+	for _, masterPatient := range report.MasterPatients {
+		_, _ = fmt.Fprintf(w, "    %d %s %s (born %s)\n", masterPatient.Id, masterPatient.FirstName,
+			masterPatient.LastName, masterPatient.Birthdate)
+	}
+	_, _ = fmt.Fprintf(w, "  Configured patients:\n")
+	for _, match := range report.PatientMatches {
+		if match.MasterPatient == nil {
+			_, _ = fmt.Fprintf(w, "    %s: NOT FOUND\n", &match.Patient)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "    %s: %d %s %s\n", &match.Patient, match.MasterPatient.Id,
+			match.MasterPatient.FirstName, match.MasterPatient.LastName)
+	}
+}
+
+func runLoginTestCommand(args []string) error {
+	flagSet := newFlagSet("login-test", "Log in with every Doctolib account and check that each configured patient "+
+		"is one of the patients of its account. Nothing is booked.")
+	var loginTestArgs loginTestArguments
+	if err := parseLoginTestArgs(flagSet, args, &loginTestArgs); err != nil {
+		return err
+	}
+
+	config, err := loginTestArgs.load(flagSet, nil)
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateWithoutCenters(); err != nil {
+		return err
+	}
+	if err := loginTestArgs.loadCredentials(config); err != nil {
+		return err
+	}
+	defer config.WipeCredentials()
+
+	failedAccountsNb := 0
+	for i := range config.Accounts {
+		if i > 0 {
+			_, _ = fmt.Fprintln(os.Stdout)
+		}
+
+		// Each account gets its own client, hence its own session
+		doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout,
+			logging.Discard())
+		if err != nil {
+			return err
+		}
+		report := govaccine.CheckAccount(doctolibClient, &config.Accounts[i])
+		printAccountReport(os.Stdout, report)
+		if report.Err != nil {
+			failedAccountsNb++
+			continue
+		}
+		for _, match := range report.PatientMatches {
+			if match.MasterPatient == nil {
+				failedAccountsNb++
+				break
+			}
+		}
+	}
+
+	if failedAccountsNb > 0 {
+		return fmt.Errorf("%d of %d Doctolib accounts can't be used", failedAccountsNb, len(config.Accounts))
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"github.com/GuiTeK/govaccine/internal/pkg/metrics"
+	"golang.org/x/term"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// controlShutdownTimeout is the time given to the requests to the control API to complete once the search ended.
+const controlShutdownTimeout = 5 * time.Second
+
+// searchArguments are the flags of the watch and book commands.
+type searchArguments struct {
+	configArguments
+	workersNb         uint
+	sleepTime         uint
+	dryRun            bool
+	notifiersFilepath string
+	metricsAddress    string
+	auditLogFilepath  string
+	historyFilepath   string
+	dashboard         bool
+}
+
+func parseSearchArgs(flagSet *flag.FlagSet, args []string, searchArgs *searchArguments, book bool) error {
+	searchArgs.registerConfigFlags(flagSet)
+	searchArgs.registerCentersFlag(flagSet)
+	searchArgs.registerAccountFlags(flagSet)
+	searchArgs.registerLoggingFlags(flagSet)
+	flagSet.UintVar(&searchArgs.workersNb, "w", 4, "Initial number of workers checking for appointments concurrently")
+	flagSet.UintVar(&searchArgs.sleepTime, "s", 1,
+		"Number of seconds between each appointment check for a single worker")
+	flagSet.StringVar(&searchArgs.notifiersFilepath, "n", "",
+		"Filepath of a JSON file describing the notification sinks (webhook, email, command, desktop)")
+	flagSet.StringVar(&searchArgs.historyFilepath, "H", govaccine.DefaultHistoryFilepath,
+		"Filepath of the history store recording every slot seen, disabled if empty")
+	flagSet.StringVar(&searchArgs.metricsAddress, "m", "",
+		"Address on which to expose Prometheus metrics on /metrics (e.g. \":9090\"), disabled if empty")
+	flagSet.BoolVar(&searchArgs.dashboard, "i", false,
+		"Interactive dashboard: show the workers, centers, bookings and slots found instead of the logs")
+	if book {
+		flagSet.BoolVar(&searchArgs.dryRun, "d", false,
+			"Dry run: go through the whole booking process but release the appointment instead of confirming it")
+		flagSet.StringVar(&searchArgs.auditLogFilepath, "a", govaccine.DefaultAuditLogFilepath,
+			"Filepath of the JSONL audit log recording every booking attempt, disabled if empty")
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if err := searchArgs.checkRequired(); err != nil {
+		return err
+	}
+
+	return searchArgs.check()
+}
+
+// loadConfig loads the configuration of the search, overridden by the flags explicitly set on flagSet.
+func (a *searchArguments) loadConfig(flagSet *flag.FlagSet) (*govaccine.Config, error) {
+	config, err := a.load(flagSet, func(config *govaccine.Config, flagName string) error {
+		switch flagName {
+		case "w":
+			config.Scheduling.Workers = a.workersNb
+		case "s":
+			config.Scheduling.Sleep = time.Duration(a.sleepTime) * time.Second
+		case "d":
+			config.DryRun = a.dryRun
+		case "n":
+			notifierConfigs, err := govaccine.LoadNotifierConfigs(a.notifiersFilepath)
+			if err != nil {
+				return err
+			}
+			config.Notifications = notifierConfigs
+		case "a":
+			config.Audit.File = a.auditLogFilepath
+		case "H":
+			config.History.File = a.historyFilepath
+		case "m":
+			config.Metrics.Address = a.metricsAddress
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// serveControlApi serves the control API until the returned server is shut down.
+func serveControlApi(address string, handler http.Handler, logger *slog.Logger) *http.Server {
+	server := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		logger.Info("Serving control API", "address", address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Control API server stopped", logging.ErrorKey, err)
+		}
+	}()
+
+	return server
+}
+
+func serveMetrics(address string, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	go func() {
+		logger.Info("Serving metrics", "address", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.Error("Metrics server stopped", logging.ErrorKey, err)
+		}
+	}()
+}
+
+// runSearch checks the vaccination centers until every patient got an appointment, or forever if the configuration
+// is watch only.
+func runSearch(flagSet *flag.FlagSet, searchArgs *searchArguments, config *govaccine.Config) error {
+	vaccinationCenters, err := govaccine.LoadVaccinationCenters(config)
+	if err != nil {
+		return err
+	}
+
+	if err := searchArgs.loadCredentials(config); err != nil {
+		return err
+	}
+	defer config.WipeCredentials()
+
+	var logOutput io.Writer = os.Stderr
+	var dashboardLogs *logBuffer
+	if searchArgs.dashboard {
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+			return fmt.Errorf("the dashboard (-i flag) needs a terminal")
+		}
+		dashboardLogs = &logBuffer{}
+		logOutput = dashboardLogs
+	}
+	logger, err := newLogger(config, logOutput)
+	if err != nil {
+		return err
+	}
+
+	notifications, err := govaccine.NewNotifications(config.Notifications, logger)
+	if err != nil {
+		return fmt.Errorf("invalid notifiers configuration: %w", err)
+	}
+
+	var auditLog *govaccine.AuditLog
+	if config.Audit.File != "" && !config.WatchOnly {
+		auditLog, err = govaccine.NewAuditLog(config.Audit.File)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer func() {
+			_ = auditLog.Close()
+		}()
+	}
+
+	var history *govaccine.HistoryStore
+	if config.History.File != "" {
+		history, err = govaccine.NewHistoryStore(config.History.File)
+		if err != nil {
+			return fmt.Errorf("failed to open history store: %w", err)
+		}
+		defer func() {
+			if err := history.Close(); err != nil {
+				logger.Warn("Failed to write the last slots seen to the history store", logging.ErrorKey, err)
+			}
+		}()
+	}
+
+	homeLocation, err := config.Home.Locate(geo.NewGeocoder(config.GeocodingUrl, config.Scheduling.RequestsTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to locate home: %w", err)
+	}
+	if homeLocation != nil {
+		logger.Info("Located home", "home", homeLocation.Label, "coordinates", homeLocation.Coordinates.String())
+	} else {
+		for _, center := range vaccinationCenters {
+			if center.MaxDistance > 0 {
+				logger.Warn("Ignoring the maximum distance of vaccination centers as home isn't configured",
+					logging.CenterKey, center.Name)
+				break
+			}
+		}
+	}
+	ranking := govaccine.NewCenterRanking(config)
+	ranking.Sort(vaccinationCenters)
+
+	stop := make(chan bool)
+	coordinator := govaccine.NewBookingCoordinator(config.Accounts, stop)
+	go coordinator.Run()
+	arbiter := govaccine.NewArbiter(config, coordinator, stop, logger)
+	go arbiter.Run()
+	scheduler := govaccine.NewScheduler(vaccinationCenters, config, ranking, stop, logger)
+	metrics.NewGaugeFunc("govaccine_jobs_queue_depth", "Number of vaccination centers waiting to be checked",
+		func() float64 {
+			return float64(len(scheduler.Jobs()))
+		})
+	if config.Metrics.Address != "" {
+		serveMetrics(config.Metrics.Address, logger)
+	}
+
+	monitor := govaccine.NewMonitor()
+	if config.Control.Address != "" {
+		controlServer := serveControlApi(config.Control.Address,
+			govaccine.NewControlApi(config, scheduler, coordinator, ranking, monitor).Handler(), logger)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+			defer cancel()
+			_ = controlServer.Shutdown(ctx)
+		}()
+	}
+
+	pool := govaccine.NewWorkerPool(config.Accounts, coordinator.PendingPatients,
+		func(id int, account *govaccine.AccountConfig) (govaccine.Worker, error) {
+			return govaccine.NewVaccibot(fmt.Sprintf("Worker %d", id), account, config, scheduler, stop, coordinator,
+				ranking, arbiter, monitor, notifications, auditLog, history, logger)
+		})
+	for i := uint(0); i < config.Scheduling.Workers; i++ {
+		if err := pool.Grow(); err != nil {
+			return err
+		}
+	}
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	reloader := govaccine.NewReloader(searchArgs.configFilepath, config, func() (*govaccine.Config, error) {
+		reloadedConfig, err := searchArgs.loadConfig(flagSet)
+		if reloadedConfig != nil {
+			reloadedConfig.WatchOnly = config.WatchOnly
+		}
+		return reloadedConfig, err
+	}, scheduler, arbiter, notifications, stop, logger)
+	go reloader.Run(reloads, config.Reload.WatchInterval)
+
+	dashboardDone := make(chan struct{})
+	if searchArgs.dashboard {
+		go func() {
+			defer close(dashboardDone)
+			err := newDashboard(scheduler, coordinator, ranking, monitor, dashboardLogs).run(stop)
+			dashboardLogs.detach(os.Stderr)
+			if err != nil {
+				logger.Error("Dashboard stopped", logging.ErrorKey, err)
+			}
+		}()
+	} else {
+		close(dashboardDone)
+	}
+
+	scheduler.Run(pool)
+	<-dashboardDone // Restores the terminal
+
+	logger.Info("Shutting down...")
+	pool.Wait()
+	notifications.Wait()
+
+	return nil
+}
+
+func runBookCommand(args []string) error {
+	flagSet := newFlagSet("book",
+		"Check the vaccination centers and book an appointment for every patient as soon as possible.")
+	var searchArgs searchArguments
+	if err := parseSearchArgs(flagSet, args, &searchArgs, true); err != nil {
+		return err
+	}
+	config, err := searchArgs.loadConfig(flagSet)
+	if err != nil {
+		return err
+	}
+
+	return runSearch(flagSet, &searchArgs, config)
+}
+
+func runWatchCommand(args []string) error {
+	flagSet := newFlagSet("watch",
+		"Check the vaccination centers and report the slots found (logs, notifications, history) without booking "+
+			"them.")
+	var searchArgs searchArguments
+	if err := parseSearchArgs(flagSet, args, &searchArgs, false); err != nil {
+		return err
+	}
+	config, err := searchArgs.loadConfig(flagSet)
+	if err != nil {
+		return err
+	}
+	config.WatchOnly = true
+
+	return runSearch(flagSet, &searchArgs, config)
+}
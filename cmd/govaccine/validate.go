@@ -0,0 +1,157 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/app/govaccine"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type validateArguments struct {
+	configArguments
+}
+
+func parseValidateArgs(flagSet *flag.FlagSet, args []string, validateArgs *validateArguments) error {
+	validateArgs.registerConfigFlags(flagSet)
+	validateArgs.registerCentersFlag(flagSet)
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if validateArgs.configFilepath == "" && validateArgs.vaccinationCentersFilepath == "" {
+		return fmt.Errorf("either a configuration file (-c flag) or a vaccination centers file (-f flag) is required")
+	}
+
+	return nil
+}
+
+func formatIds(ids []int) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+
+	formattedIds := make([]string, len(ids))
+	for i, id := range ids {
+		formattedIds[i] = fmt.Sprint(id)
+	}
+
+	return strings.Join(formattedIds, ", ")
+}
+
+func printCenterReport(w io.Writer, report *govaccine.CenterReport) {
+	status := "OK"
+	if report.Problem != "" {
+		status = "NEVER BOOKABLE: " + report.Problem
+	} else if report.Err != nil {
+		status = "ERROR"
+	}
+	_, _ = fmt.Fprintf(w, "%s (%s): %s\n", report.Center, report.Center.Url, status)
+
+	if report.StatusCode != 0 {
+		_, _ = fmt.Fprintf(w, "  HTTP status:      %d\n", report.StatusCode)
+	}
+	if report.Err != nil {
+		_, _ = fmt.Fprintf(w, "  Error:            %s\n", report.Err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "  Profile ID:       %d\n", report.ProfileId)
+	if len(report.VisitMotives) == 0 {
+		_, _ = fmt.Fprintf(w, "  Visit motives:    none matching\n")
+	}
+	for i, visitMotive := range report.VisitMotives {
+		label := "  Visit motives:   "
+		if i > 0 {
+			label = "                  "
+		}
+		booked := ""
+		if i == 0 {
+			booked = " (booked)"
+		}
+		_, _ = fmt.Fprintf(w, "%s %d \"%s\"%s\n", label, visitMotive.Id, visitMotive.Name, booked)
+	}
+	if len(report.VisitMotives) > 0 {
+		_, _ = fmt.Fprintf(w, "  Enabled agendas:  %s\n", formatIds(report.EnabledAgendaIds))
+		_, _ = fmt.Fprintf(w, "  Disabled agendas: %s\n", formatIds(report.DisabledAgendaIds))
+		_, _ = fmt.Fprintf(w, "  Practice IDs:     %s\n", formatIds(report.PracticeIds))
+	}
+}
+
+func runValidateCommand(args []string) error {
+	flagSet := newFlagSet("validate", "Check that every vaccination center can be booked, without logging in.")
+	var validateArgs validateArguments
+	if err := parseValidateArgs(flagSet, args, &validateArgs); err != nil {
+		return err
+	}
+
+	config, err := validateArgs.load(flagSet, nil)
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateWithoutAccounts(); err != nil {
+		return err
+	}
+	centers, err := govaccine.LoadVaccinationCenters(config)
+	if err != nil {
+		return err
+	}
+
+	doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout,
+		logging.Discard())
+	if err != nil {
+		return err
+	}
+
+	unbookableCentersNb := 0
+	failedCentersNb := 0
+	for i, center := range centers {
+		if i > 0 {
+			time.Sleep(config.Scheduling.Sleep)
+			_, _ = fmt.Fprintln(os.Stdout)
+		}
+
+		report := govaccine.CheckVaccinationCenter(doctolibClient, config, center)
+		printCenterReport(os.Stdout, report)
+		if report.Problem != "" {
+			unbookableCentersNb++
+		} else if report.Err != nil {
+			failedCentersNb++
+		}
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "\n%d vaccination centers: %d OK, %d never bookable, %d errors\n", len(centers),
+		len(centers)-unbookableCentersNb-failedCentersNb, unbookableCentersNb, failedCentersNb)
+	if unbookableCentersNb > 0 || failedCentersNb > 0 {
+		return fmt.Errorf("%d of %d vaccination centers can't be booked", unbookableCentersNb+failedCentersNb,
+			len(centers))
+	}
+
+	return nil
+}
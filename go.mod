@@ -1,3 +1,11 @@
 module github.com/GuiTeK/govaccine
 
-go 1.16
+go 1.22
+
+require (
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/term v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.4.0 // indirect
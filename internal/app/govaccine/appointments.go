@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"net/http"
+)
+
+// PatientAppointments are the appointments of a patient of a Doctolib account.
+type PatientAppointments struct {
+	MasterPatient doctolib.MasterPatient
+	Upcoming      []doctolib.Appointment
+	Past          []doctolib.Appointment
+}
+
+// AccountSession is a Doctolib session of an account, to manage the appointments of its patients.
+type AccountSession struct {
+	doctolibClient *doctolib.Client
+	csrfToken      string
+}
+
+// PatientsAppointments lists the appointments of each patient of the Doctolib account.
+func (s *AccountSession) PatientsAppointments() ([]PatientAppointments, error) {
+	masterPatientsResponse, err := s.doctolibClient.GetMasterPatients(s.csrfToken)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.PatientsAppointments(): failed to get patients: %w", err)
+	}
+	s.csrfToken = masterPatientsResponse.CsrfToken
+
+	var patientsAppointments []PatientAppointments
+	for _, masterPatient := range masterPatientsResponse.MasterPatients {
+		appointmentsResponse, err := s.doctolibClient.ListAppointments(masterPatient.Id, s.csrfToken)
+		if err != nil {
+			return nil, fmt.Errorf("govaccine.PatientsAppointments(): failed to list appointments of patient %d: %w",
+				masterPatient.Id, err)
+		}
+		s.csrfToken = appointmentsResponse.CsrfToken
+
+		patientsAppointments = append(patientsAppointments, PatientAppointments{
+			MasterPatient: masterPatient,
+			Upcoming:      appointmentsResponse.Upcoming,
+			Past:          appointmentsResponse.Past,
+		})
+	}
+
+	return patientsAppointments, nil
+}
+
+// CancellationError reports an appointment canceled while the appointment linked to it wasn't.
+type CancellationError struct {
+	CanceledId string
+	LinkedId   string
+	Err        error
+}
+
+func (e *CancellationError) Error() string {
+	return fmt.Sprintf("appointment (ID %s) canceled but not its linked appointment (ID %s): %s", e.CanceledId,
+		e.LinkedId, e.Err)
+}
+
+func (e *CancellationError) Unwrap() error {
+	return e.Err
+}
+
+// CancelAppointment cancels appointment, then the appointment linked to it (e.g. its second shot), if any. If only
+// appointment was canceled, the error is a *CancellationError.
+func (s *AccountSession) CancelAppointment(appointment *doctolib.Appointment) error {
+	var err error
+	s.csrfToken, err = cancelAppointment(s.doctolibClient, appointment, s.csrfToken)
+
+	return err
+}
+
+// cancelAppointment cancels appointment and the appointment linked to it, and returns the new CSRF token. The linked
+// appointment isn't canceled if appointment couldn't be. A linked appointment not found is considered canceled along
+// with appointment.
+func cancelAppointment(doctolibClient *doctolib.Client, appointment *doctolib.Appointment,
+	csrfToken string) (string, error) {
+	cancelResponse, err := doctolibClient.CancelAppointment(appointment.Id, csrfToken)
+	if err != nil {
+		return csrfToken, fmt.Errorf("govaccine.cancelAppointment(): failed to cancel appointment (ID %s): %w",
+			appointment.Id, err)
+	}
+	csrfToken = cancelResponse.CsrfToken
+
+	if appointment.LinkedAppointmentId == "" {
+		return csrfToken, nil
+	}
+	cancelResponse, err = doctolibClient.CancelAppointment(appointment.LinkedAppointmentId, csrfToken)
+	var statusErr *doctolib.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return csrfToken, nil
+	}
+	if err != nil {
+		return csrfToken, fmt.Errorf("govaccine.cancelAppointment(): %w",
+			&CancellationError{CanceledId: appointment.Id, LinkedId: appointment.LinkedAppointmentId, Err: err})
+	}
+
+	return cancelResponse.CsrfToken, nil
+}
+
+// NewAccountSession logs in with account, whose credentials must be loaded.
+func NewAccountSession(doctolibClient *doctolib.Client, account *AccountConfig) (*AccountSession, error) {
+	loginResponse, err := doctolibClient.Login(account.Username, account.secret.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.NewAccountSession(): failed to login: %w", err)
+	}
+
+	return &AccountSession{doctolibClient: doctolibClient, csrfToken: loginResponse.CsrfToken}, nil
+}
@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDoctolibClient returns a Doctolib client sending its requests to a stand-in server handled by handler.
+func newTestDoctolibClient(t *testing.T, handler http.HandlerFunc) *doctolib.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := doctolib.NewClient(server.URL, 5*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestPatientsAppointments(t *testing.T) {
+	var requests []string
+	client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI()+" "+r.Header.Get("x-csrf-token"))
+		switch r.URL.Path {
+		case "/account/master_patients.json":
+			w.Header().Set("x-csrf-token", "token-patients")
+			_, _ = io.WriteString(w, `[{"id": 1, "first_name": "Alice"}, {"id": 2, "first_name": "Bob"}]`)
+		case "/account/appointments.json":
+			masterPatientId := r.URL.Query().Get("master_patient_id")
+			w.Header().Set("x-csrf-token", "token-"+masterPatientId)
+			_, _ = io.WriteString(w, `{"upcoming": [{"id": "u`+masterPatientId+`"}], "past": [{"id": "p`+
+				masterPatientId+`"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	session := &AccountSession{doctolibClient: client, csrfToken: "token"}
+
+	patientsAppointments, err := session.PatientsAppointments()
+	if err != nil {
+		t.Fatalf("PatientsAppointments() error = %v", err)
+	}
+	wantRequests := []string{
+		"/account/master_patients.json token",
+		"/account/appointments.json?master_patient_id=1 token-patients",
+		"/account/appointments.json?master_patient_id=2 token-1",
+	}
+	if !slices.Equal(requests, wantRequests) {
+		t.Errorf("got requests %q, want %q", requests, wantRequests)
+	}
+	if len(patientsAppointments) != 2 {
+		t.Fatalf("PatientsAppointments() = %+v, want the appointments of 2 patients", patientsAppointments)
+	}
+	for i, patientAppointments := range patientsAppointments {
+		id := strconv.Itoa(i + 1)
+		if strconv.Itoa(patientAppointments.MasterPatient.Id) != id || len(patientAppointments.Upcoming) != 1 ||
+			patientAppointments.Upcoming[0].Id != "u"+id || len(patientAppointments.Past) != 1 ||
+			patientAppointments.Past[0].Id != "p"+id {
+			t.Errorf("unexpected appointments of patient %s: %+v", id, patientAppointments)
+		}
+	}
+	if session.csrfToken != "token-2" {
+		t.Errorf("session CSRF token = %q, want %q", session.csrfToken, "token-2")
+	}
+}
+
+func TestPatientsAppointmentsError(t *testing.T) {
+	client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-csrf-token", "new-token")
+		if r.URL.Path == "/account/appointments.json" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, `[{"id": 1, "first_name": "Alice"}]`)
+	})
+	session := &AccountSession{doctolibClient: client, csrfToken: "token"}
+
+	_, err := session.PatientsAppointments()
+	var statusError *doctolib.StatusError
+	if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusForbidden {
+		t.Errorf("PatientsAppointments() error = %v, want a StatusError with status 403", err)
+	}
+}
+
+func TestCancelAppointment(t *testing.T) {
+	tests := []struct {
+		name          string
+		appointment   doctolib.Appointment
+		statusCodes   map[string]int
+		wantRequests  []string
+		wantCsrfToken string
+		wantErr       bool
+		wantPartial   bool
+	}{
+		{
+			name:          "appointment and linked appointment",
+			appointment:   doctolib.Appointment{Id: "1", LinkedAppointmentId: "2"},
+			wantRequests:  []string{"DELETE /appointments/1.json token", "DELETE /appointments/2.json token-1"},
+			wantCsrfToken: "token-2",
+		},
+		{
+			name:          "no linked appointment",
+			appointment:   doctolib.Appointment{Id: "1"},
+			wantRequests:  []string{"DELETE /appointments/1.json token"},
+			wantCsrfToken: "token-1",
+		},
+		{
+			name:          "linked appointment canceled along",
+			appointment:   doctolib.Appointment{Id: "1", LinkedAppointmentId: "2"},
+			statusCodes:   map[string]int{"2": http.StatusNotFound},
+			wantRequests:  []string{"DELETE /appointments/1.json token", "DELETE /appointments/2.json token-1"},
+			wantCsrfToken: "token-1",
+		},
+		{
+			name:          "linked appointment not canceled",
+			appointment:   doctolib.Appointment{Id: "1", LinkedAppointmentId: "2"},
+			statusCodes:   map[string]int{"2": http.StatusInternalServerError},
+			wantRequests:  []string{"DELETE /appointments/1.json token", "DELETE /appointments/2.json token-1"},
+			wantCsrfToken: "token-1",
+			wantErr:       true,
+			wantPartial:   true,
+		},
+		{
+			name:          "appointment not canceled",
+			appointment:   doctolib.Appointment{Id: "1", LinkedAppointmentId: "2"},
+			statusCodes:   map[string]int{"1": http.StatusForbidden},
+			wantRequests:  []string{"DELETE /appointments/1.json token"},
+			wantCsrfToken: "token",
+			wantErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []string
+			client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("x-csrf-token"))
+				appointmentId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/appointments/"), ".json")
+				if statusCode, ok := test.statusCodes[appointmentId]; ok {
+					w.WriteHeader(statusCode)
+					return
+				}
+				w.Header().Set("x-csrf-token", "token-"+appointmentId)
+			})
+
+			csrfToken, err := cancelAppointment(client, &test.appointment, "token")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("cancelAppointment() error = %v, want an error: %t", err, test.wantErr)
+			}
+			var cancellationErr *CancellationError
+			if errors.As(err, &cancellationErr) != test.wantPartial {
+				t.Errorf("cancelAppointment() error = %v, want a CancellationError: %t", err, test.wantPartial)
+			}
+			if test.wantPartial && (cancellationErr.CanceledId != "1" || cancellationErr.LinkedId != "2") {
+				t.Errorf("unexpected CancellationError %+v", cancellationErr)
+			}
+			if csrfToken != test.wantCsrfToken {
+				t.Errorf("got CSRF token %q, want %q", csrfToken, test.wantCsrfToken)
+			}
+			if !slices.Equal(requests, test.wantRequests) {
+				t.Errorf("got requests %q, want %q", requests, test.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Arbitration preferences, used to compare candidate slots.
+const (
+	PreferPriority = "priority" // Center with the highest priority
+	PreferMotive   = "motive"   // Visit motive matched by the first motive selector
+	PreferDistance = "distance" // Center closest to home
+	PreferDate     = "date"     // Earliest slot
+)
+
+// Candidate is an available slot found by a worker, waiting for the arbiter's decision.
+type Candidate struct {
+	Center *VaccinationCenter
+	Worker string
+	// Username is the username of the account of Worker, which books the slot if picked
+	Username  string
+	SlotStart time.Time
+	// Distance is the distance (in km) between home and the center, -1 if unknown
+	Distance float64
+	// MotiveRank is the index of the motive selector matching the visit motive of the slot
+	MotiveRank int
+	decision   chan bool
+}
+
+// Arbiter collects the slots found by all the workers during a short window, then lets only the best one be booked.
+// Only the candidates of accounts with patients still waiting for an appointment can be picked.
+type Arbiter struct {
+	mutex       sync.RWMutex
+	window      time.Duration
+	preferences []string
+	coordinator *BookingCoordinator
+	candidates  chan *Candidate
+	stop        chan bool
+	logger      *slog.Logger
+}
+
+var validPreferences = map[string]bool{
+	PreferPriority: true,
+	PreferMotive:   true,
+	PreferDistance: true,
+	PreferDate:     true,
+}
+
+// compare returns a negative number if x is better than y, a positive one if y is better than x, and 0 if they are
+// equally good. If knownOnly is true, the distance is ignored unless known for both.
+func (a *Arbiter) compare(x *Candidate, y *Candidate, knownOnly bool) float64 {
+	a.mutex.RLock()
+	preferences := a.preferences
+	a.mutex.RUnlock()
+
+	for _, preference := range preferences {
+		var difference float64
+		switch preference {
+		case PreferPriority:
+			if x.Center != nil && y.Center != nil {
+				difference = float64(y.Center.Priority - x.Center.Priority)
+			}
+		case PreferMotive:
+			difference = float64(x.MotiveRank - y.MotiveRank)
+		case PreferDistance:
+			switch {
+			case x.Distance >= 0 && y.Distance >= 0:
+				difference = x.Distance - y.Distance
+			case knownOnly:
+			case x.Distance >= 0:
+				difference = -1 // Known distances first
+			case y.Distance >= 0:
+				difference = 1
+			}
+		case PreferDate:
+			difference = float64(x.SlotStart.Sub(y.SlotStart))
+		}
+
+		if difference != 0 {
+			return difference
+		}
+	}
+
+	return 0
+}
+
+func (a *Arbiter) decide(candidates []*Candidate) {
+	// A candidate whose account can't book anything would waste the slot
+	pending, err := a.coordinator.PendingPatients()
+	if err != nil {
+		pending = nil
+	}
+	var best *Candidate
+	for _, candidate := range candidates {
+		if len(pending[candidate.Username]) == 0 {
+			continue
+		}
+		if best == nil || a.compare(candidate, best, false) < 0 {
+			best = candidate
+		}
+	}
+
+	for _, candidate := range candidates {
+		won := candidate == best
+		if won {
+			arbitrationCandidatesTotal.Inc("won")
+		} else {
+			arbitrationCandidatesTotal.Inc("lost")
+		}
+		candidate.decision <- won
+	}
+	if best != nil && len(candidates) > 1 {
+		a.logger.Info("Picked the best of the slots found", logging.CenterKey, best.Center.Name,
+			logging.WorkerKey, best.Worker, "start_date", best.SlotStart, "candidates", len(candidates))
+	}
+}
+
+// Improves reports whether candidate is strictly better than held, an appointment already booked. The preferences
+// unknown for held (e.g. the distance of a center which isn't configured) are ignored.
+func (a *Arbiter) Improves(candidate *Candidate, held *Candidate) bool {
+	return a.compare(candidate, held, true) < 0
+}
+
+// Submit proposes candidate and waits for the end of the arbitration window. It returns true if the candidate was
+// picked, in which case it must be booked.
+func (a *Arbiter) Submit(candidate *Candidate) bool {
+	candidate.decision = make(chan bool, 1)
+	select {
+	case a.candidates <- candidate:
+	case <-a.stop:
+		return false
+	}
+
+	select {
+	case won := <-candidate.decision:
+		return won
+	case <-a.stop:
+		return false
+	}
+}
+
+// Update applies the window and preferences of config from the next arbitration on.
+func (a *Arbiter) Update(config *ArbitrationConfig) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.window = config.Window
+	a.preferences = config.Prefer
+}
+
+// Run arbitrates between the candidates until stop is closed. A window opens with the first candidate, the best
+// candidate submitted until it closes wins.
+func (a *Arbiter) Run() {
+	var candidates []*Candidate
+	var windowEnd <-chan time.Time
+	for {
+		select {
+		case candidate := <-a.candidates:
+			candidates = append(candidates, candidate)
+			a.mutex.RLock()
+			window := a.window
+			a.mutex.RUnlock()
+			if window == 0 {
+				a.decide(candidates)
+				candidates = nil
+			} else if windowEnd == nil {
+				windowEnd = time.After(window)
+			}
+		case <-windowEnd:
+			a.decide(candidates)
+			candidates = nil
+			windowEnd = nil
+		case <-a.stop:
+			for _, candidate := range candidates {
+				candidate.decision <- false
+			}
+			return
+		}
+	}
+}
+
+func NewArbiter(config *Config, coordinator *BookingCoordinator, stop chan bool, logger *slog.Logger) *Arbiter {
+	return &Arbiter{
+		window:      config.Arbitration.Window,
+		preferences: config.Arbitration.Prefer,
+		coordinator: coordinator,
+		candidates:  make(chan *Candidate),
+		stop:        stop,
+		logger:      logger,
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testDay = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestArbiterCompare(t *testing.T) {
+	high := &VaccinationCenter{Name: "high", Priority: 2}
+	low := &VaccinationCenter{Name: "low", Priority: 1}
+	tests := []struct {
+		name        string
+		preferences []string
+		x           Candidate
+		y           Candidate
+		want        int // -1 if x is better, 1 if y is better, 0 if equal
+	}{
+		{
+			name:        "priority",
+			preferences: []string{PreferPriority, PreferDate},
+			x:           Candidate{Center: high, SlotStart: testDay.Add(time.Hour)},
+			y:           Candidate{Center: low, SlotStart: testDay},
+			want:        -1,
+		},
+		{
+			name:        "date before priority",
+			preferences: []string{PreferDate, PreferPriority},
+			x:           Candidate{Center: high, SlotStart: testDay.Add(time.Hour)},
+			y:           Candidate{Center: low, SlotStart: testDay},
+			want:        1,
+		},
+		{
+			name:        "motive",
+			preferences: []string{PreferMotive, PreferDate},
+			x:           Candidate{MotiveRank: 0, SlotStart: testDay.Add(time.Hour)},
+			y:           Candidate{MotiveRank: 1, SlotStart: testDay},
+			want:        -1,
+		},
+		{
+			name:        "distance",
+			preferences: []string{PreferDistance},
+			x:           Candidate{Distance: 12},
+			y:           Candidate{Distance: 3.5},
+			want:        1,
+		},
+		{
+			name:        "known distance first",
+			preferences: []string{PreferDistance, PreferDate},
+			x:           Candidate{Distance: 40, SlotStart: testDay.Add(time.Hour)},
+			y:           Candidate{Distance: -1, SlotStart: testDay},
+			want:        -1,
+		},
+		{
+			name:        "next preference on a tie",
+			preferences: []string{PreferPriority, PreferDistance, PreferDate},
+			x:           Candidate{Center: low, Distance: 5, SlotStart: testDay},
+			y:           Candidate{Center: low, Distance: 5, SlotStart: testDay.Add(time.Minute)},
+			want:        -1,
+		},
+		{
+			name:        "equal",
+			preferences: []string{PreferPriority, PreferMotive, PreferDistance, PreferDate},
+			x:           Candidate{Center: low, Distance: 5, SlotStart: testDay},
+			y:           Candidate{Center: low, Distance: 5, SlotStart: testDay},
+			want:        0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			arbiter := &Arbiter{preferences: test.preferences}
+			got := arbiter.compare(&test.x, &test.y, false)
+			if (test.want < 0 && got >= 0) || (test.want > 0 && got <= 0) || (test.want == 0 && got != 0) {
+				t.Errorf("compare() = %v, want the sign of %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestArbiterImproves(t *testing.T) {
+	arbiter := &Arbiter{preferences: []string{PreferDistance, PreferDate}}
+	held := &Candidate{SlotStart: testDay, Distance: -1}
+
+	// The distance of the held appointment is unknown, only the date counts
+	if !arbiter.Improves(&Candidate{SlotStart: testDay.Add(-time.Hour), Distance: 50}, held) {
+		t.Error("an earlier slot doesn't improve the appointment held")
+	}
+	if arbiter.Improves(&Candidate{SlotStart: testDay, Distance: 1}, held) {
+		t.Error("a slot at the same date improves the appointment held")
+	}
+}
+
+// submitAll submits candidates at once and returns the ones picked.
+func submitAll(arbiter *Arbiter, candidates []*Candidate) []*Candidate {
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	var picked []*Candidate
+	for _, candidate := range candidates {
+		waitGroup.Add(1)
+		go func(candidate *Candidate) {
+			defer waitGroup.Done()
+			if arbiter.Submit(candidate) {
+				mutex.Lock()
+				picked = append(picked, candidate)
+				mutex.Unlock()
+			}
+		}(candidate)
+	}
+	waitGroup.Wait()
+
+	return picked
+}
+
+func startArbiter(coordinator *BookingCoordinator, stop chan bool, preferences []string) *Arbiter {
+	arbiter := NewArbiter(&Config{Arbitration: ArbitrationConfig{Window: 200 * time.Millisecond, Prefer: preferences}},
+		coordinator, stop, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go arbiter.Run()
+
+	return arbiter
+}
+
+func TestArbiterPicksBest(t *testing.T) {
+	coordinator, stop := startCoordinator(t, []AccountConfig{{Username: "a@example.com"}})
+	arbiter := startArbiter(coordinator, stop, []string{PreferPriority, PreferDistance, PreferDate})
+	center := &VaccinationCenter{Name: "center"}
+	candidates := []*Candidate{
+		{Center: center, Worker: "Worker 1", Username: "a@example.com", Distance: 10, SlotStart: testDay},
+		{Center: center, Worker: "Worker 2", Username: "a@example.com", Distance: 2, SlotStart: testDay.Add(time.Hour)},
+		{Center: center, Worker: "Worker 3", Username: "a@example.com", Distance: 2, SlotStart: testDay},
+	}
+
+	picked := submitAll(arbiter, candidates)
+	if len(picked) != 1 || picked[0].Worker != "Worker 3" {
+		t.Errorf("picked %+v, want Worker 3 only", picked)
+	}
+}
+
+func TestArbiterSkipsAccountsWithoutPendingPatient(t *testing.T) {
+	alice := PatientConfig{FirstName: "Alice", LastName: "Martin"}
+	bob := PatientConfig{FirstName: "Bob", LastName: "Martin"}
+	coordinator, stop := startCoordinator(t, []AccountConfig{
+		{Username: "a@example.com", Patients: []PatientConfig{alice}},
+		{Username: "b@example.com", Patients: []PatientConfig{bob}},
+	})
+	if err := coordinator.StopSearch("a@example.com", alice); err != nil {
+		t.Fatal(err)
+	}
+	arbiter := startArbiter(coordinator, stop, []string{PreferDate})
+
+	center := &VaccinationCenter{Name: "center"}
+	// The best slot was found by a worker of an account which has nobody left to book for
+	candidates := []*Candidate{
+		{Center: center, Worker: "Worker 1", Username: "a@example.com", Distance: -1, SlotStart: testDay},
+		{
+			Center:    center,
+			Worker:    "Worker 2",
+			Username:  "b@example.com",
+			Distance:  -1,
+			SlotStart: testDay.Add(time.Hour),
+		},
+	}
+	picked := submitAll(arbiter, candidates)
+	if len(picked) != 1 || picked[0].Worker != "Worker 2" {
+		t.Errorf("picked %+v, want Worker 2 only", picked)
+	}
+
+	// Nobody wins if no account can book
+	if err := coordinator.StopSearch("b@example.com", bob); err != nil {
+		t.Fatal(err)
+	}
+	if arbiter.Submit(&Candidate{Center: center, Worker: "Worker 2", Username: "b@example.com", SlotStart: testDay}) {
+		t.Error("a candidate was picked after every patient got an appointment")
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuditEvent string
+
+const (
+	AuditRunStarted   AuditEvent = "run_started"
+	AuditApiCall      AuditEvent = "api_call"
+	AuditRunSucceeded AuditEvent = "run_succeeded"
+	AuditRunDryRun    AuditEvent = "run_dry_run"
+	AuditRunFailed    AuditEvent = "run_failed"
+)
+
+type AuditCall struct {
+	RequestId  string          `json:"request_id"`
+	Endpoint   string          `json:"endpoint"`
+	Method     string          `json:"method"`
+	Url        string          `json:"url"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	StatusCode int             `json:"status_code"`
+	DurationMs int64           `json:"duration_ms"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// AuditRecord is a line of the audit log. A booking run is made of a run_started record, the api_call records of
+// all the requests sent to Doctolib during the run, and a final run_succeeded, run_dry_run or run_failed record.
+type AuditRecord struct {
+	Time           time.Time  `json:"time"`
+	RunId          string     `json:"run_id"`
+	Event          AuditEvent `json:"event"`
+	Worker         string     `json:"worker"`
+	Center         string     `json:"center"`
+	ProfileId      int        `json:"profile_id,omitempty"`
+	VisitMotiveIds []int      `json:"visit_motive_ids,omitempty"`
+	AgendaIds      []int      `json:"agenda_ids,omitempty"`
+	PracticeIds    []int      `json:"practice_ids,omitempty"`
+	Slot           string     `json:"slot,omitempty"`
+	AppointmentId  string     `json:"appointment_id,omitempty"`
+	Call           *AuditCall `json:"call,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only JSONL file recording every booking run. It is shared by all the Vaccibots.
+type AuditLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+const redactedValue = "[REDACTED]"
+
+// auditRedactedKeys are the payload keys whose values are personal data or secrets.
+var auditRedactedKeys = map[string]bool{
+	"username":     true,
+	"password":     true,
+	"first_name":   true,
+	"last_name":    true,
+	"maiden_name":  true,
+	"birthdate":    true,
+	"email":        true,
+	"phone_number": true,
+	"address":      true,
+	"zipcode":      true,
+	"city":         true,
+	// Custom fields and qualification answers hold personal and health data (e.g. social security number, age)
+	"custom_fields_values":  true,
+	"qualification_answers": true,
+}
+
+func redactValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, nestedValue := range typedValue {
+			if auditRedactedKeys[key] && nestedValue != nil {
+				typedValue[key] = redactedValue
+			} else {
+				typedValue[key] = redactValue(nestedValue)
+			}
+		}
+	case []interface{}:
+		for i, nestedValue := range typedValue {
+			typedValue[i] = redactValue(nestedValue)
+		}
+	}
+
+	return value
+}
+
+// redactPayload replaces personal data and secrets in a JSON payload. Non-JSON payloads are dropped entirely.
+func redactPayload(payload []byte) json.RawMessage {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return json.RawMessage(fmt.Sprintf("\"%s\"", redactedValue))
+	}
+
+	redactedPayload, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return json.RawMessage(fmt.Sprintf("\"%s\"", redactedValue))
+	}
+
+	return redactedPayload
+}
+
+func newRunId() string {
+	runIdBytes := make([]byte, 6)
+	_, _ = rand.Read(runIdBytes)
+
+	return hex.EncodeToString(runIdBytes)
+}
+
+func (a *AuditLog) Write(record *AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.encoder.Encode(record); err != nil {
+		return fmt.Errorf("govaccine.AuditLog.Write(): cannot write record to %s: %w", a.file.Name(), err)
+	}
+
+	return nil
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.file.Close()
+}
+
+func newAuditCall(call *doctolib.Call) *AuditCall {
+	auditCall := &AuditCall{
+		RequestId:  call.RequestId,
+		Endpoint:   call.Endpoint,
+		Method:     call.Method,
+		Url:        call.Url,
+		Payload:    redactPayload(call.RequestBody),
+		StatusCode: call.StatusCode,
+		DurationMs: call.Duration.Milliseconds(),
+	}
+	if call.Err != nil {
+		auditCall.Error = call.Err.Error()
+	}
+
+	return auditCall
+}
+
+func NewAuditLog(auditLogFilepath string) (*AuditLog, error) {
+	file, err := os.OpenFile(auditLogFilepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.NewAuditLog(): failed to open file %s: %w", auditLogFilepath, err)
+	}
+
+	return &AuditLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// ReadAuditRecords reads the records of an audit log, skipping the ones filter rejects (if not nil).
+func ReadAuditRecords(reader io.Reader, filter func(record *AuditRecord) bool) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNb := 1; scanner.Scan(); lineNb++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("govaccine.ReadAuditRecords(): invalid record on line %d: %w", lineNb, err)
+		}
+
+		if filter == nil || filter(&record) {
+			records = append(records, record)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("govaccine.ReadAuditRecords(): failed to read audit log: %w", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,125 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"encoding/json"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{name: "empty", payload: "", want: ""},
+		{name: "not JSON", payload: "username=a&password=b", want: `"[REDACTED]"`},
+		{
+			name:    "nothing to redact",
+			payload: `{"profile_id":7,"start_date":"2021-06-02"}`,
+			want:    `{"profile_id":7,"start_date":"2021-06-02"}`,
+		},
+		{
+			name:    "top-level keys",
+			payload: `{"username":"a@example.com","password":"secret","kind":"patient"}`,
+			want:    `{"kind":"patient","password":"[REDACTED]","username":"[REDACTED]"}`,
+		},
+		{
+			name:    "null values are kept",
+			payload: `{"maiden_name":null,"email":""}`,
+			want:    `{"email":"[REDACTED]","maiden_name":null}`,
+		},
+		{
+			name:    "nested maps",
+			payload: `{"appointment":{"id":1,"patient":{"first_name":"Alice","last_name":"Martin","zipcode":"75014"}}}`,
+			want: `{"appointment":{"id":1,"patient":{"first_name":"[REDACTED]","last_name":"[REDACTED]",` +
+				`"zipcode":"[REDACTED]"}}}`,
+		},
+		{
+			name:    "arrays of maps",
+			payload: `{"master_patients":[{"id":1,"birthdate":"1990-01-01"},{"id":2,"phone_number":"0601020304"}]}`,
+			want:    `{"master_patients":[{"birthdate":"[REDACTED]","id":1},{"id":2,"phone_number":"[REDACTED]"}]}`,
+		},
+		{
+			name:    "top-level array",
+			payload: `[{"city":"Paris"},[{"address":"1 rue de Rivoli"}],3]`,
+			want:    `[{"city":"[REDACTED]"},[{"address":"[REDACTED]"}],3]`,
+		},
+		{
+			name:    "redacted objects and arrays",
+			payload: `{"custom_fields_values":{"ssn":"1900175"},"qualification_answers":[{"answer":"yes"}]}`,
+			want:    `{"custom_fields_values":"[REDACTED]","qualification_answers":"[REDACTED]"}`,
+		},
+		{name: "keys are case sensitive", payload: `{"Email":"a@example.com"}`, want: `{"Email":"a@example.com"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(redactPayload([]byte(test.payload))); got != test.want {
+				t.Errorf("redactPayload(%s) = %s, want %s", test.payload, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuditCallLogin(t *testing.T) {
+	client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-csrf-token", "token")
+		if r.URL.Path == "/login.json" {
+			_, _ = io.WriteString(w, `{"id":1,"full_name":"Alice Martin"}`)
+		}
+	})
+	var calls []*AuditCall
+	client.SetCallObserver(func(call *doctolib.Call) {
+		calls = append(calls, newAuditCall(call))
+	})
+
+	if _, err := client.Login("a@example.com", []byte("secret")); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want the sessions_new and login calls", len(calls))
+	}
+	login := calls[1]
+	if login.Endpoint != "login" || login.Method != http.MethodPost {
+		t.Errorf("got call %s %s, want POST login", login.Method, login.Endpoint)
+	}
+	if login.Payload != nil {
+		t.Errorf("login call recorded the payload %s", login.Payload)
+	}
+	recordBytes, err := json.Marshal(&AuditRecord{Event: AuditApiCall, Call: login})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret", "a@example.com", "payload"} {
+		if strings.Contains(string(recordBytes), secret) {
+			t.Errorf("audit record %s contains %q", recordBytes, secret)
+		}
+	}
+}
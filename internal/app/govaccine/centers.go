@@ -0,0 +1,396 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VaccinationCenter is a vaccination center to check, along with its optional metadata.
+type VaccinationCenter struct {
+	// Name identifies the center in the Doctolib API (last part of its URL)
+	Name  string `json:"-"`
+	Url   string `json:"url"`
+	Label string `json:"label,omitempty"`
+	// Priority orders the centers: the higher the priority, the sooner the center is checked
+	Priority int `json:"priority,omitempty"`
+	// PreferredMotive is the name of the visit motive to book first at this center, before the configured ones
+	PreferredMotive string `json:"preferred_motive,omitempty"`
+	// MaxDistance is the maximum acceptable distance (in km) between the home and this center, 0 if unlimited
+	MaxDistance float64 `json:"max_distance,omitempty"`
+	Address     string  `json:"address,omitempty"`
+	geo.Coordinates
+}
+
+const centerUrlExample = "https://www.doctolib.fr/SPECIALITY/CITY/CENTER"
+
+func (c *VaccinationCenter) String() string {
+	if c.Label != "" {
+		return c.Label
+	}
+
+	return c.Name
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// doctolibLocales are the locale prefixes of the Doctolib websites (e.g. "en" in doctolib.de/en/...).
+var doctolibLocales = map[string]bool{"de": true, "en": true, "es": true, "fr": true, "it": true, "nl": true}
+
+// isLocale reports whether an URL path segment is a locale prefix.
+func isLocale(segment string) bool {
+	return doctolibLocales[strings.ToLower(segment)]
+}
+
+// ParseVaccinationCenterUrl extracts the name of a vaccination center from its URL on the Doctolib website at
+// rootUrl. Query strings, fragments, trailing slashes and locale prefixes are ignored.
+func ParseVaccinationCenterUrl(centerUrl string, rootUrl string) (string, error) {
+	if !strings.Contains(centerUrl, "://") {
+		centerUrl = "https://" + centerUrl
+	}
+	parsedUrl, err := url.Parse(centerUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %s", err)
+	}
+	parsedRootUrl, err := url.Parse(rootUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid Doctolib URL %s: %s", rootUrl, err)
+	}
+
+	if normalizeHost(parsedUrl.Host) != normalizeHost(parsedRootUrl.Host) {
+		return "", fmt.Errorf("host %s doesn't match the Doctolib website %s (see doctolib_url)", parsedUrl.Host,
+			parsedRootUrl.Host)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(parsedUrl.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 4 && isLocale(segments[0]) {
+		segments = segments[1:]
+	}
+	if len(segments) != 3 {
+		return "", fmt.Errorf("unexpected path %s (expected a center URL such as %s)", parsedUrl.Path,
+			centerUrlExample)
+	}
+
+	return segments[2], nil
+}
+
+// centersFileErrors gathers the problems found in a centers file, each one prefixed with its location.
+type centersFileErrors struct {
+	filepath string
+	problems []string
+}
+
+func (e *centersFileErrors) add(location string, format string, a ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf("%s:%s: %s", e.filepath, location, fmt.Sprintf(format, a...)))
+}
+
+func (e *centersFileErrors) err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(e.problems, "\n  "))
+}
+
+func newVaccinationCenter(center VaccinationCenter, rootUrl string) (*VaccinationCenter, error) {
+	name, err := ParseVaccinationCenterUrl(center.Url, rootUrl)
+	if err != nil {
+		return nil, err
+	}
+	if center.MaxDistance < 0 {
+		return nil, errors.New("max_distance must be >= 0")
+	}
+	center.Name = name
+
+	return &center, nil
+}
+
+// readTextCenters reads one URL per line. Blank lines and comments (starting with "#") are ignored.
+func readTextCenters(reader io.Reader, rootUrl string, fileErrors *centersFileErrors) ([]*VaccinationCenter, error) {
+	var centers []*VaccinationCenter
+	scanner := bufio.NewScanner(reader)
+	for lineNb := 1; scanner.Scan(); lineNb++ {
+		line := strings.TrimSpace(scanner.Text())
+		// "#" is only a comment at the beginning of a line or after a blank, URLs may contain fragments
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		center, err := newVaccinationCenter(VaccinationCenter{Url: line}, rootUrl)
+		if err != nil {
+			fileErrors.add(strconv.Itoa(lineNb), "%s", err)
+			continue
+		}
+		centers = append(centers, center)
+	}
+
+	return centers, scanner.Err()
+}
+
+// readCsvCenters reads a CSV file with a header line. The url column is required, the label, priority,
+// preferred_motive, max_distance, address, latitude and longitude columns are optional.
+func readCsvCenters(reader io.Reader, rootUrl string, fileErrors *centersFileErrors) ([]*VaccinationCenter, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		switch column {
+		case "url", "label", "priority", "preferred_motive", "max_distance", "address", "latitude", "longitude":
+			columns[column] = i
+		default:
+			fileErrors.add("1", "unknown column \"%s\"", column)
+		}
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, errors.New("missing url column in header")
+	}
+
+	var centers []*VaccinationCenter
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				fileErrors.add(strconv.Itoa(parseErr.Line), "%s", parseErr.Err)
+				continue
+			}
+			return nil, err
+		}
+		line, _ := csvReader.FieldPos(0)
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		center := VaccinationCenter{
+			Url:             field("url"),
+			Label:           field("label"),
+			PreferredMotive: field("preferred_motive"),
+			Address:         field("address"),
+		}
+		if priority := field("priority"); priority != "" {
+			if center.Priority, err = strconv.Atoi(priority); err != nil {
+				fileErrors.add(strconv.Itoa(line), "invalid priority \"%s\"", priority)
+				continue
+			}
+		}
+		if maxDistance := field("max_distance"); maxDistance != "" {
+			if center.MaxDistance, err = strconv.ParseFloat(maxDistance, 64); err != nil {
+				fileErrors.add(strconv.Itoa(line), "invalid max_distance \"%s\"", maxDistance)
+				continue
+			}
+		}
+
+		if latitude, longitude := field("latitude"), field("longitude"); latitude != "" || longitude != "" {
+			if center.Coordinates, err = geo.ParseCoordinates(latitude + "," + longitude); err != nil {
+				fileErrors.add(strconv.Itoa(line), "invalid coordinates \"%s,%s\"", latitude, longitude)
+				continue
+			}
+		}
+
+		parsedCenter, err := newVaccinationCenter(center, rootUrl)
+		if err != nil {
+			fileErrors.add(strconv.Itoa(line), "%s", err)
+			continue
+		}
+		centers = append(centers, parsedCenter)
+	}
+
+	return centers, nil
+}
+
+// readJsonCenters reads a JSON array of centers.
+func readJsonCenters(reader io.Reader, rootUrl string, fileErrors *centersFileErrors) ([]*VaccinationCenter, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var jsonCenters []VaccinationCenter
+	if err := decoder.Decode(&jsonCenters); err != nil {
+		return nil, err
+	}
+
+	var centers []*VaccinationCenter
+	for i, jsonCenter := range jsonCenters {
+		center, err := newVaccinationCenter(jsonCenter, rootUrl)
+		if err != nil {
+			fileErrors.add(fmt.Sprintf("[%d]", i), "%s", err)
+			continue
+		}
+		centers = append(centers, center)
+	}
+
+	return centers, nil
+}
+
+// ReadVaccinationCentersFile reads the vaccination centers of a file. Its format depends on its extension:
+// ".csv", ".json", or one URL per line otherwise. Every invalid line is reported.
+func ReadVaccinationCentersFile(centersFilepath string, rootUrl string) ([]*VaccinationCenter, error) {
+	file, err := os.Open(centersFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.ReadVaccinationCentersFile(): failed to open file %s: %s",
+			centersFilepath, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	readCenters := readTextCenters
+	switch strings.ToLower(filepath.Ext(centersFilepath)) {
+	case ".csv":
+		readCenters = readCsvCenters
+	case ".json":
+		readCenters = readJsonCenters
+	}
+
+	fileErrors := &centersFileErrors{filepath: centersFilepath}
+	centers, err := readCenters(file, rootUrl, fileErrors)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.ReadVaccinationCentersFile(): failed to read file %s: %w",
+			centersFilepath, err)
+	}
+	if err := fileErrors.err(); err != nil {
+		return nil, fmt.Errorf("govaccine.ReadVaccinationCentersFile(): invalid vaccination centers:\n  %w", err)
+	}
+	if len(centers) == 0 {
+		return nil, fmt.Errorf("govaccine.ReadVaccinationCentersFile(): no vaccination center found in file %s",
+			centersFilepath)
+	}
+
+	return centers, nil
+}
+
+// LoadVaccinationCenters reads the configured vaccination centers, without duplicates and sorted by decreasing
+// priority.
+func LoadVaccinationCenters(config *Config) ([]*VaccinationCenter, error) {
+	var centers []*VaccinationCenter
+	for _, centersFilepath := range config.Centers.Files {
+		fileCenters, err := ReadVaccinationCentersFile(centersFilepath, config.DoctolibUrl)
+		if err != nil {
+			return nil, fmt.Errorf("govaccine.LoadVaccinationCenters(): %w", err)
+		}
+		centers = append(centers, fileCenters...)
+	}
+	for i, centerUrl := range config.Centers.Urls {
+		center, err := newVaccinationCenter(VaccinationCenter{Url: centerUrl}, config.DoctolibUrl)
+		if err != nil {
+			return nil, fmt.Errorf("govaccine.LoadVaccinationCenters(): centers.urls[%d]: %w", i, err)
+		}
+		centers = append(centers, center)
+	}
+
+	seenNames := make(map[string]bool)
+	uniqueCenters := centers[:0]
+	for _, center := range centers {
+		if !seenNames[center.Name] {
+			seenNames[center.Name] = true
+			uniqueCenters = append(uniqueCenters, center)
+		}
+	}
+	sort.SliceStable(uniqueCenters, func(i, j int) bool {
+		return uniqueCenters[i].Priority > uniqueCenters[j].Priority
+	})
+
+	return uniqueCenters, nil
+}
+
+// WriteVaccinationCenters writes centers in a file format read by ReadVaccinationCentersFile: "csv", "json" or
+// "text" (one URL per line, preceded by a comment describing the center).
+func WriteVaccinationCenters(w io.Writer, centers []*VaccinationCenter, format string) error {
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		_ = csvWriter.Write([]string{"url", "label", "priority", "preferred_motive", "max_distance", "address",
+			"latitude", "longitude"})
+		for _, center := range centers {
+			record := []string{center.Url, center.Label, "", center.PreferredMotive, "", center.Address, "", ""}
+			if center.Priority != 0 {
+				record[2] = strconv.Itoa(center.Priority)
+			}
+			if center.MaxDistance != 0 {
+				record[4] = strconv.FormatFloat(center.MaxDistance, 'f', -1, 64)
+			}
+			if !center.Coordinates.IsZero() {
+				record[6] = strconv.FormatFloat(center.Latitude, 'f', 6, 64)
+				record[7] = strconv.FormatFloat(center.Longitude, 'f', 6, 64)
+			}
+			_ = csvWriter.Write(record)
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(centers)
+	case "text":
+		for _, center := range centers {
+			description := []string{center.String()}
+			if center.Address != "" {
+				description = append(description, center.Address)
+			}
+			if !center.Coordinates.IsZero() {
+				description = append(description, center.Coordinates.String())
+			}
+			if _, err := fmt.Fprintf(w, "# %s\n%s\n", strings.Join(description, " - "), center.Url); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("govaccine.WriteVaccinationCenters(): unknown format \"%s\"", format)
+	}
+}
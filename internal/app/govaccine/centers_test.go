@@ -0,0 +1,379 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVaccinationCenterUrl(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		rootUrl  string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "plain", url: "https://www.doctolib.fr/centre-de-sante/paris/centre-a", wantName: "centre-a"},
+		{name: "without www", url: "https://doctolib.fr/centre-de-sante/paris/centre-a", wantName: "centre-a"},
+		{name: "without scheme", url: "www.doctolib.fr/centre-de-sante/paris/centre-a", wantName: "centre-a"},
+		{name: "upper case host", url: "https://WWW.Doctolib.FR/centre-de-sante/paris/centre-a", wantName: "centre-a"},
+		{name: "trailing slash", url: "https://www.doctolib.fr/centre-de-sante/paris/centre-a/", wantName: "centre-a"},
+		{
+			name:     "query string",
+			url:      "https://www.doctolib.fr/centre-de-sante/paris/centre-a?highlight[speciality_ids][]=5494",
+			wantName: "centre-a",
+		},
+		{name: "fragment", url: "https://www.doctolib.fr/centre-de-sante/paris/centre-a#booking", wantName: "centre-a"},
+		{name: "locale prefix", url: "https://www.doctolib.fr/en/centre-de-sante/paris/centre-a", wantName: "centre-a"},
+		{
+			name:     "upper case locale prefix",
+			url:      "https://www.doctolib.fr/EN/centre-de-sante/paris/centre-a",
+			wantName: "centre-a",
+		},
+		{
+			name:     "doctolib.de",
+			url:      "https://www.doctolib.de/en/impfung-covid-19-corona/berlin/impfzentrum-a",
+			rootUrl:  "https://www.doctolib.de",
+			wantName: "impfzentrum-a",
+		},
+		{
+			name:    "unknown locale prefix",
+			url:     "https://www.doctolib.fr/xx/centre-de-sante/paris/centre-a",
+			wantErr: true,
+		},
+		{
+			name:    "other Doctolib website",
+			url:     "https://www.doctolib.de/impfung-covid-19-corona/berlin/impfzentrum-a",
+			wantErr: true,
+		},
+		{name: "other host", url: "https://example.com/centre-de-sante/paris/centre-a", wantErr: true},
+		{name: "search page", url: "https://www.doctolib.fr/centre-de-sante/paris", wantErr: true},
+		{name: "too many segments", url: "https://www.doctolib.fr/a/centre-de-sante/paris/centre-a", wantErr: true},
+		{name: "invalid URL", url: "https://www.doctolib.fr/%zz", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootUrl := test.rootUrl
+			if rootUrl == "" {
+				rootUrl = "https://www.doctolib.fr"
+			}
+
+			name, err := ParseVaccinationCenterUrl(test.url, rootUrl)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseVaccinationCenterUrl() = %q, want an error", name)
+				}
+				return
+			}
+			if err != nil || name != test.wantName {
+				t.Errorf("ParseVaccinationCenterUrl() = %q, %v, want %q", name, err, test.wantName)
+			}
+		})
+	}
+}
+
+const testRootUrl = "https://www.doctolib.fr"
+
+func centerNames(centers []*VaccinationCenter) []string {
+	var names []string
+	for _, center := range centers {
+		names = append(names, center.Name)
+	}
+
+	return names
+}
+
+func TestReadTextCenters(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantNames    []string
+		wantProblems []string
+	}{
+		{
+			name: "comments and blank lines",
+			content: "# Paris\n\nhttps://www.doctolib.fr/centre-de-sante/paris/centre-a\n" +
+				"   \n  # indented comment\n" +
+				"https://www.doctolib.fr/centre-de-sante/paris/centre-b # closest one\n",
+			wantNames: []string{"centre-a", "centre-b"},
+		},
+		{
+			name:      "fragment isn't a comment",
+			content:   "https://www.doctolib.fr/centre-de-sante/paris/centre-a#booking\n",
+			wantNames: []string{"centre-a"},
+		},
+		{
+			name:      "no trailing newline",
+			content:   "https://www.doctolib.fr/centre-de-sante/paris/centre-a\nhttps://doctolib.fr/c/paris/centre-b",
+			wantNames: []string{"centre-a", "centre-b"},
+		},
+		{
+			name:      "CRLF line endings",
+			content:   "https://www.doctolib.fr/centre-de-sante/paris/centre-a\r\n# comment\r\n",
+			wantNames: []string{"centre-a"},
+		},
+		{
+			name: "every invalid line is reported",
+			content: "https://www.doctolib.fr/centre-de-sante/paris\n" +
+				"https://www.doctolib.fr/centre-de-sante/paris/centre-a\n" +
+				"https://example.com/centre-de-sante/paris/centre-b\n",
+			wantNames:    []string{"centre-a"},
+			wantProblems: []string{"centers.txt:1: unexpected path", "centers.txt:3: host example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileErrors := &centersFileErrors{filepath: "centers.txt"}
+			centers, err := readTextCenters(strings.NewReader(test.content), testRootUrl, fileErrors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := centerNames(centers); !reflect.DeepEqual(names, test.wantNames) {
+				t.Errorf("got centers %v, want %v", names, test.wantNames)
+			}
+			checkProblems(t, fileErrors, test.wantProblems)
+		})
+	}
+}
+
+func checkProblems(t *testing.T, fileErrors *centersFileErrors, wantProblems []string) {
+	t.Helper()
+	if len(fileErrors.problems) != len(wantProblems) {
+		t.Fatalf("got problems %q, want %q", fileErrors.problems, wantProblems)
+	}
+	for i, wantProblem := range wantProblems {
+		if !strings.HasPrefix(fileErrors.problems[i], wantProblem) {
+			t.Errorf("got problem %q, want it to start with %q", fileErrors.problems[i], wantProblem)
+		}
+	}
+}
+
+func TestReadCsvCenters(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantCenters  []VaccinationCenter
+		wantProblems []string
+		wantErr      bool
+	}{
+		{
+			name: "every column",
+			content: "url,label,priority,preferred_motive,max_distance,address,latitude,longitude\n" +
+				"https://www.doctolib.fr/c/paris/centre-a,Centre A,2,Pfizer,12.5,\"1 rue A, Paris\",48.85,2.35\n",
+			wantCenters: []VaccinationCenter{{
+				Name:            "centre-a",
+				Url:             "https://www.doctolib.fr/c/paris/centre-a",
+				Label:           "Centre A",
+				Priority:        2,
+				PreferredMotive: "Pfizer",
+				MaxDistance:     12.5,
+				Address:         "1 rue A, Paris",
+				Coordinates:     geo.Coordinates{Latitude: 48.85, Longitude: 2.35},
+			}},
+		},
+		{
+			name: "url column only, in any case, with comments",
+			content: "# centers\nURL\nhttps://www.doctolib.fr/c/paris/centre-a\n" +
+				"# next\nhttps://doctolib.fr/c/paris/centre-b",
+			wantCenters: []VaccinationCenter{
+				{Name: "centre-a", Url: "https://www.doctolib.fr/c/paris/centre-a"},
+				{Name: "centre-b", Url: "https://doctolib.fr/c/paris/centre-b"},
+			},
+		},
+		{
+			name: "columns in another order, missing trailing fields",
+			content: "label, url, priority\n" +
+				"Centre A, https://www.doctolib.fr/c/paris/centre-a\n",
+			wantCenters: []VaccinationCenter{
+				{Name: "centre-a", Url: "https://www.doctolib.fr/c/paris/centre-a", Label: "Centre A"},
+			},
+		},
+		{
+			name: "every invalid line is reported",
+			content: "url,priority,max_distance,latitude,longitude,rank\n" +
+				"https://www.doctolib.fr/c/paris/centre-a,high,,,\n" +
+				"https://www.doctolib.fr/c/paris/centre-b,,far,,\n" +
+				"https://www.doctolib.fr/c/paris/centre-c,,-1,,\n" +
+				"https://www.doctolib.fr/c/paris/centre-d,,,48.85,\n" +
+				"https://www.doctolib.fr/c/paris\n" +
+				"\"unterminated\n",
+			wantProblems: []string{
+				"centers.csv:1: unknown column \"rank\"",
+				"centers.csv:2: invalid priority \"high\"",
+				"centers.csv:3: invalid max_distance \"far\"",
+				"centers.csv:4: max_distance must be >= 0",
+				"centers.csv:5: invalid coordinates \"48.85,\"",
+				"centers.csv:6: unexpected path",
+				"centers.csv:7: extraneous or missing \" in quoted-field",
+			},
+		},
+		{name: "missing url column", content: "label,priority\nCentre A,1\n", wantErr: true},
+		{name: "empty file", content: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileErrors := &centersFileErrors{filepath: "centers.csv"}
+			centers, err := readCsvCenters(strings.NewReader(test.content), testRootUrl, fileErrors)
+			if test.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCenters(t, centers, test.wantCenters)
+			checkProblems(t, fileErrors, test.wantProblems)
+		})
+	}
+}
+
+func checkCenters(t *testing.T, centers []*VaccinationCenter, wantCenters []VaccinationCenter) {
+	t.Helper()
+	if len(centers) != len(wantCenters) {
+		t.Fatalf("got centers %v, want %v", centerNames(centers), wantCenters)
+	}
+	for i := range wantCenters {
+		if !reflect.DeepEqual(*centers[i], wantCenters[i]) {
+			t.Errorf("got center %+v, want %+v", *centers[i], wantCenters[i])
+		}
+	}
+}
+
+func TestReadJsonCenters(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantCenters  []VaccinationCenter
+		wantProblems []string
+		wantErr      bool
+	}{
+		{
+			name: "metadata",
+			content: `[
+				{"url": "https://www.doctolib.fr/c/paris/centre-a", "label": "Centre A", "priority": 2,
+					"preferred_motive": "Pfizer", "max_distance": 12.5, "address": "1 rue A",
+					"latitude": 48.85, "longitude": 2.35},
+				{"url": "https://www.doctolib.fr/en/c/paris/centre-b/"}
+			]`,
+			wantCenters: []VaccinationCenter{
+				{
+					Name:            "centre-a",
+					Url:             "https://www.doctolib.fr/c/paris/centre-a",
+					Label:           "Centre A",
+					Priority:        2,
+					PreferredMotive: "Pfizer",
+					MaxDistance:     12.5,
+					Address:         "1 rue A",
+					Coordinates:     geo.Coordinates{Latitude: 48.85, Longitude: 2.35},
+				},
+				{Name: "centre-b", Url: "https://www.doctolib.fr/en/c/paris/centre-b/"},
+			},
+		},
+		{
+			name: "every invalid center is reported",
+			content: `[
+				{"url": "https://www.doctolib.fr/c/paris"},
+				{"url": "https://www.doctolib.fr/c/paris/centre-a"},
+				{"url": "https://www.doctolib.fr/c/paris/centre-b", "max_distance": -1}
+			]`,
+			wantCenters:  []VaccinationCenter{{Name: "centre-a", Url: "https://www.doctolib.fr/c/paris/centre-a"}},
+			wantProblems: []string{"centers.json:[0]: unexpected path", "centers.json:[2]: max_distance must be >= 0"},
+		},
+		{name: "unknown field", content: `[{"url": "https://www.doctolib.fr/c/paris/a", "rank": 1}]`, wantErr: true},
+		{name: "not an array", content: `{"url": "https://www.doctolib.fr/c/paris/a"}`, wantErr: true},
+		{name: "invalid JSON", content: `[{"url": }]`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileErrors := &centersFileErrors{filepath: "centers.json"}
+			centers, err := readJsonCenters(strings.NewReader(test.content), testRootUrl, fileErrors)
+			if test.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCenters(t, centers, test.wantCenters)
+			checkProblems(t, fileErrors, test.wantProblems)
+		})
+	}
+}
+
+func TestReadVaccinationCentersFile(t *testing.T) {
+	tests := []struct {
+		filename  string
+		content   string
+		wantNames []string
+		wantErr   string
+	}{
+		{filename: "centers.txt", content: "https://www.doctolib.fr/c/paris/a\n", wantNames: []string{"a"}},
+		{filename: "centers", content: "https://www.doctolib.fr/c/paris/a", wantNames: []string{"a"}},
+		{filename: "centers.CSV", content: "url\nhttps://www.doctolib.fr/c/paris/a\n", wantNames: []string{"a"}},
+		{filename: "centers.json", content: `[{"url": "https://www.doctolib.fr/c/paris/a"}]`, wantNames: []string{"a"}},
+		{filename: "empty.txt", content: "# nothing yet\n", wantErr: "no vaccination center found"},
+		{
+			filename: "invalid.txt",
+			content:  "https://www.doctolib.fr/c/paris\nhttps://www.doctolib.fr/c\n",
+			wantErr:  "invalid.txt:1: unexpected path /c/paris",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			centersFilepath := filepath.Join(t.TempDir(), test.filename)
+			if err := os.WriteFile(centersFilepath, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			centers, err := ReadVaccinationCentersFile(centersFilepath, testRootUrl)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := centerNames(centers); !reflect.DeepEqual(names, test.wantNames) {
+				t.Errorf("got centers %v, want %v", names, test.wantNames)
+			}
+		})
+	}
+}
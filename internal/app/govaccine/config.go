@@ -0,0 +1,784 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type PatientConfig struct {
+	Id        int    `json:"id,omitempty" yaml:"id"`
+	FirstName string `json:"first_name,omitempty" yaml:"first_name"`
+	LastName  string `json:"last_name,omitempty" yaml:"last_name"`
+}
+
+type AccountConfig struct {
+	Username string `yaml:"username"`
+	// Password is the inline password, prefer PasswordSource
+	Password string `yaml:"password"`
+	// PasswordSource is where to read the password from: "env:NAME", "file:PATH", "command:COMMAND" or "prompt"
+	PasswordSource string `yaml:"password_source"`
+	// Patients are the patients of the account to book an appointment for. If empty, an appointment is booked
+	// for the first patient of the account.
+	Patients []PatientConfig `yaml:"patients"`
+	secret   *Secret
+}
+
+type CentersConfig struct {
+	// Files are filepaths of files containing vaccination centers (one URL per line, CSV or JSON), relative to the
+	// configuration file
+	Files []string `yaml:"files"`
+	Urls  []string `yaml:"urls"`
+}
+
+// MotiveSelector selects visit motives by exact name or by regular expression.
+type MotiveSelector struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	regexp  *regexp.Regexp
+}
+
+type TimeWindowConfig struct {
+	// StartAfterDays is the first day to look for slots, relative to today
+	StartAfterDays int `yaml:"start_after_days"`
+	// Days is the number of days to look for slots, starting from StartAfterDays
+	Days int `yaml:"days"`
+	// EarliestTime and LatestTime (HH:MM) bound the start time of acceptable slots
+	EarliestTime string   `yaml:"earliest_time"`
+	LatestTime   string   `yaml:"latest_time"`
+	Weekdays     []string `yaml:"weekdays"`
+	earliest     int
+	latest       int
+	weekdays     map[time.Weekday]bool
+}
+
+// HomeConfig locates home, by coordinates ("LATITUDE,LONGITUDE") or by address, to prefer the closest centers.
+type HomeConfig struct {
+	Coordinates string `yaml:"coordinates"`
+	Address     string `yaml:"address"`
+	// MaxDistance is the maximum distance (in km) between home and a center, 0 if unlimited
+	MaxDistance float64 `yaml:"max_distance"`
+	location    *geo.Coordinates
+}
+
+// ArbitrationConfig sets how long to wait for other slots once one was found, and how to pick the best one.
+type ArbitrationConfig struct {
+	Window time.Duration `yaml:"window"`
+	// Prefer lists the preferences by decreasing importance: "priority", "motive", "distance" and "date"
+	Prefer []string `yaml:"prefer"`
+}
+
+type RescheduleConfig struct {
+	// Enabled keeps looking for a better slot, by the arbitration preferences, for the patients who already hold an
+	// appointment instead of skipping them. The better slot is booked before the old appointment is canceled.
+	Enabled bool `yaml:"enabled"`
+}
+
+type UpgradeConfig struct {
+	// Enabled keeps looking for an earlier slot, at any center, for the patients who got an appointment. The earlier
+	// slot is booked before the appointment is canceled.
+	Enabled bool `yaml:"enabled"`
+	// MinImprovement is how much earlier than the appointment a slot must be to replace it
+	MinImprovement time.Duration `yaml:"min_improvement"`
+}
+
+// SecondDoseConfig books only the second dose, for the patients who got the first one elsewhere. The slots are
+// searched between the minimum and maximum intervals after the first dose.
+type SecondDoseConfig struct {
+	// FirstDoseDate (YYYY-MM-DD) enables the second dose mode
+	FirstDoseDate string `yaml:"first_dose_date"`
+	// Vaccine is the vaccine of the first dose: "pfizer", "moderna" or "astrazeneca"
+	Vaccine string `yaml:"vaccine"`
+	// MinDays and MaxDays are the minimum and maximum numbers of days between both doses, the vaccine's ones if
+	// omitted
+	MinDays *int `yaml:"min_days"`
+	MaxDays *int `yaml:"max_days"`
+	// Motives select the visit motives of the second dose, the vaccine's one if empty
+	Motives  []MotiveSelector `yaml:"motives"`
+	motives  []MotiveSelector
+	earliest time.Time
+	latest   time.Time
+}
+
+// ConfirmationConfig declares the answers to the qualification questions (e.g. eligibility) and the values of the
+// custom fields some centers require to confirm an appointment, by question or field ID or label.
+type ConfirmationConfig struct {
+	QualificationAnswers map[string]string `yaml:"qualification_answers"`
+	CustomFields         map[string]string `yaml:"custom_fields"`
+}
+
+type SchedulingConfig struct {
+	// Workers is the initial number of workers, between MinWorkers and MaxWorkers. Throttling never shrinks the pool
+	// below one worker per account, but the workers of accounts without patients waiting are stopped.
+	Workers    uint `yaml:"workers"`
+	MinWorkers uint `yaml:"min_workers"`
+	MaxWorkers uint `yaml:"max_workers"`
+	// TargetLatency is the maximum time between two checks of a center, above which workers are added. Workers are
+	// removed when Doctolib throttles requests. The number of workers is fixed if 0.
+	TargetLatency   time.Duration `yaml:"target_latency"`
+	Sleep           time.Duration `yaml:"sleep"`
+	RequestsTimeout time.Duration `yaml:"requests_timeout"`
+}
+
+// ReloadConfig sets how often to check the configuration file and the centers files for changes. They are always
+// reloaded on SIGHUP.
+type ReloadConfig struct {
+	// WatchInterval is the time between two checks of the files, 0 to only reload on SIGHUP
+	WatchInterval time.Duration `yaml:"watch_interval"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type MetricsConfig struct {
+	Address string `yaml:"address"`
+}
+
+// ControlConfig sets the address of the control API, disabled if empty. Without a token, the address must be a
+// loopback address.
+type ControlConfig struct {
+	Address string `yaml:"address"`
+	// Token is the bearer token required by the control API, if not empty
+	Token string `yaml:"token"`
+}
+
+type AuditConfig struct {
+	File string `yaml:"file"`
+}
+
+type HistoryConfig struct {
+	File string `yaml:"file"`
+}
+
+type Config struct {
+	// DoctolibUrl is the root URL of the Doctolib website (e.g. https://www.doctolib.de)
+	DoctolibUrl string `yaml:"doctolib_url"`
+	// GeocodingUrl is the root URL of the address API used to locate addresses
+	GeocodingUrl  string             `yaml:"geocoding_url"`
+	Accounts      []AccountConfig    `yaml:"accounts"`
+	Centers       CentersConfig      `yaml:"centers"`
+	Motives       []MotiveSelector   `yaml:"motives"`
+	TimeWindow    TimeWindowConfig   `yaml:"time_window"`
+	Home          HomeConfig         `yaml:"home"`
+	Arbitration   ArbitrationConfig  `yaml:"arbitration"`
+	Reschedule    RescheduleConfig   `yaml:"reschedule"`
+	Upgrade       UpgradeConfig      `yaml:"upgrade"`
+	SecondDose    SecondDoseConfig   `yaml:"second_dose"`
+	Confirmation  ConfirmationConfig `yaml:"confirmation"`
+	Scheduling    SchedulingConfig   `yaml:"scheduling"`
+	Reload        ReloadConfig       `yaml:"reload"`
+	Notifications []NotifierConfig   `yaml:"notifications"`
+	Logging       LoggingConfig      `yaml:"logging"`
+	Metrics       MetricsConfig      `yaml:"metrics"`
+	Control       ControlConfig      `yaml:"control"`
+	Audit         AuditConfig        `yaml:"audit"`
+	History       HistoryConfig      `yaml:"history"`
+	DryRun        bool               `yaml:"dry_run"`
+	// WatchOnly reports the slots found without booking them, it is set by the watch command
+	WatchOnly bool `yaml:"-"`
+}
+
+const (
+	DefaultAuditLogFilepath = "govaccine_audit.jsonl"
+	DefaultHistoryFilepath  = "govaccine_history.db"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// secondDoseVaccine is the visit motive of the second dose of a vaccine, and the usual interval between both doses.
+type secondDoseVaccine struct {
+	visitMotiveName string
+	minDays         int
+	maxDays         int
+}
+
+var secondDoseVaccines = map[string]secondDoseVaccine{
+	"pfizer":      {"2de injection vaccin COVID-19 (Pfizer-BioNTech)", 21, 49},
+	"moderna":     {"2de injection vaccin COVID-19 (Moderna)", 28, 49},
+	"astrazeneca": {"2de injection vaccin COVID-19 (AstraZeneca)", 63, 84},
+}
+
+func (m *MotiveSelector) Matches(visitMotiveName string) bool {
+	if m.regexp != nil {
+		return m.regexp.MatchString(visitMotiveName)
+	}
+
+	return m.Name == visitMotiveName
+}
+
+// motiveSelectors returns the motive selectors, by order of preference: the second dose ones in second dose mode.
+func (c *Config) motiveSelectors() []MotiveSelector {
+	if c.SecondDose.Enabled() {
+		return c.SecondDose.motives
+	}
+
+	return c.Motives
+}
+
+// centerMotiveSelectors returns the motive selectors to use for center, by order of preference. The preferred motive
+// of the center is ignored in second dose mode.
+func (c *Config) centerMotiveSelectors(center *VaccinationCenter) []MotiveSelector {
+	if center.PreferredMotive == "" || c.SecondDose.Enabled() {
+		return c.motiveSelectors()
+	}
+
+	return append([]MotiveSelector{{Name: center.PreferredMotive}}, c.Motives...)
+}
+
+// matchVisitMotives returns the visit motives matching motiveSelector.
+func matchVisitMotives(motiveSelector *MotiveSelector,
+	visitMotives []doctolib.BookingVisitMotive) []doctolib.BookingVisitMotive {
+	var matchingVisitMotives []doctolib.BookingVisitMotive
+	for _, visitMotive := range visitMotives {
+		if motiveSelector.Matches(visitMotive.Name) {
+			matchingVisitMotives = append(matchingVisitMotives, visitMotive)
+		}
+	}
+
+	return matchingVisitMotives
+}
+
+// BookedAppointment returns the first appointment of appointments, upcoming ones first, which isn't canceled and
+// whose visit motive matches a motive selector, or nil if there is none. It also returns whether the appointment is
+// upcoming and the index of the motive selector it matches.
+func (c *Config) BookedAppointment(appointments *doctolib.AppointmentsResponse) (*doctolib.Appointment, bool, int) {
+	for k, appointmentList := range [][]doctolib.Appointment{appointments.Upcoming, appointments.Past} {
+		for i := range appointmentList {
+			if appointmentList[i].Status == doctolib.AppointmentCanceled {
+				continue
+			}
+			motiveSelectors := c.motiveSelectors()
+			for j := range motiveSelectors {
+				if motiveSelectors[j].Matches(appointmentList[i].VisitMotiveName) {
+					return &appointmentList[i], k == 0, j
+				}
+			}
+		}
+	}
+
+	return nil, false, 0
+}
+
+func (m *MotiveSelector) String() string {
+	if m.Pattern != "" {
+		return fmt.Sprintf("/%s/", m.Pattern)
+	}
+
+	return fmt.Sprintf("\"%s\"", m.Name)
+}
+
+// Contains reports whether a slot starting at slotStart is acceptable.
+func (t *TimeWindowConfig) Contains(slotStart time.Time) bool {
+	if len(t.weekdays) > 0 && !t.weekdays[slotStart.Weekday()] {
+		return false
+	}
+
+	minutes := slotStart.Hour()*60 + slotStart.Minute()
+	if t.EarliestTime != "" && minutes < t.earliest {
+		return false
+	}
+	if t.LatestTime != "" && minutes > t.latest {
+		return false
+	}
+
+	return true
+}
+
+// Enabled reports whether only the second dose is booked.
+func (s *SecondDoseConfig) Enabled() bool {
+	return s.FirstDoseDate != ""
+}
+
+// Window returns the start of the second dose window and its end, which is the midnight after its last day.
+func (s *SecondDoseConfig) Window() (time.Time, time.Time) {
+	return s.earliest, s.latest
+}
+
+// WindowPassed reports whether no slot starting from searchStart can be in the second dose window.
+func (s *SecondDoseConfig) WindowPassed(searchStart time.Time) bool {
+	return !searchStart.Before(s.latest)
+}
+
+// Contains reports whether the second dose can be given at slotStart.
+func (s *SecondDoseConfig) Contains(slotStart time.Time) bool {
+	return !slotStart.Before(s.earliest) && slotStart.Before(s.latest)
+}
+
+// validate checks the second dose configuration, if enabled, and computes its window. Problems are reported with
+// addProblem.
+func (s *SecondDoseConfig) validate(startAfterDays int, addProblem func(path string, format string, a ...interface{})) {
+	if !s.Enabled() {
+		return
+	}
+
+	vaccine, ok := secondDoseVaccines[strings.ToLower(s.Vaccine)]
+	if !ok {
+		addProblem("second_dose.vaccine",
+			"unknown vaccine \"%s\" (expected \"pfizer\", \"moderna\" or \"astrazeneca\")", s.Vaccine)
+		return
+	}
+	minDays, maxDays := vaccine.minDays, vaccine.maxDays
+	if s.MinDays != nil {
+		minDays = *s.MinDays
+	}
+	if s.MaxDays != nil {
+		maxDays = *s.MaxDays
+	}
+	if minDays < 0 {
+		addProblem("second_dose.min_days", "must be >= 0")
+	}
+	if maxDays < minDays {
+		addProblem("second_dose.max_days", "must be >= min_days (%d)", minDays)
+	}
+
+	firstDoseDate, err := time.ParseInLocation("2006-01-02", s.FirstDoseDate, time.Local)
+	if err != nil {
+		addProblem("second_dose.first_dose_date", "invalid date \"%s\" (expected YYYY-MM-DD)", s.FirstDoseDate)
+		return
+	}
+	s.earliest = firstDoseDate.AddDate(0, 0, minDays)
+	s.latest = firstDoseDate.AddDate(0, 0, maxDays+1) // The whole last day
+	if s.WindowPassed(time.Now().AddDate(0, 0, startAfterDays)) {
+		addProblem("second_dose", "the second dose window (%s to %s) has passed", s.earliest.Format("2006-01-02"),
+			s.latest.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+
+	s.motives = s.Motives
+	if len(s.motives) == 0 {
+		s.motives = []MotiveSelector{{Name: vaccine.visitMotiveName}}
+	}
+	validateMotiveSelectors("second_dose.motives", s.motives, addProblem)
+}
+
+// validateMotiveSelectors checks motiveSelectors, found at path, and compiles their patterns. Problems are reported
+// with addProblem.
+func validateMotiveSelectors(path string, motiveSelectors []MotiveSelector,
+	addProblem func(path string, format string, a ...interface{})) {
+	for i := range motiveSelectors {
+		motive := &motiveSelectors[i]
+		if (motive.Name == "") == (motive.Pattern == "") {
+			addProblem(fmt.Sprintf("%s[%d]", path, i), "exactly one of \"name\" and \"pattern\" is required")
+			continue
+		}
+		if motive.Pattern != "" {
+			motiveRegexp, err := regexp.Compile(motive.Pattern)
+			if err != nil {
+				addProblem(fmt.Sprintf("%s[%d].pattern", path, i), "invalid regular expression: %s", err)
+			}
+			motive.regexp = motiveRegexp
+		}
+	}
+}
+
+func (p *PatientConfig) isAnyPatient() bool {
+	return p.Id == 0 && p.FirstName == "" && p.LastName == ""
+}
+
+func (p *PatientConfig) String() string {
+	if p.isAnyPatient() {
+		return "first patient of the account"
+	}
+	if p.Id != 0 {
+		return fmt.Sprintf("patient %d", p.Id)
+	}
+
+	return fmt.Sprintf("%s %s", p.FirstName, p.LastName)
+}
+
+func (p *PatientConfig) Matches(id int, firstName string, lastName string) bool {
+	if p.isAnyPatient() {
+		return true
+	}
+	if p.Id != 0 {
+		return p.Id == id
+	}
+
+	return strings.EqualFold(p.FirstName, firstName) && strings.EqualFold(p.LastName, lastName)
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	timeOfDay, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time \"%s\" (expected HH:MM)", value)
+	}
+
+	return timeOfDay.Hour()*60 + timeOfDay.Minute(), nil
+}
+
+// Validate checks the configuration and prepares it for use. All the problems found are reported at once.
+func (c *Config) Validate() error {
+	return c.validate(true, true)
+}
+
+// ValidateWithoutAccounts is like Validate but doesn't require any account, for commands that don't log in.
+func (c *Config) ValidateWithoutAccounts() error {
+	return c.validate(false, true)
+}
+
+// ValidateWithoutCenters is like Validate but doesn't require any vaccination center, for commands that only log in.
+func (c *Config) ValidateWithoutCenters() error {
+	return c.validate(true, false)
+}
+
+func (c *Config) validate(withAccounts bool, withCenters bool) error {
+	var problems []string
+	addProblem := func(path string, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, a...)))
+	}
+
+	if doctolibUrl, err := url.Parse(c.DoctolibUrl); err != nil {
+		addProblem("doctolib_url", "invalid URL: %s", err)
+	} else if (doctolibUrl.Scheme != "https" && doctolibUrl.Scheme != "http") || doctolibUrl.Host == "" {
+		addProblem("doctolib_url", "expected an URL such as %s", doctolib.RootUrl)
+	}
+	if geocodingUrl, err := url.Parse(c.GeocodingUrl); err != nil {
+		addProblem("geocoding_url", "invalid URL: %s", err)
+	} else if (geocodingUrl.Scheme != "https" && geocodingUrl.Scheme != "http") || geocodingUrl.Host == "" {
+		addProblem("geocoding_url", "expected an URL such as %s", geo.AddressApiUrl)
+	}
+
+	if withAccounts {
+		if len(c.Accounts) == 0 {
+			addProblem("accounts", "at least one account is required")
+		}
+		for i, account := range c.Accounts {
+			if account.Username == "" {
+				addProblem(fmt.Sprintf("accounts[%d].username", i), "required")
+			}
+			if account.Password != "" && account.PasswordSource != "" {
+				addProblem(fmt.Sprintf("accounts[%d]", i), "\"password\" and \"password_source\" are mutually exclusive")
+			} else if account.PasswordSource != "" {
+				if _, _, err := parsePasswordSource(account.PasswordSource); err != nil {
+					addProblem(fmt.Sprintf("accounts[%d].password_source", i), "%s", err)
+				}
+			}
+			for j, patient := range account.Patients {
+				if patient.Id == 0 && (patient.FirstName == "" || patient.LastName == "") {
+					addProblem(fmt.Sprintf("accounts[%d].patients[%d]", i, j),
+						"either \"id\" or both \"first_name\" and \"last_name\" are required")
+				}
+			}
+		}
+	}
+
+	if withCenters && len(c.Centers.Files) == 0 && len(c.Centers.Urls) == 0 {
+		addProblem("centers", "at least one file or URL is required")
+	}
+
+	if len(c.Motives) == 0 {
+		addProblem("motives", "at least one visit motive selector is required")
+	}
+	validateMotiveSelectors("motives", c.Motives, addProblem)
+	c.SecondDose.validate(c.TimeWindow.StartAfterDays, addProblem)
+
+	if c.TimeWindow.StartAfterDays < 0 {
+		addProblem("time_window.start_after_days", "must be >= 0")
+	}
+	if c.TimeWindow.Days < 1 || c.TimeWindow.Days > 7 {
+		addProblem("time_window.days", "must be between 1 and 7")
+	}
+	var earliestErr, latestErr error
+	if c.TimeWindow.EarliestTime != "" {
+		if c.TimeWindow.earliest, earliestErr = parseTimeOfDay(c.TimeWindow.EarliestTime); earliestErr != nil {
+			addProblem("time_window.earliest_time", "%s", earliestErr)
+		}
+	}
+	if c.TimeWindow.LatestTime != "" {
+		if c.TimeWindow.latest, latestErr = parseTimeOfDay(c.TimeWindow.LatestTime); latestErr != nil {
+			addProblem("time_window.latest_time", "%s", latestErr)
+		}
+	}
+	// Invalid times are only reported once
+	if c.TimeWindow.EarliestTime != "" && c.TimeWindow.LatestTime != "" && earliestErr == nil && latestErr == nil &&
+		c.TimeWindow.earliest > c.TimeWindow.latest {
+		addProblem("time_window", "earliest_time (%s) is after latest_time (%s)", c.TimeWindow.EarliestTime,
+			c.TimeWindow.LatestTime)
+	}
+	c.TimeWindow.weekdays = make(map[time.Weekday]bool)
+	for i, weekday := range c.TimeWindow.Weekdays {
+		day, ok := weekdays[strings.ToLower(weekday)]
+		if !ok {
+			addProblem(fmt.Sprintf("time_window.weekdays[%d]", i), "unknown day \"%s\"", weekday)
+		}
+		c.TimeWindow.weekdays[day] = true
+	}
+
+	if c.Home.Coordinates != "" && c.Home.Address != "" {
+		addProblem("home", "\"coordinates\" and \"address\" are mutually exclusive")
+	} else if c.Home.Coordinates != "" {
+		if _, err := geo.ParseCoordinates(c.Home.Coordinates); err != nil {
+			addProblem("home.coordinates", "invalid coordinates \"%s\" (expected LATITUDE,LONGITUDE)",
+				c.Home.Coordinates)
+		}
+	} else if c.Home.Address == "" && c.Home.MaxDistance != 0 {
+		addProblem("home.max_distance", "requires \"coordinates\" or \"address\"")
+	}
+	if c.Home.MaxDistance < 0 {
+		addProblem("home.max_distance", "must be >= 0")
+	}
+
+	if c.Arbitration.Window < 0 {
+		addProblem("arbitration.window", "must be >= 0")
+	}
+	seenPreferences := make(map[string]bool)
+	for i, preference := range c.Arbitration.Prefer {
+		if !validPreferences[preference] {
+			addProblem(fmt.Sprintf("arbitration.prefer[%d]", i),
+				"unknown preference \"%s\" (expected \"%s\", \"%s\", \"%s\" or \"%s\")", preference,
+				PreferPriority, PreferMotive, PreferDistance, PreferDate)
+		} else if seenPreferences[preference] {
+			addProblem(fmt.Sprintf("arbitration.prefer[%d]", i), "duplicate preference \"%s\"", preference)
+		}
+		seenPreferences[preference] = true
+	}
+
+	if c.Scheduling.MinWorkers == 0 {
+		addProblem("scheduling.min_workers", "must be >= 1")
+	}
+	if c.Scheduling.MaxWorkers < c.Scheduling.MinWorkers {
+		addProblem("scheduling.max_workers", "must be >= min_workers (%d)", c.Scheduling.MinWorkers)
+	}
+	if c.Scheduling.Workers < c.Scheduling.MinWorkers || c.Scheduling.Workers > c.Scheduling.MaxWorkers {
+		addProblem("scheduling.workers", "must be between min_workers (%d) and max_workers (%d)",
+			c.Scheduling.MinWorkers, c.Scheduling.MaxWorkers)
+	} else if withAccounts && int(c.Scheduling.Workers) < len(c.Accounts) {
+		addProblem("scheduling.workers", "must be >= the number of accounts (%d)", len(c.Accounts))
+	}
+	if c.Scheduling.TargetLatency < 0 {
+		addProblem("scheduling.target_latency", "must be >= 0")
+	}
+	if c.Upgrade.MinImprovement < 0 {
+		addProblem("upgrade.min_improvement", "must be >= 0")
+	}
+	if c.Reload.WatchInterval < 0 {
+		addProblem("reload.watch_interval", "must be >= 0")
+	}
+	if c.Scheduling.Sleep < 0 {
+		addProblem("scheduling.sleep", "must be >= 0")
+	}
+	if c.Scheduling.RequestsTimeout <= 0 {
+		addProblem("scheduling.requests_timeout", "must be > 0")
+	}
+
+	for i := range c.Notifications {
+		if _, err := newNotifier(&c.Notifications[i]); err != nil {
+			addProblem(fmt.Sprintf("notifications[%d]", i), "%s", err)
+		}
+		for j, eventType := range c.Notifications[i].Events {
+			if _, ok := eventTitles[eventType]; !ok {
+				addProblem(fmt.Sprintf("notifications[%d].events[%d]", i, j), "unknown event type \"%s\"", eventType)
+			}
+		}
+	}
+
+	for _, section := range []struct {
+		path    string
+		answers map[string]string
+	}{
+		{path: "confirmation.qualification_answers", answers: c.Confirmation.QualificationAnswers},
+		{path: "confirmation.custom_fields", answers: c.Confirmation.CustomFields},
+	} {
+		for _, keys := range conflictingAnswerKeys(section.answers) {
+			addProblem(section.path, "\"%s\" and \"%s\" only differ by case, keep one of them", keys[0], keys[1])
+		}
+	}
+
+	if c.Control.Address != "" {
+		host, _, err := net.SplitHostPort(c.Control.Address)
+		if err != nil {
+			addProblem("control.address", "invalid address: %s", err)
+		} else if !isLoopback(host) && c.Control.Token == "" {
+			addProblem("control.address", "\"%s\" isn't a loopback address, \"token\" is required to listen on it",
+				host)
+		}
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		addProblem("logging.level", "must be one of \"debug\", \"info\", \"warn\" and \"error\"")
+	}
+	switch c.Logging.Format {
+	case "text", "json":
+	default:
+		addProblem("logging.format", "must be \"text\" or \"json\"")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("govaccine.Config.validate(): invalid configuration:\n  " +
+			strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// isLoopback reports whether host only accepts local connections.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Locate sets the coordinates of home, looking its address up with geocoder if needed.
+func (h *HomeConfig) Locate(geocoder *geo.Geocoder) (*geo.Location, error) {
+	if h.Coordinates != "" {
+		coordinates, err := geo.ParseCoordinates(h.Coordinates)
+		if err != nil {
+			return nil, fmt.Errorf("govaccine.HomeConfig.Locate(): %w", err)
+		}
+		h.location = &coordinates
+		return &geo.Location{Label: h.Coordinates, Coordinates: coordinates}, nil
+	}
+	if h.Address == "" {
+		return nil, nil
+	}
+
+	location, err := geocoder.Geocode(h.Address)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.HomeConfig.Locate(): cannot locate home: %w", err)
+	}
+	h.location = &location.Coordinates
+
+	return location, nil
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		DoctolibUrl:  doctolib.RootUrl,
+		GeocodingUrl: geo.AddressApiUrl,
+		Motives:      []MotiveSelector{{Name: PfizerBiontechVaccineVisitMotiveName}},
+		TimeWindow: TimeWindowConfig{
+			StartAfterDays: 1,
+			Days:           1,
+		},
+		Arbitration: ArbitrationConfig{
+			Window: 1 * time.Second,
+			Prefer: []string{PreferPriority, PreferMotive, PreferDistance, PreferDate},
+		},
+		Upgrade: UpgradeConfig{
+			MinImprovement: 48 * time.Hour,
+		},
+		Scheduling: SchedulingConfig{
+			Workers:         4,
+			MinWorkers:      1,
+			MaxWorkers:      16,
+			TargetLatency:   30 * time.Second,
+			Sleep:           1 * time.Second,
+			RequestsTimeout: 5 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Audit: AuditConfig{
+			File: DefaultAuditLogFilepath,
+		},
+		History: HistoryConfig{
+			File: DefaultHistoryFilepath,
+		},
+	}
+}
+
+// withoutPasswords returns a copy of c without inline passwords, which are cleared once loaded.
+func (c *Config) withoutPasswords() *Config {
+	config := *c
+	config.Accounts = make([]AccountConfig, len(c.Accounts))
+	for i, account := range c.Accounts {
+		account.Password = ""
+		config.Accounts[i] = account
+	}
+
+	return &config
+}
+
+// ChangedSections returns the names of the top-level sections which differ between c and other, inline passwords
+// excepted.
+func (c *Config) ChangedSections(other *Config) []string {
+	var sections []string
+	current := reflect.ValueOf(c.withoutPasswords()).Elem()
+	updated := reflect.ValueOf(other.withoutPasswords()).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		// Compared in YAML to ignore the values computed by validate
+		currentYaml, currentErr := yaml.Marshal(current.Field(i).Interface())
+		updatedYaml, updatedErr := yaml.Marshal(updated.Field(i).Interface())
+		if currentErr != nil || updatedErr != nil || !bytes.Equal(currentYaml, updatedYaml) {
+			name, _, _ := strings.Cut(current.Type().Field(i).Tag.Get("yaml"), ",")
+			sections = append(sections, name)
+		}
+	}
+
+	return sections
+}
+
+// LoadConfig reads a YAML configuration file on top of the default configuration. Relative filepaths of centers
+// files are resolved from the directory of the configuration file. The configuration isn't validated.
+func LoadConfig(configFilepath string) (*Config, error) {
+	configBytes, err := ioutil.ReadFile(configFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.LoadConfig(): failed to read file %s: %s", configFilepath, err)
+	}
+
+	config := DefaultConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(configBytes))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("govaccine.LoadConfig(): failed to parse file %s: %s", configFilepath, err)
+	}
+
+	configDir := filepath.Dir(configFilepath)
+	for i, centersFilepath := range config.Centers.Files {
+		if !filepath.IsAbs(centersFilepath) {
+			config.Centers.Files[i] = filepath.Join(configDir, centersFilepath)
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,505 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validTestConfig returns a configuration which passes Validate.
+func validTestConfig() *Config {
+	config := DefaultConfig()
+	config.Accounts = []AccountConfig{{
+		Username:       "a@example.com",
+		PasswordSource: "env:GOVACCINE_PASSWORD",
+		Patients:       []PatientConfig{{FirstName: "Alice", LastName: "Martin"}, {Id: 42}},
+	}}
+	config.Centers.Urls = []string{"https://www.doctolib.fr/c/paris/centre-a"}
+	config.TimeWindow.EarliestTime = "08:00"
+	config.TimeWindow.LatestTime = "18:30"
+	config.TimeWindow.Weekdays = []string{"monday", "Saturday"}
+	config.Home.Coordinates = "48.8566,2.3522"
+	config.Home.MaxDistance = 10
+	config.Notifications = []NotifierConfig{
+		{Type: "webhook", Url: "https://example.com/hook", Events: []EventType{EventBookingConfirmed}},
+	}
+	config.Control.Address = "127.0.0.1:8090"
+
+	return config
+}
+
+// validationProblems returns the problems reported by err, one per line.
+func validationProblems(err error) []string {
+	if err == nil {
+		return nil
+	}
+	_, problems, _ := strings.Cut(err.Error(), "\n  ")
+
+	return strings.Split(problems, "\n  ")
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := validTestConfig().Validate(); err != nil {
+		t.Fatalf("Validate() of the valid configuration error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		update       func(config *Config)
+		wantProblems []string
+	}{
+		{
+			name:         "invalid Doctolib URL",
+			update:       func(config *Config) { config.DoctolibUrl = "www.doctolib.fr" },
+			wantProblems: []string{"doctolib_url: expected an URL"},
+		},
+		{
+			name:         "invalid geocoding URL",
+			update:       func(config *Config) { config.GeocodingUrl = "ftp://example.com" },
+			wantProblems: []string{"geocoding_url: expected an URL"},
+		},
+		{
+			name:         "no account",
+			update:       func(config *Config) { config.Accounts = nil },
+			wantProblems: []string{"accounts: at least one account is required"},
+		},
+		{
+			name:         "no username",
+			update:       func(config *Config) { config.Accounts[0].Username = "" },
+			wantProblems: []string{"accounts[0].username: required"},
+		},
+		{
+			name:         "password and password source",
+			update:       func(config *Config) { config.Accounts[0].Password = "secret" },
+			wantProblems: []string{`accounts[0]: "password" and "password_source" are mutually exclusive`},
+		},
+		{
+			name:         "unknown password source",
+			update:       func(config *Config) { config.Accounts[0].PasswordSource = "keyring:govaccine" },
+			wantProblems: []string{`accounts[0].password_source: unknown password source "keyring:govaccine"`},
+		},
+		{
+			name:         "password source without value",
+			update:       func(config *Config) { config.Accounts[0].PasswordSource = "file:" },
+			wantProblems: []string{`accounts[0].password_source: missing value after "file:"`},
+		},
+		{
+			name:         "patient without last name",
+			update:       func(config *Config) { config.Accounts[0].Patients[0].LastName = "" },
+			wantProblems: []string{`accounts[0].patients[0]: either "id" or both "first_name" and "last_name"`},
+		},
+		{
+			name:         "no center",
+			update:       func(config *Config) { config.Centers.Urls = nil },
+			wantProblems: []string{"centers: at least one file or URL is required"},
+		},
+		{
+			name:         "no motive",
+			update:       func(config *Config) { config.Motives = nil },
+			wantProblems: []string{"motives: at least one visit motive selector is required"},
+		},
+		{
+			name:         "motive with name and pattern",
+			update:       func(config *Config) { config.Motives[0].Pattern = "Pfizer" },
+			wantProblems: []string{`motives[0]: exactly one of "name" and "pattern" is required`},
+		},
+		{
+			name:         "invalid motive pattern",
+			update:       func(config *Config) { config.Motives = []MotiveSelector{{Pattern: "Pfizer ("}} },
+			wantProblems: []string{"motives[0].pattern: invalid regular expression"},
+		},
+		{
+			name:         "negative start",
+			update:       func(config *Config) { config.TimeWindow.StartAfterDays = -1 },
+			wantProblems: []string{"time_window.start_after_days: must be >= 0"},
+		},
+		{
+			name:         "too many days",
+			update:       func(config *Config) { config.TimeWindow.Days = 8 },
+			wantProblems: []string{"time_window.days: must be between 1 and 7"},
+		},
+		{
+			name:         "invalid earliest time",
+			update:       func(config *Config) { config.TimeWindow.EarliestTime = "8h" },
+			wantProblems: []string{`time_window.earliest_time: invalid time "8h"`},
+		},
+		{
+			name:         "invalid latest time",
+			update:       func(config *Config) { config.TimeWindow.LatestTime = "24:00" },
+			wantProblems: []string{`time_window.latest_time: invalid time "24:00"`},
+		},
+		{
+			name:         "earliest time after latest time",
+			update:       func(config *Config) { config.TimeWindow.EarliestTime = "19:00" },
+			wantProblems: []string{"time_window: earliest_time (19:00) is after latest_time (18:30)"},
+		},
+		{
+			name:         "unknown weekday",
+			update:       func(config *Config) { config.TimeWindow.Weekdays[1] = "samedi" },
+			wantProblems: []string{`time_window.weekdays[1]: unknown day "samedi"`},
+		},
+		{
+			name:         "coordinates and address",
+			update:       func(config *Config) { config.Home.Address = "Paris" },
+			wantProblems: []string{`home: "coordinates" and "address" are mutually exclusive`},
+		},
+		{
+			name:         "invalid coordinates",
+			update:       func(config *Config) { config.Home.Coordinates = "48.8566" },
+			wantProblems: []string{`home.coordinates: invalid coordinates "48.8566"`},
+		},
+		{
+			name:         "maximum distance without home",
+			update:       func(config *Config) { config.Home.Coordinates = "" },
+			wantProblems: []string{`home.max_distance: requires "coordinates" or "address"`},
+		},
+		{
+			name:         "negative maximum distance",
+			update:       func(config *Config) { config.Home.MaxDistance = -1 },
+			wantProblems: []string{"home.max_distance: must be >= 0"},
+		},
+		{
+			name:         "negative arbitration window",
+			update:       func(config *Config) { config.Arbitration.Window = -time.Second },
+			wantProblems: []string{"arbitration.window: must be >= 0"},
+		},
+		{
+			name:         "unknown preference",
+			update:       func(config *Config) { config.Arbitration.Prefer[1] = "price" },
+			wantProblems: []string{`arbitration.prefer[1]: unknown preference "price"`},
+		},
+		{
+			name:         "duplicate preference",
+			update:       func(config *Config) { config.Arbitration.Prefer[3] = PreferPriority },
+			wantProblems: []string{`arbitration.prefer[3]: duplicate preference "priority"`},
+		},
+		{
+			name: "no minimum worker",
+			update: func(config *Config) {
+				config.Scheduling.MinWorkers = 0
+			},
+			wantProblems: []string{"scheduling.min_workers: must be >= 1"},
+		},
+		{
+			name: "maximum workers below minimum",
+			update: func(config *Config) {
+				config.Scheduling.MinWorkers = 4
+				config.Scheduling.MaxWorkers = 3
+			},
+			wantProblems: []string{
+				"scheduling.max_workers: must be >= min_workers (4)",
+				"scheduling.workers: must be between min_workers (4) and max_workers (3)",
+			},
+		},
+		{
+			name:         "workers above maximum",
+			update:       func(config *Config) { config.Scheduling.Workers = 17 },
+			wantProblems: []string{"scheduling.workers: must be between min_workers (1) and max_workers (16)"},
+		},
+		{
+			name: "fewer workers than accounts",
+			update: func(config *Config) {
+				config.Scheduling.Workers = 1
+				config.Accounts = append(config.Accounts, AccountConfig{Username: "b@example.com"})
+			},
+			wantProblems: []string{"scheduling.workers: must be >= the number of accounts (2)"},
+		},
+		{
+			name:         "negative target latency",
+			update:       func(config *Config) { config.Scheduling.TargetLatency = -time.Second },
+			wantProblems: []string{"scheduling.target_latency: must be >= 0"},
+		},
+		{
+			name:         "negative minimum improvement",
+			update:       func(config *Config) { config.Upgrade.MinImprovement = -time.Hour },
+			wantProblems: []string{"upgrade.min_improvement: must be >= 0"},
+		},
+		{
+			name:         "negative watch interval",
+			update:       func(config *Config) { config.Reload.WatchInterval = -time.Second },
+			wantProblems: []string{"reload.watch_interval: must be >= 0"},
+		},
+		{
+			name:         "negative sleep",
+			update:       func(config *Config) { config.Scheduling.Sleep = -time.Second },
+			wantProblems: []string{"scheduling.sleep: must be >= 0"},
+		},
+		{
+			name:         "no requests timeout",
+			update:       func(config *Config) { config.Scheduling.RequestsTimeout = 0 },
+			wantProblems: []string{"scheduling.requests_timeout: must be > 0"},
+		},
+		{
+			name:         "invalid notifier",
+			update:       func(config *Config) { config.Notifications[0].Url = "" },
+			wantProblems: []string{`notifications[0]: webhook notifier requires "url"`},
+		},
+		{
+			name:         "unknown event type",
+			update:       func(config *Config) { config.Notifications[0].Events[0] = "slot_booked" },
+			wantProblems: []string{`notifications[0].events[0]: unknown event type "slot_booked"`},
+		},
+		{
+			name: "answers differing by case",
+			update: func(config *Config) {
+				config.Confirmation.CustomFields = map[string]string{"Age": "42", "age": "43"}
+			},
+			wantProblems: []string{`confirmation.custom_fields: "Age" and "age" only differ by case`},
+		},
+		{
+			name:         "invalid control address",
+			update:       func(config *Config) { config.Control.Address = "localhost" },
+			wantProblems: []string{"control.address: invalid address"},
+		},
+		{
+			name:         "unknown logging level",
+			update:       func(config *Config) { config.Logging.Level = "trace" },
+			wantProblems: []string{"logging.level: must be one of"},
+		},
+		{
+			name:         "unknown logging format",
+			update:       func(config *Config) { config.Logging.Format = "logfmt" },
+			wantProblems: []string{`logging.format: must be "text" or "json"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			test.update(config)
+			err := config.Validate()
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %q", test.wantProblems)
+			}
+
+			problems := validationProblems(err)
+			if len(problems) != len(test.wantProblems) {
+				t.Fatalf("Validate() reported %q, want %q", problems, test.wantProblems)
+			}
+			for i, want := range test.wantProblems {
+				if !strings.HasPrefix(problems[i], want) {
+					t.Errorf("problem %d = %q, want it to start with %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValidateReportsAllProblems(t *testing.T) {
+	config := validTestConfig()
+	config.Accounts[0].Username = ""
+	config.Centers.Urls = nil
+	config.TimeWindow.Days = 0
+	config.Scheduling.Sleep = -time.Second
+	config.Logging.Format = "xml"
+
+	err := config.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "govaccine.Config.validate(): invalid configuration:") {
+		t.Fatalf("Validate() error = %v, want an invalid configuration error", err)
+	}
+	want := []string{
+		"accounts[0].username: required",
+		"centers: at least one file or URL is required",
+		"time_window.days: must be between 1 and 7",
+		"scheduling.sleep: must be >= 0",
+		`logging.format: must be "text" or "json"`,
+	}
+	problems := validationProblems(err)
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() reported %q, want %q", problems, want)
+	}
+
+	// The problems that don't apply to a command aren't reported
+	if problems := validationProblems(config.ValidateWithoutCenters()); len(problems) != 4 {
+		t.Errorf("ValidateWithoutCenters() reported %q, want all the problems but the centers one", problems)
+	}
+	config.Accounts = nil
+	if problems := validationProblems(config.ValidateWithoutAccounts()); len(problems) != 4 {
+		t.Errorf("ValidateWithoutAccounts() reported %q, want all the problems but the accounts one", problems)
+	}
+}
+
+func TestSecondDoseWindow(t *testing.T) {
+	today := time.Now()
+	firstDose := time.Date(today.Year(), today.Month(), today.Day()+1, 0, 0, 0, 0, time.Local)
+	days := func(n int) *int { return &n }
+
+	tests := []struct {
+		vaccine    string
+		minDays    *int
+		maxDays    *int
+		wantMin    int
+		wantMax    int
+		wantMotive string
+	}{
+		{vaccine: "pfizer", wantMin: 21, wantMax: 49, wantMotive: "2de injection vaccin COVID-19 (Pfizer-BioNTech)"},
+		{vaccine: "moderna", wantMin: 28, wantMax: 49, wantMotive: "2de injection vaccin COVID-19 (Moderna)"},
+		{vaccine: "astrazeneca", wantMin: 63, wantMax: 84, wantMotive: "2de injection vaccin COVID-19 (AstraZeneca)"},
+		{vaccine: "PFIZER", wantMin: 21, wantMax: 49, wantMotive: "2de injection vaccin COVID-19 (Pfizer-BioNTech)"},
+		{
+			vaccine:    "moderna",
+			minDays:    days(35),
+			maxDays:    days(42),
+			wantMin:    35,
+			wantMax:    42,
+			wantMotive: "2de injection vaccin COVID-19 (Moderna)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %d-%d days", test.vaccine, test.wantMin, test.wantMax), func(t *testing.T) {
+			config := validTestConfig()
+			config.SecondDose = SecondDoseConfig{
+				FirstDoseDate: firstDose.Format("2006-01-02"),
+				Vaccine:       test.vaccine,
+				MinDays:       test.minDays,
+				MaxDays:       test.maxDays,
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			firstDay := firstDose.AddDate(0, 0, test.wantMin)
+			afterLastDay := firstDose.AddDate(0, 0, test.wantMax+1)
+			earliest, latest := config.SecondDose.Window()
+			if !earliest.Equal(firstDay) || !latest.Equal(afterLastDay) {
+				t.Errorf("Window() = %s, %s, want %s, %s", earliest, latest, firstDay, afterLastDay)
+			}
+			slots := []struct {
+				start time.Time
+				want  bool
+			}{
+				{start: firstDay.Add(-time.Minute), want: false},
+				{start: firstDay, want: true},
+				{start: firstDay.Add(9 * time.Hour), want: true},
+				{start: afterLastDay.Add(-time.Minute), want: true},
+				{start: afterLastDay, want: false},
+			}
+			for _, slot := range slots {
+				if got := config.SecondDose.Contains(slot.start); got != slot.want {
+					t.Errorf("Contains(%s) = %t, want %t", slot.start, got, slot.want)
+				}
+			}
+			if config.SecondDose.WindowPassed(afterLastDay.Add(-time.Minute)) {
+				t.Errorf("WindowPassed(%s) = true, want false", afterLastDay.Add(-time.Minute))
+			}
+			if !config.SecondDose.WindowPassed(afterLastDay) {
+				t.Errorf("WindowPassed(%s) = false, want true", afterLastDay)
+			}
+
+			motives := config.motiveSelectors()
+			if len(motives) != 1 || !motives[0].Matches(test.wantMotive) {
+				t.Errorf("visit motives = %v, want %q", motives, test.wantMotive)
+			}
+		})
+	}
+}
+
+func TestSecondDoseValidate(t *testing.T) {
+	today := time.Now()
+	yesterday := time.Date(today.Year(), today.Month(), today.Day()-1, 0, 0, 0, 0, time.Local)
+	days := func(n int) *int { return &n }
+
+	tests := []struct {
+		name         string
+		secondDose   SecondDoseConfig
+		wantProblems []string
+	}{
+		{
+			name:         "unknown vaccine",
+			secondDose:   SecondDoseConfig{FirstDoseDate: "2099-01-01", Vaccine: "janssen"},
+			wantProblems: []string{`second_dose.vaccine: unknown vaccine "janssen"`},
+		},
+		{
+			name:         "no vaccine",
+			secondDose:   SecondDoseConfig{FirstDoseDate: "2099-01-01"},
+			wantProblems: []string{`second_dose.vaccine: unknown vaccine ""`},
+		},
+		{
+			name:         "invalid first dose date",
+			secondDose:   SecondDoseConfig{FirstDoseDate: "01/06/2021", Vaccine: "pfizer"},
+			wantProblems: []string{`second_dose.first_dose_date: invalid date "01/06/2021"`},
+		},
+		{
+			name:         "window passed",
+			secondDose:   SecondDoseConfig{FirstDoseDate: "2021-04-01", Vaccine: "pfizer"},
+			wantProblems: []string{"second_dose: the second dose window (2021-04-22 to 2021-05-20) has passed"},
+		},
+		{
+			// The search starts tomorrow (start_after_days is 1), after the last day of the window
+			name: "window ending today",
+			secondDose: SecondDoseConfig{
+				FirstDoseDate: yesterday.AddDate(0, 0, -48).Format("2006-01-02"),
+				Vaccine:       "pfizer",
+			},
+			wantProblems: []string{"second_dose: the second dose window"},
+		},
+		{
+			name: "negative minimum days",
+			secondDose: SecondDoseConfig{
+				FirstDoseDate: "2099-01-01",
+				Vaccine:       "pfizer",
+				MinDays:       days(-1),
+			},
+			wantProblems: []string{"second_dose.min_days: must be >= 0"},
+		},
+		{
+			name: "maximum days below minimum",
+			secondDose: SecondDoseConfig{
+				FirstDoseDate: "2099-01-01",
+				Vaccine:       "astrazeneca",
+				MaxDays:       days(49),
+			},
+			wantProblems: []string{"second_dose.max_days: must be >= min_days (63)"},
+		},
+		{
+			name: "invalid motive",
+			secondDose: SecondDoseConfig{
+				FirstDoseDate: "2099-01-01",
+				Vaccine:       "pfizer",
+				Motives:       []MotiveSelector{{Pattern: "Pfizer ("}},
+			},
+			wantProblems: []string{"second_dose.motives[0].pattern: invalid regular expression"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			config.SecondDose = test.secondDose
+			problems := validationProblems(config.Validate())
+			if len(problems) != len(test.wantProblems) {
+				t.Fatalf("Validate() reported %q, want %q", problems, test.wantProblems)
+			}
+			for i, want := range test.wantProblems {
+				if !strings.HasPrefix(problems[i], want) {
+					t.Errorf("problem %d = %q, want it to start with %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrMissingAnswers is returned when an appointment requires answers which aren't in the configuration.
+	ErrMissingAnswers = errors.New(
+		"missing answers to required questions, set them in the \"confirmation\" section of the configuration")
+	// ErrInsuranceMismatch is returned when the insurance of the patient isn't accepted by the center.
+	ErrInsuranceMismatch = errors.New("the insurance of the patient isn't accepted by the center, check the " +
+		"social security number and insurance in the patient's profile on Doctolib, or remove the center")
+)
+
+// lookupAnswer returns the answer of answers to the question or field id, declared by ID or by label (case
+// insensitive).
+func lookupAnswer(answers map[string]string, id int, label string) (string, bool) {
+	if answer, ok := answers[strconv.Itoa(id)]; ok {
+		return answer, true
+	}
+	for key, answer := range answers {
+		if label != "" && strings.EqualFold(strings.TrimSpace(key), strings.TrimSpace(label)) {
+			return answer, true
+		}
+	}
+
+	return "", false
+}
+
+// conflictingAnswerKeys returns the pairs of keys of answers which only differ by case, so that lookupAnswer would
+// pick any of them for a label.
+func conflictingAnswerKeys(answers map[string]string) [][2]string {
+	keys := make([]string, 0, len(answers))
+	for key := range answers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conflicts [][2]string
+	firstKeys := make(map[string]string, len(keys))
+	for _, key := range keys {
+		normalizedKey := strings.ToLower(strings.TrimSpace(key))
+		if firstKey, ok := firstKeys[normalizedKey]; ok {
+			conflicts = append(conflicts, [2]string{firstKey, key})
+		} else {
+			firstKeys[normalizedKey] = key
+		}
+	}
+
+	return conflicts
+}
+
+// confirmation returns the values to confirm appointment with, from the configured answers. It fails, listing all of
+// them, if required questions or fields have no answer.
+func (c *ConfirmationConfig) confirmation(appointment *doctolib.AppointmentDetails) (*doctolib.Confirmation, error) {
+	confirmation := &doctolib.Confirmation{
+		QualificationAnswers: make(map[string]string),
+		CustomFieldsValues:   make(map[string]string),
+	}
+	var missing []string
+	for _, question := range appointment.QualificationQuestions {
+		answer, ok := lookupAnswer(c.QualificationAnswers, question.Id, question.Label)
+		if ok {
+			confirmation.QualificationAnswers[strconv.Itoa(question.Id)] = answer
+		} else if question.Required {
+			missing = append(missing, fmt.Sprintf("qualification_answers: question %d (\"%s\")", question.Id,
+				question.Label))
+		}
+	}
+	for _, field := range appointment.CustomFields {
+		value, ok := lookupAnswer(c.CustomFields, field.Id, field.Label)
+		if ok {
+			confirmation.CustomFieldsValues[strconv.Itoa(field.Id)] = value
+		} else if field.Required {
+			missing = append(missing, fmt.Sprintf("custom_fields: field %d (\"%s\")", field.Id, field.Label))
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("govaccine.ConfirmationConfig.confirmation(): %w: %s", ErrMissingAnswers,
+			strings.Join(missing, ", "))
+	}
+
+	return confirmation, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestConfirmation(t *testing.T) {
+	appointment := &doctolib.AppointmentDetails{
+		QualificationQuestions: []doctolib.QualificationQuestion{
+			{Id: 1, Label: "Avez-vous plus de 55 ans ?", Required: true},
+			{Id: 2, Label: "Êtes-vous un professionnel de santé ?", Required: true},
+			{Id: 3, Label: "Commentaire", Required: false},
+		},
+		CustomFields: []doctolib.CustomField{
+			{Id: 10, Label: "Numéro de sécurité sociale", Required: true},
+		},
+	}
+
+	tests := []struct {
+		name                     string
+		config                   ConfirmationConfig
+		wantQualificationAnswers map[string]string
+		wantCustomFieldsValues   map[string]string
+		wantMissing              []string
+	}{
+		{
+			name: "answers by ID",
+			config: ConfirmationConfig{
+				QualificationAnswers: map[string]string{"1": "oui", "2": "non"},
+				CustomFields:         map[string]string{"10": "180017512345678"},
+			},
+			wantQualificationAnswers: map[string]string{"1": "oui", "2": "non"},
+			wantCustomFieldsValues:   map[string]string{"10": "180017512345678"},
+		},
+		{
+			name: "answers by label, case insensitive",
+			config: ConfirmationConfig{
+				QualificationAnswers: map[string]string{
+					"avez-vous plus de 55 ans ?":              "oui",
+					" ÊTES-VOUS UN PROFESSIONNEL DE SANTÉ ? ": "non",
+					"commentaire": "aucun",
+				},
+				CustomFields: map[string]string{"numéro de sécurité sociale": "180017512345678"},
+			},
+			wantQualificationAnswers: map[string]string{"1": "oui", "2": "non", "3": "aucun"},
+			wantCustomFieldsValues:   map[string]string{"10": "180017512345678"},
+		},
+		{
+			name: "ID preferred over label",
+			config: ConfirmationConfig{
+				QualificationAnswers: map[string]string{"1": "oui", "Avez-vous plus de 55 ans ?": "non", "2": "non"},
+				CustomFields:         map[string]string{"10": "180017512345678"},
+			},
+			wantQualificationAnswers: map[string]string{"1": "oui", "2": "non"},
+			wantCustomFieldsValues:   map[string]string{"10": "180017512345678"},
+		},
+		{
+			name: "required question missing",
+			config: ConfirmationConfig{
+				QualificationAnswers: map[string]string{"1": "oui"},
+				CustomFields:         map[string]string{"10": "180017512345678"},
+			},
+			wantMissing: []string{"qualification_answers: question 2 (\"Êtes-vous un professionnel de santé ?\")"},
+		},
+		{
+			name:   "every missing answer listed",
+			config: ConfirmationConfig{QualificationAnswers: map[string]string{"3": "aucun", "99": "oui"}},
+			wantMissing: []string{
+				"qualification_answers: question 1 (\"Avez-vous plus de 55 ans ?\")",
+				"qualification_answers: question 2 (\"Êtes-vous un professionnel de santé ?\")",
+				"custom_fields: field 10 (\"Numéro de sécurité sociale\")",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			confirmation, err := test.config.confirmation(appointment)
+			if len(test.wantMissing) > 0 {
+				if !errors.Is(err, ErrMissingAnswers) {
+					t.Fatalf("confirmation() error = %v, want ErrMissingAnswers", err)
+				}
+				wantErr := strings.Join(test.wantMissing, ", ")
+				if !strings.HasSuffix(err.Error(), ": "+wantErr) {
+					t.Errorf("confirmation() error = %v, want it to list %s", err, wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("confirmation() error = %v", err)
+			}
+			if !maps.Equal(confirmation.QualificationAnswers, test.wantQualificationAnswers) {
+				t.Errorf("confirmation() qualification answers = %v, want %v", confirmation.QualificationAnswers,
+					test.wantQualificationAnswers)
+			}
+			if !maps.Equal(confirmation.CustomFieldsValues, test.wantCustomFieldsValues) {
+				t.Errorf("confirmation() custom fields values = %v, want %v", confirmation.CustomFieldsValues,
+					test.wantCustomFieldsValues)
+			}
+		})
+	}
+}
+
+func TestConflictingAnswerKeysRejected(t *testing.T) {
+	config := DefaultConfig()
+	config.Confirmation = ConfirmationConfig{
+		QualificationAnswers: map[string]string{
+			"Avez-vous plus de 55 ans ?":   "oui",
+			"avez-vous plus de 55 ans ?  ": "non",
+			"1":                            "oui",
+		},
+		CustomFields: map[string]string{"Numéro": "1", "NUMÉRO": "2", "Autre": "3"},
+	}
+
+	err := config.ValidateWithoutCenters()
+	if err == nil {
+		t.Fatal("ValidateWithoutCenters() error = nil, want an error")
+	}
+	for _, want := range []string{
+		"confirmation.qualification_answers: \"Avez-vous plus de 55 ans ?\" and " +
+			"\"avez-vous plus de 55 ans ?  \" only differ by case",
+		"confirmation.custom_fields: \"NUMÉRO\" and \"Numéro\" only differ by case",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateWithoutCenters() error = %v, want it to contain %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "\"Autre\"") || strings.Contains(err.Error(), "\"1\"") {
+		t.Errorf("ValidateWithoutCenters() error = %v, reported distinct keys", err)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ControlApi serves the local HTTP API controlling a running search: centers, workers, slot sightings and patients.
+type ControlApi struct {
+	scheduler   *Scheduler
+	coordinator *BookingCoordinator
+	ranking     *CenterRanking
+	monitor     *Monitor
+	rootUrl     string
+	token       string
+}
+
+// centerResponse is the state of a vaccination center returned by the control API.
+type centerResponse struct {
+	Name string `json:"name"`
+	*VaccinationCenter
+	Paused         bool      `json:"paused"`
+	LastDispatched time.Time `json:"last_dispatched"`
+	CenterState
+	// DistanceKm is the distance between home and the center, -1 if unknown
+	DistanceKm float64 `json:"distance_km"`
+}
+
+type patientResponse struct {
+	Account string        `json:"account"`
+	Patient PatientConfig `json:"patient"`
+}
+
+type stopSearchRequest struct {
+	Account string `json:"account"`
+	// Patient is the patient to stop searching for, omitted for the first patient of the account
+	Patient PatientConfig `json:"patient"`
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnknownCenter), errors.Is(err, ErrUnknownPatient):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, ErrCenterExists):
+		statusCode = http.StatusConflict
+	case errors.Is(err, ErrSchedulerStopped), errors.Is(err, ErrStopped):
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeJson(w, statusCode, map[string]string{"error": err.Error()})
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeJson(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
+
+func (a *ControlApi) getStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, a.scheduler.Status())
+}
+
+func (a *ControlApi) getCenters(w http.ResponseWriter, _ *http.Request) {
+	centers, err := a.scheduler.Centers()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]centerResponse, len(centers))
+	for i, center := range centers {
+		distance, ok := a.ranking.Distance(center.Center)
+		if !ok {
+			distance = -1
+		}
+		response[i] = centerResponse{
+			Name:              center.Center.Name,
+			VaccinationCenter: center.Center,
+			Paused:            center.Paused,
+			LastDispatched:    center.LastDispatched,
+			CenterState:       a.monitor.Center(center.Center.Name),
+			DistanceKm:        math.Round(distance*10) / 10,
+		}
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func (a *ControlApi) addCenter(w http.ResponseWriter, r *http.Request) {
+	var center VaccinationCenter
+	if err := json.NewDecoder(r.Body).Decode(&center); err != nil {
+		writeBadRequest(w, fmt.Errorf("invalid vaccination center: %w", err))
+		return
+	}
+	newCenter, err := newVaccinationCenter(center, a.rootUrl)
+	if err != nil {
+		writeBadRequest(w, fmt.Errorf("invalid vaccination center: %w", err))
+		return
+	}
+
+	if err := a.scheduler.AddCenter(newCenter); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, centerResponse{Name: newCenter.Name, VaccinationCenter: newCenter,
+		DistanceKm: -1})
+}
+
+func (a *ControlApi) removeCenter(w http.ResponseWriter, r *http.Request) {
+	if err := a.scheduler.RemoveCenter(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ControlApi) pauseCenter(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := a.scheduler.PauseCenter(r.PathValue("name"), paused); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *ControlApi) checkCenter(w http.ResponseWriter, r *http.Request) {
+	if err := a.scheduler.CheckNow(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *ControlApi) pause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := a.scheduler.Pause(paused); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *ControlApi) getWorkers(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, a.monitor.Workers())
+}
+
+func (a *ControlApi) getSightings(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, a.monitor.Sightings())
+}
+
+func (a *ControlApi) getPatients(w http.ResponseWriter, _ *http.Request) {
+	pendingPatients, err := a.coordinator.PendingPatients()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := []patientResponse{}
+	for username, patients := range pendingPatients {
+		for _, patient := range patients {
+			response = append(response, patientResponse{Account: username, Patient: patient})
+		}
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func (a *ControlApi) stopSearch(w http.ResponseWriter, r *http.Request) {
+	var request stopSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeBadRequest(w, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	if err := a.coordinator.StopSearch(request.Account, request.Patient); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler returns the HTTP handler of the control API. If a token is configured, every request must carry it as a
+// bearer token.
+func (a *ControlApi) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", a.getStatus)
+	mux.HandleFunc("POST /pause", a.pause(true))
+	mux.HandleFunc("POST /resume", a.pause(false))
+	mux.HandleFunc("GET /centers", a.getCenters)
+	mux.HandleFunc("POST /centers", a.addCenter)
+	mux.HandleFunc("DELETE /centers/{name}", a.removeCenter)
+	mux.HandleFunc("POST /centers/{name}/pause", a.pauseCenter(true))
+	mux.HandleFunc("POST /centers/{name}/resume", a.pauseCenter(false))
+	mux.HandleFunc("POST /centers/{name}/check", a.checkCenter)
+	mux.HandleFunc("GET /workers", a.getWorkers)
+	mux.HandleFunc("GET /sightings", a.getSightings)
+	mux.HandleFunc("GET /patients", a.getPatients)
+	mux.HandleFunc("POST /patients/stop", a.stopSearch)
+
+	if a.token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJson(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func NewControlApi(config *Config, scheduler *Scheduler, coordinator *BookingCoordinator, ranking *CenterRanking,
+	monitor *Monitor) *ControlApi {
+	return &ControlApi{
+		scheduler:   scheduler,
+		coordinator: coordinator,
+		ranking:     ranking,
+		monitor:     monitor,
+		rootUrl:     config.DoctolibUrl,
+		token:       config.Control.Token,
+	}
+}
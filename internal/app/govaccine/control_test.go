@@ -0,0 +1,291 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type controlTest struct {
+	scheduler *Scheduler
+	server    *httptest.Server
+}
+
+// newControlTest serves the control API of a running search of two centers, for two patients of a@example.com.
+func newControlTest(t *testing.T, token string) *controlTest {
+	t.Helper()
+	config := DefaultConfig()
+	config.Control.Token = token
+	config.Scheduling.MinWorkers = 0 // No job is dispatched ahead
+	config.Scheduling.TargetLatency = 0
+	config.Accounts = []AccountConfig{{Username: "a@example.com", Patients: []PatientConfig{
+		{FirstName: "Alice", LastName: "Martin"},
+		{Id: 42},
+	}}}
+	var centers []*VaccinationCenter
+	for _, name := range []string{"centre-a", "centre-b"} {
+		center, err := newVaccinationCenter(VaccinationCenter{Url: "https://www.doctolib.fr/c/paris/" + name},
+			config.DoctolibUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		centers = append(centers, center)
+	}
+
+	stop := make(chan bool)
+	coordinator := NewBookingCoordinator(config.Accounts, stop)
+	go coordinator.Run()
+	t.Cleanup(coordinator.Shutdown)
+	ranking := NewCenterRanking(config)
+	scheduler := NewScheduler(centers, config, ranking, stop, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go scheduler.Run(NewWorkerPool(nil, nil, nil))
+
+	monitor := NewMonitor()
+	monitor.AddWorker("Worker 1", "a@example.com")
+	monitor.StartCheck("Worker 1", "centre-a")
+	monitor.RecordSighting("Worker 1", "centre-a", "Pfizer", 3, "2021-06-02T09:00:00.000+02:00")
+
+	api := NewControlApi(config, scheduler, coordinator, ranking, monitor)
+	server := httptest.NewServer(api.Handler())
+	t.Cleanup(server.Close)
+
+	return &controlTest{scheduler: scheduler, server: server}
+}
+
+func (c *controlTest) do(t *testing.T, method string, path string, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	responseBytes, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(responseBytes)
+}
+
+func TestControlApiRoutes(t *testing.T) {
+	control := newControlTest(t, "")
+
+	// The steps depend on each other: the search is paused first so that no center is dispatched
+	steps := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   []string
+	}{
+		{method: "POST", path: "/pause", wantStatus: http.StatusNoContent},
+		{method: "GET", path: "/status", wantStatus: http.StatusOK, wantBody: []string{`"paused":true`}},
+		{
+			method:     "GET",
+			path:       "/centers",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"name":"centre-a"`, `"name":"centre-b"`, `"slots_seen":3`, `"distance_km":-1`},
+		},
+		{
+			method:     "POST",
+			path:       "/centers",
+			body:       `{"url": "https://www.doctolib.fr/c/paris/centre-c", "priority": 2}`,
+			wantStatus: http.StatusCreated,
+			wantBody:   []string{`"name":"centre-c"`, `"priority":2`},
+		},
+		{
+			method:     "POST",
+			path:       "/centers",
+			body:       `{"url": "https://www.doctolib.fr/c/paris/centre-c"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{method: "POST", path: "/centers", body: `{"url": `, wantStatus: http.StatusBadRequest},
+		{
+			method:     "POST",
+			path:       "/centers",
+			body:       `{"url": "https://example.com/c/paris/centre-d"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   []string{"doesn't match the Doctolib website"},
+		},
+		{method: "DELETE", path: "/centers/centre-c", wantStatus: http.StatusNoContent},
+		{method: "DELETE", path: "/centers/centre-c", wantStatus: http.StatusNotFound},
+		{method: "POST", path: "/centers/centre-a/pause", wantStatus: http.StatusNoContent},
+		{method: "GET", path: "/centers", wantStatus: http.StatusOK, wantBody: []string{`"paused":true`}},
+		{method: "POST", path: "/centers/centre-a/resume", wantStatus: http.StatusNoContent},
+		{method: "POST", path: "/centers/unknown/pause", wantStatus: http.StatusNotFound},
+		{method: "POST", path: "/centers/unknown/resume", wantStatus: http.StatusNotFound},
+		{method: "POST", path: "/centers/unknown/check", wantStatus: http.StatusNotFound},
+		{
+			method:     "GET",
+			path:       "/workers",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"name":"Worker 1"`, `"account":"a@example.com"`, `"center":"centre-a"`},
+		},
+		{
+			method:     "GET",
+			path:       "/sightings",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"center":"centre-a"`, `"total":3`, `"motive":"Pfizer"`},
+		},
+		{
+			method:     "GET",
+			path:       "/patients",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"first_name":"Alice"`, `"id":42`},
+		},
+		{
+			method:     "POST",
+			path:       "/patients/stop",
+			body:       `{"account": "a@example.com", "patient": {"first_name": "Alice", "last_name": "Martin"}}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			method:     "POST",
+			path:       "/patients/stop",
+			body:       `{"account": "a@example.com", "patient": {"first_name": "Alice", "last_name": "Martin"}}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{method: "POST", path: "/patients/stop", body: `not json`, wantStatus: http.StatusBadRequest},
+		{
+			method:     "GET",
+			path:       "/patients",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`[{"account":"a@example.com","patient":{"id":42}}]`},
+		},
+		{method: "GET", path: "/unknown", wantStatus: http.StatusNotFound},
+		{method: "GET", path: "/pause", wantStatus: http.StatusMethodNotAllowed},
+		{method: "PUT", path: "/centers", wantStatus: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/centers/centre-a/check", wantStatus: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/patients/stop", wantStatus: http.StatusMethodNotAllowed},
+		{method: "POST", path: "/resume", wantStatus: http.StatusNoContent},
+		{method: "GET", path: "/status", wantStatus: http.StatusOK, wantBody: []string{`"paused":false`}},
+	}
+
+	for _, step := range steps {
+		statusCode, body := control.do(t, step.method, step.path, step.body, nil)
+		if statusCode != step.wantStatus {
+			t.Errorf("%s %s: got status %d, want %d (body %s)", step.method, step.path, statusCode, step.wantStatus,
+				body)
+		}
+		for _, want := range step.wantBody {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s %s: body %s doesn't contain %s", step.method, step.path, body, want)
+			}
+		}
+		if statusCode >= 400 && statusCode != http.StatusMethodNotAllowed && step.path != "/unknown" {
+			var response map[string]string
+			if err := json.Unmarshal([]byte(body), &response); err != nil || response["error"] == "" {
+				t.Errorf("%s %s: expected a JSON error, got %s", step.method, step.path, body)
+			}
+		}
+	}
+}
+
+func TestControlApiCheckCenter(t *testing.T) {
+	control := newControlTest(t, "")
+	// A center is checked right away, even if paused
+	for _, path := range []string{"/pause", "/centers/centre-b/pause", "/centers/centre-b/check"} {
+		if statusCode, body := control.do(t, "POST", path, "", nil); statusCode >= 300 {
+			t.Fatalf("POST %s: got status %d (body %s)", path, statusCode, body)
+		}
+	}
+	select {
+	case center := <-control.scheduler.Jobs():
+		if center.Name != "centre-b" {
+			t.Errorf("dispatched %s, want centre-b", center.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("centre-b wasn't dispatched")
+	}
+}
+
+func TestControlApiToken(t *testing.T) {
+	control := newControlTest(t, "secret")
+
+	tests := []struct {
+		authorization string
+		wantStatus    int
+	}{
+		{authorization: "", wantStatus: http.StatusUnauthorized},
+		{authorization: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{authorization: "secret", wantStatus: http.StatusUnauthorized},
+		{authorization: "Basic c2VjcmV0", wantStatus: http.StatusUnauthorized},
+		{authorization: "Bearer secret", wantStatus: http.StatusOK},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.authorization != "" {
+			header.Set("Authorization", test.authorization)
+		}
+		if statusCode, body := control.do(t, "GET", "/status", "", header); statusCode != test.wantStatus {
+			t.Errorf("Authorization %q: got status %d, want %d (body %s)", test.authorization, statusCode,
+				test.wantStatus, body)
+		}
+	}
+
+	// Even the routes which don't exist aren't disclosed
+	if statusCode, _ := control.do(t, "GET", "/unknown", "", nil); statusCode != http.StatusUnauthorized {
+		t.Errorf("GET /unknown without token: got status %d, want %d", statusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestControlAddressValidation(t *testing.T) {
+	tests := []struct {
+		address string
+		token   string
+		wantErr bool
+	}{
+		{address: "127.0.0.1:8090"},
+		{address: "localhost:8090"},
+		{address: "[::1]:8090"},
+		{address: ":8090", wantErr: true},
+		{address: "0.0.0.0:8090", wantErr: true},
+		{address: "192.168.1.10:8090", wantErr: true},
+		{address: "0.0.0.0:8090", token: "secret"},
+		{address: "localhost", wantErr: true},
+	}
+
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.Control = ControlConfig{Address: test.address, Token: test.token}
+		err := config.ValidateWithoutCenters()
+		if gotErr := err != nil && strings.Contains(err.Error(), "control.address"); gotErr != test.wantErr {
+			t.Errorf("address %s, token %q: got error %v, want a control.address error: %t", test.address,
+				test.token, err, test.wantErr)
+		}
+	}
+}
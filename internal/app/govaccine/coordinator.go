@@ -0,0 +1,484 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"strings"
+)
+
+var (
+	// ErrNoPendingPatient is returned when all the patients of an account already got an appointment.
+	ErrNoPendingPatient = errors.New("no more patient of the account waiting for an appointment")
+	// ErrBookingInProgress is returned when another worker is booking an appointment for the same account.
+	ErrBookingInProgress = errors.New("another worker is booking an appointment for the account")
+	// ErrStopped is returned once every patient got an appointment.
+	ErrStopped = errors.New("the booking coordinator stopped")
+	// ErrUnknownPatient is returned when stopping the search for a patient who isn't waiting for an appointment.
+	ErrUnknownPatient = errors.New("no such patient waiting for an appointment")
+	// ErrPatientBookingInProgress is returned when a worker of another account is booking an appointment for the
+	// same patient.
+	ErrPatientBookingInProgress = errors.New("another account is booking an appointment for the patient")
+	// ErrPatientAlreadyBooked is returned when the patient already got an appointment through another account.
+	ErrPatientAlreadyBooked = errors.New("the patient already got an appointment through another account")
+
+	errAlreadyHeld = errors.New("the appointment is already held")
+)
+
+// HeldAppointment is an appointment a patient already holds, replaced when a better slot is booked.
+type HeldAppointment struct {
+	Appointment doctolib.Appointment
+	// Candidate describes the appointment to compare it with the slots found
+	Candidate *Candidate
+	// Upgrade is true if the appointment was booked while searching, it is then replaced by any slot earlier enough
+	// instead of a slot better by the arbitration preferences
+	Upgrade bool
+}
+
+// BookingCoordinator keeps track of the patients still waiting for an appointment, per account, and makes sure
+// that a single worker books an appointment for an account at a time, and for a patient listed by several accounts
+// at a time, so that each patient gets one appointment. Workers ask it for a BookingTicket before booking, claim the
+// patient through the ticket, and report the outcome through the ticket.
+type BookingCoordinator struct {
+	requests      chan *bookingRequest
+	outcomes      chan *bookingOutcome
+	cancellations chan *bookingCancellation
+	holds         chan *appointmentHold
+	claims        chan *patientClaim
+	appointments  chan *patientAppointment
+	snapshots     chan chan map[string][]PatientConfig
+	shutdown      chan struct{}
+	stop          chan bool
+	pending       map[string][]PatientConfig
+	// held are the appointments already held by pending patients, by account username
+	held    map[string]map[PatientConfig]*HeldAppointment
+	booking map[string]bool
+	// bookingPatients are the usernames of the accounts booking an appointment for a patient
+	bookingPatients map[patientKey]string
+	// bookedPatients are the usernames of the accounts through which a patient got an appointment
+	bookedPatients map[patientKey]string
+}
+
+// patientKey identifies a person across accounts, as each account has its own master patient IDs.
+type patientKey string
+
+func newPatientKey(masterPatient *doctolib.MasterPatient) patientKey {
+	return patientKey(strings.Join([]string{
+		strings.ToLower(strings.TrimSpace(masterPatient.FirstName)),
+		strings.ToLower(strings.TrimSpace(masterPatient.LastName)),
+		masterPatient.Birthdate,
+	}, "\x00"))
+}
+
+type bookingRequest struct {
+	username string
+	reply    chan *bookingReply
+}
+
+type bookingReply struct {
+	ticket *BookingTicket
+	err    error
+}
+
+type bookingOutcome struct {
+	username string
+	// claimed is the patient claimed by the ticket, empty if none
+	claimed patientKey
+	// patient is the patient who got an appointment, nil if the booking failed
+	patient *PatientConfig
+	// held is the appointment patient got, if it keeps waiting for a better one
+	held *HeldAppointment
+}
+
+type bookingCancellation struct {
+	username string
+	patient  PatientConfig
+	reply    chan error
+}
+
+type appointmentHold struct {
+	username string
+	patient  PatientConfig
+	held     *HeldAppointment
+	reply    chan error
+}
+
+type patientClaim struct {
+	username string
+	patient  PatientConfig
+	key      patientKey
+	reply    chan error
+}
+
+type patientAppointment struct {
+	username string
+	key      patientKey
+}
+
+// BookingTicket allows a worker to book an appointment for one of the patients of an account.
+type BookingTicket struct {
+	coordinator *BookingCoordinator
+	username    string
+	patients    []PatientConfig
+	held        map[PatientConfig]*HeldAppointment
+	claimed     patientKey
+	done        bool
+}
+
+// Patients returns the patients of the account still waiting for an appointment. A zero PatientConfig stands for
+// the first patient of the account.
+func (t *BookingTicket) Patients() []PatientConfig {
+	return t.patients
+}
+
+// Held returns the appointment already held by patient, nil if none.
+func (t *BookingTicket) Held(patient PatientConfig) *HeldAppointment {
+	return t.held[patient]
+}
+
+func (t *BookingTicket) report(patient *PatientConfig, held *HeldAppointment) {
+	if t.done {
+		return
+	}
+	t.done = true
+
+	select {
+	case t.coordinator.outcomes <- &bookingOutcome{username: t.username, claimed: t.claimed, patient: patient,
+		held: held}:
+	case <-t.coordinator.stop:
+	}
+}
+
+// Claim makes sure that no worker of another account books an appointment for masterPatient, the master patient
+// of patient, until the ticket is done. If masterPatient got an appointment through another account, patient stops
+// waiting and ErrPatientAlreadyBooked is returned.
+func (t *BookingTicket) Claim(patient PatientConfig, masterPatient *doctolib.MasterPatient) error {
+	claim := &patientClaim{
+		username: t.username,
+		patient:  patient,
+		key:      newPatientKey(masterPatient),
+		reply:    make(chan error, 1),
+	}
+	select {
+	case t.coordinator.claims <- claim:
+	case <-t.coordinator.stop:
+		return ErrStopped
+	}
+
+	select {
+	case err := <-claim.reply:
+		if err == nil {
+			t.claimed = claim.key
+		}
+		return err
+	case <-t.coordinator.stop:
+		return ErrStopped
+	}
+}
+
+// Booked reports that patient got an appointment.
+func (t *BookingTicket) Booked(patient PatientConfig) {
+	t.report(&patient, nil)
+}
+
+// BookedAndHeld reports that patient got the appointment held, but keeps waiting for a better one.
+func (t *BookingTicket) BookedAndHeld(patient PatientConfig, held *HeldAppointment) {
+	t.report(&patient, held)
+}
+
+// Release gives the ticket back without booking anything. It does nothing if Booked or BookedAndHeld was called.
+func (t *BookingTicket) Release() {
+	t.report(nil, nil)
+}
+
+// RequestBooking asks for a ticket to book an appointment for a patient of the account of username.
+func (c *BookingCoordinator) RequestBooking(username string) (*BookingTicket, error) {
+	request := &bookingRequest{username: username, reply: make(chan *bookingReply, 1)}
+	select {
+	case c.requests <- request:
+	case <-c.stop:
+		return nil, ErrStopped
+	}
+
+	select {
+	case reply := <-request.reply:
+		return reply.ticket, reply.err
+	case <-c.stop:
+		return nil, ErrStopped
+	}
+}
+
+func (c *BookingCoordinator) handleRequest(request *bookingRequest) *bookingReply {
+	if len(c.pending[request.username]) == 0 {
+		return &bookingReply{err: ErrNoPendingPatient}
+	}
+	if c.booking[request.username] {
+		return &bookingReply{err: ErrBookingInProgress}
+	}
+
+	c.booking[request.username] = true
+	ticket := &BookingTicket{
+		coordinator: c,
+		username:    request.username,
+		patients:    append([]PatientConfig(nil), c.pending[request.username]...),
+		held:        make(map[PatientConfig]*HeldAppointment),
+	}
+	for patient, held := range c.held[request.username] {
+		ticket.held[patient] = held
+	}
+
+	return &bookingReply{ticket: ticket}
+}
+
+// HoldAppointment records that patient of the account of username already holds an appointment, so that it is
+// only replaced by a better one. It returns an error if the patient isn't waiting or already holds it.
+func (c *BookingCoordinator) HoldAppointment(username string, patient PatientConfig, held *HeldAppointment) error {
+	hold := &appointmentHold{username: username, patient: patient, held: held, reply: make(chan error, 1)}
+	select {
+	case c.holds <- hold:
+	case <-c.stop:
+		return ErrStopped
+	}
+
+	select {
+	case err := <-hold.reply:
+		return err
+	case <-c.stop:
+		return ErrStopped
+	}
+}
+
+func (c *BookingCoordinator) handleHold(hold *appointmentHold) error {
+	for _, patient := range c.pending[hold.username] {
+		if patient != hold.patient {
+			continue
+		}
+		if held := c.held[hold.username][hold.patient]; held != nil && held.Appointment.Id == hold.held.Appointment.Id {
+			return errAlreadyHeld
+		}
+		c.held[hold.username][hold.patient] = hold.held
+		return nil
+	}
+
+	return ErrUnknownPatient
+}
+
+func (c *BookingCoordinator) handleClaim(claim *patientClaim) error {
+	if username, ok := c.bookedPatients[claim.key]; ok && username != claim.username {
+		c.removePending(claim.username, claim.patient)
+		return ErrPatientAlreadyBooked
+	}
+	if username, ok := c.bookingPatients[claim.key]; ok && username != claim.username {
+		return ErrPatientBookingInProgress
+	}
+
+	c.bookingPatients[claim.key] = claim.username
+	return nil
+}
+
+// RecordAppointment records that masterPatient of the account of username already holds an appointment, so that
+// the other accounts listing the same patient don't book another one.
+func (c *BookingCoordinator) RecordAppointment(username string, masterPatient *doctolib.MasterPatient) {
+	select {
+	case c.appointments <- &patientAppointment{username: username, key: newPatientKey(masterPatient)}:
+	case <-c.stop:
+	}
+}
+
+func (c *BookingCoordinator) handleAppointment(appointment *patientAppointment) {
+	if _, ok := c.bookedPatients[appointment.key]; !ok {
+		c.bookedPatients[appointment.key] = appointment.username
+	}
+}
+
+// StopSearch stops looking for an appointment for patient of the account of username. A booking in progress for the
+// account isn't interrupted.
+func (c *BookingCoordinator) StopSearch(username string, patient PatientConfig) error {
+	cancellation := &bookingCancellation{username: username, patient: patient, reply: make(chan error, 1)}
+	select {
+	case c.cancellations <- cancellation:
+	case <-c.stop:
+		return ErrStopped
+	}
+
+	select {
+	case err := <-cancellation.reply:
+		return err
+	case <-c.stop: // The search for the last patient was stopped
+		return nil
+	}
+}
+
+// Shutdown stops the search for every patient. Bookings in progress aren't interrupted.
+func (c *BookingCoordinator) Shutdown() {
+	select {
+	case c.shutdown <- struct{}{}:
+	case <-c.stop:
+	}
+}
+
+// PendingPatients returns the patients still waiting for an appointment, by account username. A zero PatientConfig
+// stands for the first patient of the account.
+func (c *BookingCoordinator) PendingPatients() (map[string][]PatientConfig, error) {
+	snapshot := make(chan map[string][]PatientConfig, 1)
+	select {
+	case c.snapshots <- snapshot:
+	case <-c.stop:
+		return nil, ErrStopped
+	}
+
+	return <-snapshot, nil
+}
+
+func (c *BookingCoordinator) snapshot() map[string][]PatientConfig {
+	pending := make(map[string][]PatientConfig, len(c.pending))
+	for username, patients := range c.pending {
+		if len(patients) > 0 {
+			pending[username] = append([]PatientConfig(nil), patients...)
+		}
+	}
+	return pending
+}
+
+// removePending removes patient from the patients of username waiting for an appointment, and returns false if it
+// wasn't waiting.
+func (c *BookingCoordinator) removePending(username string, patient PatientConfig) bool {
+	var pending []PatientConfig
+	for _, pendingPatient := range c.pending[username] {
+		if pendingPatient != patient {
+			pending = append(pending, pendingPatient)
+		}
+	}
+	removed := len(pending) < len(c.pending[username])
+	c.pending[username] = pending
+	delete(c.held[username], patient)
+
+	return removed
+}
+
+// anyPending returns true if a patient is still waiting for an appointment.
+func (c *BookingCoordinator) anyPending() bool {
+	for _, patients := range c.pending {
+		if len(patients) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleOutcome records the outcome of a booking and returns false once every patient got an appointment.
+func (c *BookingCoordinator) handleOutcome(outcome *bookingOutcome) bool {
+	delete(c.booking, outcome.username)
+	if outcome.claimed != "" {
+		delete(c.bookingPatients, outcome.claimed)
+	}
+	if outcome.patient == nil {
+		return true
+	}
+	if outcome.claimed != "" {
+		c.bookedPatients[outcome.claimed] = outcome.username
+	}
+	if outcome.held != nil {
+		_ = c.handleHold(&appointmentHold{username: outcome.username, patient: *outcome.patient, held: outcome.held})
+		return true
+	}
+
+	c.removePending(outcome.username, *outcome.patient)
+	return c.anyPending()
+}
+
+// Run handles the booking requests and outcomes of the workers, and closes the stop channel once every patient got
+// an appointment or on Shutdown.
+func (c *BookingCoordinator) Run() {
+	for {
+		select {
+		case request := <-c.requests:
+			request.reply <- c.handleRequest(request)
+		case outcome := <-c.outcomes:
+			if !c.handleOutcome(outcome) {
+				close(c.stop)
+				return
+			}
+		case cancellation := <-c.cancellations:
+			if !c.removePending(cancellation.username, cancellation.patient) {
+				cancellation.reply <- ErrUnknownPatient
+				continue
+			}
+			cancellation.reply <- nil
+			if !c.anyPending() {
+				close(c.stop)
+				return
+			}
+		case hold := <-c.holds:
+			hold.reply <- c.handleHold(hold)
+		case claim := <-c.claims:
+			claim.reply <- c.handleClaim(claim)
+			if !c.anyPending() {
+				close(c.stop)
+				return
+			}
+		case appointment := <-c.appointments:
+			c.handleAppointment(appointment)
+		case snapshot := <-c.snapshots:
+			snapshot <- c.snapshot()
+		case <-c.shutdown:
+			close(c.stop)
+			return
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func NewBookingCoordinator(accounts []AccountConfig, stop chan bool) *BookingCoordinator {
+	coordinator := &BookingCoordinator{
+		requests:        make(chan *bookingRequest),
+		outcomes:        make(chan *bookingOutcome),
+		cancellations:   make(chan *bookingCancellation),
+		holds:           make(chan *appointmentHold),
+		claims:          make(chan *patientClaim),
+		appointments:    make(chan *patientAppointment),
+		snapshots:       make(chan chan map[string][]PatientConfig),
+		shutdown:        make(chan struct{}),
+		stop:            stop,
+		pending:         make(map[string][]PatientConfig),
+		held:            make(map[string]map[PatientConfig]*HeldAppointment),
+		booking:         make(map[string]bool),
+		bookingPatients: make(map[patientKey]string),
+		bookedPatients:  make(map[patientKey]string),
+	}
+	for _, account := range accounts {
+		coordinator.held[account.Username] = make(map[PatientConfig]*HeldAppointment)
+		if len(account.Patients) == 0 {
+			coordinator.pending[account.Username] = []PatientConfig{{}}
+			continue
+		}
+
+		coordinator.pending[account.Username] = append([]PatientConfig(nil), account.Patients...)
+	}
+
+	return coordinator
+}
@@ -0,0 +1,176 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"testing"
+	"time"
+)
+
+// startCoordinator runs a coordinator for accounts, stopped at the end of the test.
+func startCoordinator(t *testing.T, accounts []AccountConfig) (*BookingCoordinator, chan bool) {
+	t.Helper()
+	stop := make(chan bool)
+	coordinator := NewBookingCoordinator(accounts, stop)
+	go coordinator.Run()
+	t.Cleanup(coordinator.Shutdown)
+
+	return coordinator, stop
+}
+
+func requestBooking(t *testing.T, coordinator *BookingCoordinator, username string) *BookingTicket {
+	t.Helper()
+	ticket, err := coordinator.RequestBooking(username)
+	if err != nil {
+		t.Fatalf("RequestBooking(%s) error = %v", username, err)
+	}
+
+	return ticket
+}
+
+func TestBookingCoordinatorSharedPatient(t *testing.T) {
+	alice := PatientConfig{FirstName: "Alice", LastName: "Martin"}
+	bob := PatientConfig{FirstName: "Bob", LastName: "Martin"}
+	coordinator, stop := startCoordinator(t, []AccountConfig{
+		{Username: "a@example.com", Patients: []PatientConfig{alice}},
+		{Username: "b@example.com", Patients: []PatientConfig{alice, bob}},
+	})
+	// Each account has its own master patient IDs
+	aliceOfA := &doctolib.MasterPatient{Id: 1, FirstName: "Alice", LastName: "Martin", Birthdate: "1950-01-01"}
+	aliceOfB := &doctolib.MasterPatient{Id: 2, FirstName: "alice ", LastName: "MARTIN", Birthdate: "1950-01-01"}
+	bobOfB := &doctolib.MasterPatient{Id: 3, FirstName: "Bob", LastName: "Martin", Birthdate: "1952-01-01"}
+
+	ticketA := requestBooking(t, coordinator, "a@example.com")
+	if err := ticketA.Claim(alice, aliceOfA); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	// Another account can't book for the same patient meanwhile, but can for another one
+	ticketB := requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(alice, aliceOfB); !errors.Is(err, ErrPatientBookingInProgress) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrPatientBookingInProgress)
+	}
+	if err := ticketB.Claim(bob, bobOfB); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	ticketB.Release()
+
+	ticketA.Booked(alice)
+
+	// The patient stops waiting through the other account once booked
+	ticketB = requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(alice, aliceOfB); !errors.Is(err, ErrPatientAlreadyBooked) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrPatientAlreadyBooked)
+	}
+	ticketB.Release()
+	pending, err := coordinator.PendingPatients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending["a@example.com"]) != 0 || len(pending["b@example.com"]) != 1 || pending["b@example.com"][0] != bob {
+		t.Errorf("PendingPatients() = %v, want only %v for b@example.com", pending, bob)
+	}
+
+	ticketB = requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(bob, bobOfB); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	ticketB.Booked(bob)
+	select {
+	case <-stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the coordinator didn't stop once every patient got an appointment")
+	}
+}
+
+func TestBookingCoordinatorClaimReleased(t *testing.T) {
+	alice := PatientConfig{FirstName: "Alice", LastName: "Martin"}
+	coordinator, _ := startCoordinator(t, []AccountConfig{
+		{Username: "a@example.com", Patients: []PatientConfig{alice}},
+		{Username: "b@example.com", Patients: []PatientConfig{alice}},
+	})
+	masterPatient := &doctolib.MasterPatient{FirstName: "Alice", LastName: "Martin", Birthdate: "1950-01-01"}
+
+	ticketA := requestBooking(t, coordinator, "a@example.com")
+	if err := ticketA.Claim(alice, masterPatient); err != nil {
+		t.Fatal(err)
+	}
+	ticketA.Release()
+
+	// A failed booking lets the other accounts book for the patient
+	ticketB := requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(alice, masterPatient); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	ticketB.Release()
+
+	// Homonyms are different patients
+	coordinator.RecordAppointment("a@example.com", masterPatient)
+	ticketB = requestBooking(t, coordinator, "b@example.com")
+	homonym := &doctolib.MasterPatient{FirstName: "Alice", LastName: "Martin", Birthdate: "1990-01-01"}
+	if err := ticketB.Claim(alice, homonym); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	ticketB.Release()
+
+	// An appointment held by the patient through another account counts as booked
+	ticketB = requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(alice, masterPatient); !errors.Is(err, ErrPatientAlreadyBooked) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrPatientAlreadyBooked)
+	}
+	ticketB.Release()
+}
+
+func TestBookingCoordinatorUpgradeSameAccount(t *testing.T) {
+	alice := PatientConfig{FirstName: "Alice", LastName: "Martin"}
+	coordinator, _ := startCoordinator(t, []AccountConfig{
+		{Username: "a@example.com", Patients: []PatientConfig{alice}},
+		{Username: "b@example.com", Patients: []PatientConfig{alice}},
+	})
+	masterPatient := &doctolib.MasterPatient{FirstName: "Alice", LastName: "Martin", Birthdate: "1950-01-01"}
+
+	ticketA := requestBooking(t, coordinator, "a@example.com")
+	if err := ticketA.Claim(alice, masterPatient); err != nil {
+		t.Fatal(err)
+	}
+	ticketA.BookedAndHeld(alice, &HeldAppointment{Appointment: doctolib.Appointment{Id: "1"}, Upgrade: true})
+
+	// The account holding the appointment keeps looking for a better one, the other one stops
+	ticketA = requestBooking(t, coordinator, "a@example.com")
+	if err := ticketA.Claim(alice, masterPatient); err != nil {
+		t.Errorf("Claim() error = %v", err)
+	}
+	if held := ticketA.Held(alice); held == nil || held.Appointment.Id != "1" {
+		t.Errorf("Held() = %+v, want appointment 1", held)
+	}
+	ticketA.Release()
+
+	ticketB := requestBooking(t, coordinator, "b@example.com")
+	if err := ticketB.Claim(alice, masterPatient); !errors.Is(err, ErrPatientAlreadyBooked) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrPatientAlreadyBooked)
+	}
+	ticketB.Release()
+}
@@ -0,0 +1,255 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	// DefaultPasswordEnv is the environment variable read when no password source is configured
+	DefaultPasswordEnv = "GOVACCINE_PASSWORD"
+
+	passwordSourceEnv     = "env"
+	passwordSourceFile    = "file"
+	passwordSourceCommand = "command"
+	passwordSourcePrompt  = "prompt"
+	redacted              = "[REDACTED]"
+)
+
+// Secret holds a password. It is never printed, logged nor marshalled, and can be wiped from memory.
+type Secret struct {
+	value []byte
+}
+
+// PasswordPrompter asks the user for the password of username without echoing it.
+type PasswordPrompter func(username string) ([]byte, error)
+
+func (s *Secret) Bytes() []byte {
+	return s.value
+}
+
+func (s *Secret) String() string {
+	return redacted
+}
+
+func (s *Secret) GoString() string {
+	return redacted
+}
+
+func (s *Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// Wipe overwrites the password with zeros.
+func (s *Secret) Wipe() {
+	for i := range s.value {
+		s.value[i] = 0
+	}
+	s.value = nil
+}
+
+// parsePasswordSource checks a password source: "env:NAME", "file:PATH", "command:COMMAND" or "prompt".
+func parsePasswordSource(source string) (kind string, value string, err error) {
+	kind, value, _ = strings.Cut(source, ":")
+	switch kind {
+	case passwordSourceEnv, passwordSourceFile, passwordSourceCommand:
+		if value == "" {
+			return "", "", fmt.Errorf("missing value after \"%s:\"", kind)
+		}
+	case passwordSourcePrompt:
+		if value != "" {
+			return "", "", errors.New("\"prompt\" doesn't take a value")
+		}
+	default:
+		return "", "", fmt.Errorf("unknown password source \"%s\" (expected \"env:NAME\", \"file:PATH\", "+
+			"\"command:COMMAND\" or \"prompt\")", source)
+	}
+
+	return kind, value, nil
+}
+
+// firstLine returns the first line of content, which holds the password like with pass, and wipes the other ones.
+func firstLine(content []byte) []byte {
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return content
+	}
+
+	line := append([]byte(nil), content[:i]...)
+	for i := range content {
+		content[i] = 0
+	}
+	return line
+}
+
+func readPasswordFile(passwordFilepath string) ([]byte, error) {
+	info, err := os.Stat(passwordFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat file %s: %s", passwordFilepath, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf(
+			"file %s is accessible by other users (mode %s), run chmod 600 on it",
+			passwordFilepath, info.Mode().Perm())
+	}
+
+	content, err := ioutil.ReadFile(passwordFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file %s: %s", passwordFilepath, err)
+	}
+
+	return firstLine(content), nil
+}
+
+func runPasswordCommand(command string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	// The command may need to ask for a passphrase (e.g. gpg-agent for pass)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("command \"%s\" failed: %s", command, err)
+	}
+
+	return firstLine(output), nil
+}
+
+// passwordSource returns the source of the password of account, "" if the password is inline.
+func passwordSource(account *AccountConfig) string {
+	if account.PasswordSource != "" || account.Password != "" {
+		return account.PasswordSource
+	}
+	if os.Getenv(DefaultPasswordEnv) != "" {
+		return passwordSourceEnv + ":" + DefaultPasswordEnv
+	}
+
+	return passwordSourcePrompt
+}
+
+// isSharedPasswordSource reports whether source gives the same password to every account using it, unlike the prompt
+// and inline passwords.
+func isSharedPasswordSource(source string) bool {
+	kind, _, err := parsePasswordSource(source)
+	return err == nil && kind != passwordSourcePrompt
+}
+
+func readPassword(account *AccountConfig, source string, prompt PasswordPrompter) ([]byte, error) {
+	if source == "" {
+		return []byte(account.Password), nil
+	}
+
+	kind, value, err := parsePasswordSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var password []byte
+	switch kind {
+	case passwordSourceEnv:
+		password = []byte(os.Getenv(value))
+	case passwordSourceFile:
+		password, err = readPasswordFile(value)
+	case passwordSourceCommand:
+		password, err = runPasswordCommand(value)
+	case passwordSourcePrompt:
+		if prompt == nil {
+			return nil, errors.New("no interactive prompt available")
+		}
+		password, err = prompt(account.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	password = bytes.TrimRight(password, "\r\n")
+	if len(password) == 0 {
+		return nil, fmt.Errorf("password from %s is empty", kind)
+	}
+
+	return password, nil
+}
+
+// LoadCredentials reads the password of every account from its source. Accounts sharing the same environment variable,
+// file or command only read it once, and the password of a username is only prompted once. The environment variables
+// read are unset once every account got its password.
+func (c *Config) LoadCredentials(prompt PasswordPrompter) error {
+	sources := make([]string, len(c.Accounts))
+	for i := range c.Accounts {
+		sources[i] = passwordSource(&c.Accounts[i])
+	}
+	// Don't pass the passwords on to notification commands
+	defer func() {
+		for _, source := range sources {
+			if kind, value, err := parsePasswordSource(source); err == nil && kind == passwordSourceEnv {
+				_ = os.Unsetenv(value)
+			}
+		}
+	}()
+
+	for i := range c.Accounts {
+		account := &c.Accounts[i]
+		for j := 0; j < i && account.secret == nil; j++ {
+			if sources[j] == sources[i] && (isSharedPasswordSource(sources[i]) ||
+				c.Accounts[j].Username == account.Username) {
+				account.secret = c.Accounts[j].secret
+			}
+		}
+		if account.secret != nil {
+			continue
+		}
+
+		password, err := readPassword(account, sources[i], prompt)
+		if err != nil {
+			return fmt.Errorf("govaccine.Config.LoadCredentials(): cannot get the password of %s: %w",
+				account.Username, err)
+		}
+		account.secret = &Secret{value: password}
+		account.Password = ""
+	}
+
+	return nil
+}
+
+// WipeCredentials wipes the passwords of all the accounts from memory.
+func (c *Config) WipeCredentials() {
+	for i := range c.Accounts {
+		if c.Accounts[i].secret != nil {
+			c.Accounts[i].secret.Wipe()
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadCredentialsSharedEnv(t *testing.T) {
+	t.Setenv(DefaultPasswordEnv, "default-secret")
+	t.Setenv("GOVACCINE_TEST_PASSWORD", "shared-secret")
+	config := &Config{Accounts: []AccountConfig{
+		{Username: "a@example.com", PasswordSource: "env:GOVACCINE_TEST_PASSWORD"},
+		{Username: "b@example.com", PasswordSource: "env:GOVACCINE_TEST_PASSWORD"},
+		{Username: "c@example.com"},
+		{Username: "d@example.com"},
+	}}
+	prompt := func(username string) ([]byte, error) {
+		t.Errorf("unexpected prompt for %s", username)
+		return []byte("prompted"), nil
+	}
+
+	if err := config.LoadCredentials(prompt); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+	want := []string{"shared-secret", "shared-secret", "default-secret", "default-secret"}
+	for i, account := range config.Accounts {
+		if got := string(account.secret.Bytes()); got != want[i] {
+			t.Errorf("password of %s = %q, want %q", account.Username, got, want[i])
+		}
+	}
+	for _, name := range []string{DefaultPasswordEnv, "GOVACCINE_TEST_PASSWORD"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s is still set", name)
+		}
+	}
+}
+
+func TestLoadCredentialsSharedCommand(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	source := fmt.Sprintf("command:echo run >> %s; printf 'shared-secret\\nlogin: a@example.com\\n'", runs)
+	config := &Config{Accounts: []AccountConfig{
+		{Username: "a@example.com", PasswordSource: source},
+		{Username: "b@example.com", PasswordSource: source},
+		{Username: "c@example.com", PasswordSource: "prompt"},
+		{Username: "c@example.com", PasswordSource: "prompt"},
+		{Username: "d@example.com", PasswordSource: "prompt"},
+		{Username: "e@example.com", Password: "inline-e"},
+		{Username: "f@example.com", Password: "inline-f"},
+	}}
+	var prompted []string
+	prompt := func(username string) ([]byte, error) {
+		prompted = append(prompted, username)
+		return []byte("prompted-" + username[:1] + "\n"), nil
+	}
+
+	if err := config.LoadCredentials(prompt); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+	want := []string{"shared-secret", "shared-secret", "prompted-c", "prompted-c", "prompted-d", "inline-e",
+		"inline-f"}
+	for i, account := range config.Accounts {
+		if got := string(account.secret.Bytes()); got != want[i] {
+			t.Errorf("password of account %d (%s) = %q, want %q", i, account.Username, got, want[i])
+		}
+	}
+	if !slices.Equal(prompted, []string{"c@example.com", "d@example.com"}) {
+		t.Errorf("prompted %v, want [c@example.com d@example.com]", prompted)
+	}
+	if runsContent, err := os.ReadFile(runs); err != nil || string(runsContent) != "run\n" {
+		t.Errorf("the command ran %q (error %v), want once", runsContent, err)
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	dir := t.TempDir()
+	writePasswordFile := func(name string, content string, mode os.FileMode) string {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr string
+	}{
+		{name: "private file", source: "file:" + writePasswordFile("private", "secret\n", 0600), want: "secret"},
+		{name: "read-only file", source: "file:" + writePasswordFile("read-only", "secret", 0400), want: "secret"},
+		{
+			name:   "file of several lines",
+			source: "file:" + writePasswordFile("lines", "secret\r\nusername: a@example.com\n", 0600),
+			want:   "secret",
+		},
+		{
+			name:    "file readable by the group",
+			source:  "file:" + writePasswordFile("group", "secret\n", 0640),
+			wantErr: "accessible by other users",
+		},
+		{
+			name:    "file readable by others",
+			source:  "file:" + writePasswordFile("others", "secret\n", 0604),
+			wantErr: "accessible by other users",
+		},
+		{
+			name:    "file writable by the group",
+			source:  "file:" + writePasswordFile("group-writable", "secret\n", 0620),
+			wantErr: "accessible by other users",
+		},
+		{name: "empty file", source: "file:" + writePasswordFile("empty", "\n", 0600), wantErr: "empty"},
+		{name: "missing file", source: "file:" + filepath.Join(dir, "missing"), wantErr: "cannot stat"},
+		{name: "command", source: "command:printf secret", want: "secret"},
+		{name: "command of several lines", source: "command:printf 'secret\\nother\\nlines\\n'", want: "secret"},
+		{name: "command printing nothing", source: "command:true", wantErr: "empty"},
+		{name: "failing command", source: "command:exit 3", wantErr: "failed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("file permissions and sh aren't available on Windows")
+			}
+
+			password, err := readPassword(&AccountConfig{Username: "a@example.com"}, test.source, nil)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("readPassword() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPassword() error = %v", err)
+			}
+			if string(password) != test.want {
+				t.Errorf("readPassword() = %q, want %q", password, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VaccinationSpeciality is the Doctolib speciality of COVID-19 vaccination centers.
+const VaccinationSpeciality = "vaccination-covid-19"
+
+// DiscoveryQuery describes the vaccination centers to look for.
+type DiscoveryQuery struct {
+	Speciality string
+	// Location is a city or a postcode
+	Location string
+	// Radius is the maximum distance (in km) between Location and the centers, 0 if unlimited
+	Radius   float64
+	MaxPages int
+	// Sleep is the pause between two search requests
+	Sleep time.Duration
+}
+
+// DiscoverVaccinationCenters searches the vaccination centers around query.Location, by page of search results until
+// there are no more or query.MaxPages were read. If query.Radius isn't 0, query.Location is geocoded with geocoder
+// and centers are sorted by distance.
+func DiscoverVaccinationCenters(doctolibClient *doctolib.Client, geocoder *geo.Geocoder,
+	query *DiscoveryQuery) ([]*VaccinationCenter, error) {
+	var origin *geo.Location
+	if query.Radius > 0 {
+		var err error
+		if origin, err = geocoder.Geocode(query.Location); err != nil {
+			return nil, fmt.Errorf("govaccine.DiscoverVaccinationCenters(): cannot locate %s: %w", query.Location,
+				err)
+		}
+	}
+
+	var centers []*VaccinationCenter
+	distances := make(map[*VaccinationCenter]float64)
+	seenNames := make(map[string]bool)
+	for page := 1; page <= query.MaxPages; page++ {
+		if page > 1 {
+			time.Sleep(query.Sleep)
+		}
+		searchResponse, err := doctolibClient.Search(query.Speciality, query.Location, page)
+		if err != nil {
+			return nil, fmt.Errorf("govaccine.DiscoverVaccinationCenters(): failed to search page %d: %w", page, err)
+		}
+		if len(searchResponse.Data.Doctors) == 0 {
+			break
+		}
+
+		for _, doctor := range searchResponse.Data.Doctors {
+			center := &VaccinationCenter{
+				Url:   doctolibClient.Url(doctor.Link),
+				Label: doctor.NameWithTitle,
+				Address: strings.TrimSpace(fmt.Sprintf("%s, %s %s", doctor.Address, doctor.Zipcode,
+					doctor.City)),
+				Coordinates: geo.Coordinates{Latitude: doctor.Position.Lat, Longitude: doctor.Position.Lng},
+			}
+			var err error
+			if center.Name, err = ParseVaccinationCenterUrl(center.Url, doctolibClient.Url("")); err != nil {
+				continue // Not a practitioner page
+			}
+			if seenNames[center.Name] {
+				continue
+			}
+			seenNames[center.Name] = true
+
+			if origin != nil {
+				if center.Coordinates.IsZero() {
+					continue
+				}
+				distances[center] = geo.Distance(origin.Coordinates, center.Coordinates)
+				if distances[center] > query.Radius {
+					continue
+				}
+			}
+			centers = append(centers, center)
+		}
+
+		if searchResponse.Data.Total > 0 && len(seenNames) >= searchResponse.Data.Total {
+			break
+		}
+	}
+
+	sort.SliceStable(centers, func(i, j int) bool {
+		return distances[centers[i]] < distances[centers[j]]
+	})
+
+	return centers, nil
+}
@@ -0,0 +1,186 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"encoding/json"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// discoveryServer serves pages of search results and records the pages requested.
+func discoveryServer(t *testing.T, total int, pages [][]doctolib.SearchDoctor, requestedPages *[]int) *doctolib.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vaccination-covid-19/saint-etienne.json" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil {
+			t.Errorf("invalid page %q", r.URL.Query().Get("page"))
+		}
+		*requestedPages = append(*requestedPages, page)
+
+		var response doctolib.SearchResponse
+		response.Data.Total = total
+		if page >= 1 && page <= len(pages) {
+			response.Data.Doctors = pages[page-1]
+		}
+		_ = json.NewEncoder(w).Encode(&response)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := doctolib.NewClient(server.URL, 5*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func searchDoctor(name string) doctolib.SearchDoctor {
+	return doctolib.SearchDoctor{
+		Link:          "/vaccination-covid-19/saint-etienne/" + name,
+		NameWithTitle: "Centre " + name,
+		Address:       "1 rue de la Paix",
+		Zipcode:       "42000",
+		City:          "Saint-Étienne",
+	}
+}
+
+func TestDiscoverVaccinationCenters(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int
+		pages     [][]doctolib.SearchDoctor
+		maxPages  int
+		wantNames []string
+		wantPages []int
+	}{
+		{
+			name:  "stops at the first empty page",
+			total: 0,
+			pages: [][]doctolib.SearchDoctor{
+				{searchDoctor("a"), searchDoctor("b")},
+				{searchDoctor("c")},
+			},
+			maxPages:  5,
+			wantNames: []string{"a", "b", "c"},
+			wantPages: []int{1, 2, 3},
+		},
+		{
+			name:  "stops once total centers were seen",
+			total: 3,
+			pages: [][]doctolib.SearchDoctor{
+				{searchDoctor("a"), searchDoctor("b")},
+				{searchDoctor("c")},
+				{searchDoctor("d")},
+			},
+			maxPages:  5,
+			wantNames: []string{"a", "b", "c"},
+			wantPages: []int{1, 2},
+		},
+		{
+			name:  "stops after max pages",
+			total: 10,
+			pages: [][]doctolib.SearchDoctor{
+				{searchDoctor("a")},
+				{searchDoctor("b")},
+				{searchDoctor("c")},
+			},
+			maxPages:  2,
+			wantNames: []string{"a", "b"},
+			wantPages: []int{1, 2},
+		},
+		{
+			name:  "skips duplicates and links other than centers",
+			total: 0,
+			pages: [][]doctolib.SearchDoctor{
+				{searchDoctor("a"), {Link: "/vaccination-covid-19/saint-etienne"}, searchDoctor("a")},
+				{searchDoctor("b"), searchDoctor("a")},
+			},
+			maxPages:  5,
+			wantNames: []string{"a", "b"},
+			wantPages: []int{1, 2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requestedPages []int
+			client := discoveryServer(t, test.total, test.pages, &requestedPages)
+
+			centers, err := DiscoverVaccinationCenters(client, nil, &DiscoveryQuery{
+				Speciality: VaccinationSpeciality,
+				Location:   "Saint-Étienne",
+				MaxPages:   test.maxPages,
+			})
+			if err != nil {
+				t.Fatalf("DiscoverVaccinationCenters() error = %v", err)
+			}
+
+			var names []string
+			for _, center := range centers {
+				names = append(names, center.Name)
+			}
+			if !slices.Equal(names, test.wantNames) {
+				t.Errorf("got centers %v, want %v", names, test.wantNames)
+			}
+			if !slices.Equal(requestedPages, test.wantPages) {
+				t.Errorf("requested pages %v, want %v", requestedPages, test.wantPages)
+			}
+		})
+	}
+}
+
+func TestDiscoverVaccinationCentersMetadata(t *testing.T) {
+	var requestedPages []int
+	client := discoveryServer(t, 1, [][]doctolib.SearchDoctor{{searchDoctor("a")}}, &requestedPages)
+
+	centers, err := DiscoverVaccinationCenters(client, nil, &DiscoveryQuery{
+		Speciality: VaccinationSpeciality,
+		Location:   "Saint-Étienne",
+		MaxPages:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(centers) != 1 {
+		t.Fatalf("got %d centers, want 1", len(centers))
+	}
+	center := centers[0]
+	if center.Url != client.Url("/vaccination-covid-19/saint-etienne/a") || center.Label != "Centre a" ||
+		center.Address != "1 rue de la Paix, 42000 Saint-Étienne" {
+		t.Errorf("unexpected center %+v", center)
+	}
+}
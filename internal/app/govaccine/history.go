@@ -0,0 +1,396 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryStore persists the slots seen by the Vaccibots in a BoltDB file. The observations are written in batches by
+// a single goroutine, so that the Vaccibots don't wait for the disk. The file is only opened while reading or writing
+// a batch so that it can be queried while govaccine is running.
+type HistoryStore struct {
+	mutex        sync.Mutex // Serializes the accesses to the file
+	filepath     string
+	closeMutex   sync.RWMutex // Prevents RecordAvailabilities from sending observations after Close
+	closed       bool
+	observations chan []*SlotObservation
+	done         chan struct{}
+	errMutex     sync.Mutex
+	writeErr     error // Last error of the writer, reported by the next RecordAvailabilities
+}
+
+// SlotObservation is a slot seen available at ObservedAt.
+type SlotObservation struct {
+	Center     string
+	Motive     string
+	SlotStart  time.Time
+	ObservedAt time.Time
+}
+
+// CenterHistory summarizes the slots released by a vaccination center.
+type CenterHistory struct {
+	Center string
+	// SlotsNb is the number of distinct slots seen
+	SlotsNb int
+	// ReleasesPerDay is SlotsNb divided by the number of days covered by the history
+	ReleasesPerDay float64
+	// HourlyReleases counts slots by hour of day (local time) of their first sighting
+	HourlyReleases [24]int
+	// AverageLifetime is the average duration between the first and last sightings of a slot
+	AverageLifetime time.Duration
+}
+
+type HistoryReport struct {
+	From    time.Time
+	To      time.Time
+	Centers []CenterHistory
+}
+
+var observationsBucket = []byte("observations")
+
+const (
+	historyKeySeparator = "\x00"
+	historyOpenTimeout  = 5 * time.Second
+	// historyFlushInterval is the time between two writes of the observations
+	historyFlushInterval = 5 * time.Second
+	// historyQueueSize is the number of availabilities waiting to be written above which observations are dropped
+	historyQueueSize = 1024
+)
+
+func historyKey(observation *SlotObservation) []byte {
+	return []byte(strings.Join([]string{
+		observation.Center,
+		observation.Motive,
+		observation.SlotStart.UTC().Format(time.RFC3339),
+		observation.ObservedAt.UTC().Format(time.RFC3339Nano),
+	}, historyKeySeparator))
+}
+
+func parseHistoryKey(key []byte) (*SlotObservation, error) {
+	parts := strings.Split(string(key), historyKeySeparator)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+
+	slotStart, err := time.Parse(time.RFC3339, parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid slot start in key %q: %w", key, err)
+	}
+	observedAt, err := time.Parse(time.RFC3339Nano, parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid observation time in key %q: %w", key, err)
+	}
+
+	return &SlotObservation{Center: parts[0], Motive: parts[1], SlotStart: slotStart, ObservedAt: observedAt}, nil
+}
+
+func (h *HistoryStore) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(h.filepath, 0600, &bolt.Options{Timeout: historyOpenTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history store %s: %w", h.filepath, err)
+	}
+
+	return db, nil
+}
+
+// RecordAvailabilities records every slot of availabilitiesResponse as seen at observedAt. They are written to the
+// file within historyFlushInterval.
+func (h *HistoryStore) RecordAvailabilities(center string, motive string, observedAt time.Time,
+	availabilitiesResponse *doctolib.AvailabilitiesResponse) error {
+	if h == nil {
+		return nil
+	}
+
+	var observations []*SlotObservation
+	for _, availability := range availabilitiesResponse.Availabilities {
+		for _, slot := range availability.Slots {
+			slotStart, err := time.Parse(doctolib.DatetimeLayout, slot.StartDate)
+			if err != nil {
+				return fmt.Errorf("govaccine.HistoryStore.RecordAvailabilities(): invalid slot start date %s: %w",
+					slot.StartDate, err)
+			}
+
+			observations = append(observations, &SlotObservation{
+				Center:     center,
+				Motive:     motive,
+				SlotStart:  slotStart,
+				ObservedAt: observedAt,
+			})
+		}
+	}
+	if len(observations) == 0 {
+		return nil
+	}
+
+	h.errMutex.Lock()
+	writeErr := h.writeErr
+	h.writeErr = nil
+	h.errMutex.Unlock()
+	if writeErr != nil {
+		return fmt.Errorf("govaccine.HistoryStore.RecordAvailabilities(): %w", writeErr)
+	}
+
+	h.closeMutex.RLock()
+	defer h.closeMutex.RUnlock()
+	if h.closed {
+		return errors.New("govaccine.HistoryStore.RecordAvailabilities(): history store is closed")
+	}
+
+	select {
+	case h.observations <- observations:
+		return nil
+	default:
+		return fmt.Errorf("govaccine.HistoryStore.RecordAvailabilities(): writer is behind, dropped %d observations",
+			len(observations))
+	}
+}
+
+// write writes the observations recorded every historyFlushInterval, until Close is called.
+func (h *HistoryStore) write() {
+	defer close(h.done)
+	ticker := time.NewTicker(historyFlushInterval)
+	defer ticker.Stop()
+
+	var pending []*SlotObservation
+	for {
+		select {
+		case observations, ok := <-h.observations:
+			if !ok {
+				h.flush(pending)
+				return
+			}
+			pending = append(pending, observations...)
+		case <-ticker.C:
+			h.flush(pending)
+			pending = nil
+		}
+	}
+}
+
+// flush writes observations to the file, in a single transaction.
+func (h *HistoryStore) flush(observations []*SlotObservation) {
+	if len(observations) == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	db, err := h.open(false)
+	if err != nil {
+		h.setWriteErr(err)
+		return
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(observationsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, observation := range observations {
+			if err := bucket.Put(historyKey(observation), nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		h.setWriteErr(fmt.Errorf("failed to write to %s: %w", h.filepath, err))
+	}
+}
+
+func (h *HistoryStore) setWriteErr(err error) {
+	h.errMutex.Lock()
+	defer h.errMutex.Unlock()
+	h.writeErr = err
+}
+
+// Close writes the observations not written yet and stops the writer.
+func (h *HistoryStore) Close() error {
+	h.closeMutex.Lock()
+	if h.closed {
+		h.closeMutex.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.observations)
+	h.closeMutex.Unlock()
+	<-h.done
+
+	h.errMutex.Lock()
+	defer h.errMutex.Unlock()
+	if h.writeErr != nil {
+		return fmt.Errorf("govaccine.HistoryStore.Close(): %w", h.writeErr)
+	}
+
+	return nil
+}
+
+// Observations returns the observations made since the given time, for the centers whose name starts with
+// centerPrefix. Observations are sorted by center, motive, slot start and observation time.
+func (h *HistoryStore) Observations(since time.Time, centerPrefix string) ([]SlotObservation, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	db, err := h.open(true)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.HistoryStore.Observations(): %w", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var observations []SlotObservation
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(observationsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		prefix := []byte(centerPrefix)
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			observation, err := parseHistoryKey(key)
+			if err != nil {
+				return err
+			}
+
+			if !observation.ObservedAt.Before(since) {
+				observations = append(observations, *observation)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.HistoryStore.Observations(): failed to read %s: %w", h.filepath, err)
+	}
+
+	return observations, nil
+}
+
+// BuildHistoryReport computes per-center statistics from observations sorted as returned by
+// HistoryStore.Observations. Centers are sorted by decreasing number of slots.
+func BuildHistoryReport(observations []SlotObservation) *HistoryReport {
+	report := &HistoryReport{}
+	if len(observations) == 0 {
+		return report
+	}
+
+	type slotSightings struct {
+		first time.Time
+		last  time.Time
+	}
+	slotsByCenter := make(map[string]map[string]*slotSightings)
+	report.From, report.To = observations[0].ObservedAt, observations[0].ObservedAt
+	for _, observation := range observations {
+		if observation.ObservedAt.Before(report.From) {
+			report.From = observation.ObservedAt
+		}
+		if observation.ObservedAt.After(report.To) {
+			report.To = observation.ObservedAt
+		}
+
+		slots, ok := slotsByCenter[observation.Center]
+		if !ok {
+			slots = make(map[string]*slotSightings)
+			slotsByCenter[observation.Center] = slots
+		}
+
+		slotKey := observation.Motive + historyKeySeparator + observation.SlotStart.String()
+		sightings, ok := slots[slotKey]
+		if !ok {
+			slots[slotKey] = &slotSightings{first: observation.ObservedAt, last: observation.ObservedAt}
+			continue
+		}
+		if observation.ObservedAt.Before(sightings.first) {
+			sightings.first = observation.ObservedAt
+		}
+		if observation.ObservedAt.After(sightings.last) {
+			sightings.last = observation.ObservedAt
+		}
+	}
+
+	days := report.To.Sub(report.From).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+
+	for center, slots := range slotsByCenter {
+		centerHistory := CenterHistory{Center: center, SlotsNb: len(slots)}
+		var totalLifetime time.Duration
+		for _, sightings := range slots {
+			centerHistory.HourlyReleases[sightings.first.Local().Hour()]++
+			totalLifetime += sightings.last.Sub(sightings.first)
+		}
+		centerHistory.ReleasesPerDay = float64(len(slots)) / days
+		centerHistory.AverageLifetime = totalLifetime / time.Duration(len(slots))
+
+		report.Centers = append(report.Centers, centerHistory)
+	}
+
+	sort.Slice(report.Centers, func(i, j int) bool {
+		if report.Centers[i].SlotsNb != report.Centers[j].SlotsNb {
+			return report.Centers[i].SlotsNb > report.Centers[j].SlotsNb
+		}
+		return report.Centers[i].Center < report.Centers[j].Center
+	})
+
+	return report
+}
+
+func NewHistoryStore(historyFilepath string) (*HistoryStore, error) {
+	historyStore := &HistoryStore{
+		filepath:     historyFilepath,
+		observations: make(chan []*SlotObservation, historyQueueSize),
+		done:         make(chan struct{}),
+	}
+
+	// Make sure the file can be created/opened now rather than failing on the first slot seen
+	db, err := historyStore.open(false)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.NewHistoryStore(): %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return nil, fmt.Errorf("govaccine.NewHistoryStore(): failed to close history store %s: %w", historyFilepath,
+			err)
+	}
+	go historyStore.write()
+
+	return historyStore, nil
+}
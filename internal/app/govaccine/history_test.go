@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreBatchesWrites(t *testing.T) {
+	history, err := NewHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observedAt := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	response := &doctolib.AvailabilitiesResponse{Availabilities: []doctolib.Availability{
+		{Date: "2021-06-02", Slots: []doctolib.AvailabilitySlot{
+			{StartDate: "2021-06-02T09:00:00.000+02:00"},
+			{StartDate: "2021-06-02T09:05:00.000+02:00"},
+		}},
+	}}
+	if err := history.RecordAvailabilities("center", "motive", observedAt, response); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written before the flush interval
+	observations, err := history.Observations(time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(observations) != 0 {
+		t.Fatalf("got %d observations before the flush, want 0", len(observations))
+	}
+
+	if err := history.Close(); err != nil {
+		t.Fatal(err)
+	}
+	observations, err = history.Observations(time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(observations) != 2 {
+		t.Fatalf("got %d observations after Close, want 2", len(observations))
+	}
+	if !observations[0].ObservedAt.Equal(observedAt) || observations[0].Center != "center" {
+		t.Errorf("unexpected observation %+v", observations[0])
+	}
+
+	if err := history.RecordAvailabilities("center", "motive", observedAt, response); err == nil {
+		t.Error("RecordAvailabilities succeeded after Close")
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import "github.com/GuiTeK/govaccine/internal/pkg/metrics"
+
+var (
+	slotsSeenTotal = metrics.NewCounterVec("govaccine_slots_seen_total",
+		"Number of times an available slot was seen, by vaccination center and visit motive", "center", "motive")
+	bookingAttemptsTotal = metrics.NewCounterVec("govaccine_booking_attempts_total",
+		"Number of booking steps attempted, by stage (create_first, create_second, confirm) and outcome",
+		"stage", "outcome")
+	workersTotal = metrics.NewGaugeVec("govaccine_workers",
+		"Number of running workers")
+	busyWorkers = metrics.NewGaugeVec("govaccine_workers_busy",
+		"Number of workers currently checking a vaccination center")
+	arbitrationCandidatesTotal = metrics.NewCounterVec("govaccine_arbitration_candidates_total",
+		"Number of slots submitted to the arbiter, by outcome (won, lost)", "outcome")
+	sessionReloginsTotal = metrics.NewCounterVec("govaccine_session_relogins_total",
+		"Number of times a worker had to log in again after losing its Doctolib session")
+)
+
+func recordBookingStage(stage string, err error) {
+	if err != nil {
+		bookingAttemptsTotal.Inc(stage, "failure")
+	} else {
+		bookingAttemptsTotal.Inc(stage, "success")
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSightings is the number of slot sightings kept by the Monitor.
+	maxSightings = 100
+	// maxBookings is the number of bookings kept by the Monitor.
+	maxBookings = 5
+)
+
+// Monitor keeps track of what the workers are doing and of the recent results of the checks of every center.
+type Monitor struct {
+	mutex     sync.Mutex
+	workers   map[string]*WorkerState
+	centers   map[string]*CenterState
+	sightings []Sighting
+	bookings  []*BookingProgress
+	session   SessionStatus
+}
+
+// WorkerState is what a worker is currently doing.
+type WorkerState struct {
+	Name    string `json:"name"`
+	Account string `json:"account"`
+	// Center is the vaccination center being checked, empty if the worker is waiting for a job
+	Center string    `json:"center,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// CenterState sums up the recent checks of a vaccination center.
+type CenterState struct {
+	LastCheck     time.Time `json:"last_check"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+	SlotsSeen     int       `json:"slots_seen"`
+}
+
+// BookingProgress follows the stages of a booking: creation of the first shot appointment ("create_first"), of the
+// second shot appointment ("create_second") and confirmation ("confirm").
+type BookingProgress struct {
+	Worker    string         `json:"worker"`
+	Center    string         `json:"center"`
+	SlotStart string         `json:"slot_start"`
+	Started   time.Time      `json:"started"`
+	Stages    []BookingStage `json:"stages"`
+	Done      bool           `json:"done"`
+	// Error is the reason why the booking failed, empty if it succeeded or is in progress
+	Error string `json:"error,omitempty"`
+}
+
+type BookingStage struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// SessionStatus counts the Doctolib sessions lost and the requests throttled by Doctolib.
+type SessionStatus struct {
+	Relogins      int       `json:"relogins"`
+	LastRelogin   time.Time `json:"last_relogin"`
+	Throttled     int       `json:"throttled"`
+	LastThrottled time.Time `json:"last_throttled"`
+}
+
+// Sighting is an available slot seen by a worker.
+type Sighting struct {
+	Time      time.Time `json:"time"`
+	Worker    string    `json:"worker"`
+	Center    string    `json:"center"`
+	Motive    string    `json:"motive"`
+	Total     int       `json:"total"`
+	SlotStart string    `json:"slot_start,omitempty"`
+}
+
+func (m *Monitor) centerState(center string) *CenterState {
+	state := m.centers[center]
+	if state == nil {
+		state = &CenterState{}
+		m.centers[center] = state
+	}
+	return state
+}
+
+// AddWorker records that worker, logged in with account, is waiting for a job.
+func (m *Monitor) AddWorker(worker string, account string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.workers[worker] = &WorkerState{Name: worker, Account: account, Since: time.Now()}
+}
+
+// StartCheck records that worker started checking center.
+func (m *Monitor) StartCheck(worker string, center string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if state := m.workers[worker]; state != nil {
+		state.Center = center
+		state.Since = time.Now()
+	}
+}
+
+// FinishCheck records that worker is done checking center.
+func (m *Monitor) FinishCheck(worker string, center string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.centerState(center).LastCheck = now
+	if state := m.workers[worker]; state != nil {
+		state.Center = ""
+		state.Since = now
+	}
+}
+
+// RecordError records that checking center failed with err.
+func (m *Monitor) RecordError(center string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	state := m.centerState(center)
+	state.LastError = err.Error()
+	state.LastErrorTime = time.Now()
+}
+
+// RecordSighting records that worker saw total available slots at center, the first acceptable one starting at
+// slotStart (empty if none).
+func (m *Monitor) RecordSighting(worker string, center string, motive string, total int, slotStart string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.centerState(center).SlotsSeen += total
+	m.sightings = append(m.sightings, Sighting{
+		Time:      time.Now(),
+		Worker:    worker,
+		Center:    center,
+		Motive:    motive,
+		Total:     total,
+		SlotStart: slotStart,
+	})
+	if len(m.sightings) > maxSightings {
+		m.sightings = m.sightings[len(m.sightings)-maxSightings:]
+	}
+}
+
+// StartBooking records that worker started booking the slot starting at slotStart at center.
+func (m *Monitor) StartBooking(worker string, center string, slotStart string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.bookings = append(m.bookings, &BookingProgress{
+		Worker:    worker,
+		Center:    center,
+		SlotStart: slotStart,
+		Started:   time.Now(),
+	})
+	if len(m.bookings) > maxBookings {
+		m.bookings = m.bookings[len(m.bookings)-maxBookings:]
+	}
+}
+
+// booking returns the booking in progress of worker, nil if there is none.
+func (m *Monitor) booking(worker string) *BookingProgress {
+	for i := len(m.bookings) - 1; i >= 0; i-- {
+		if m.bookings[i].Worker == worker && !m.bookings[i].Done {
+			return m.bookings[i]
+		}
+	}
+	return nil
+}
+
+// RecordBookingStage records that the booking stage of worker went through, or failed with err.
+func (m *Monitor) RecordBookingStage(worker string, stage string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if booking := m.booking(worker); booking != nil {
+		bookingStage := BookingStage{Name: stage}
+		if err != nil {
+			bookingStage.Error = err.Error()
+		}
+		booking.Stages = append(booking.Stages, bookingStage)
+	}
+}
+
+// FinishBooking records that the booking of worker is over, and failed with err if not nil.
+func (m *Monitor) FinishBooking(worker string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if booking := m.booking(worker); booking != nil {
+		booking.Done = true
+		if err != nil {
+			booking.Error = err.Error()
+		}
+	}
+}
+
+// Bookings returns the recent bookings, most recent first.
+func (m *Monitor) Bookings() []BookingProgress {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	bookings := make([]BookingProgress, len(m.bookings))
+	for i, booking := range m.bookings {
+		bookings[len(m.bookings)-1-i] = *booking
+		bookings[len(m.bookings)-1-i].Stages = append([]BookingStage(nil), booking.Stages...)
+	}
+
+	return bookings
+}
+
+// RecordRelogin records that a worker lost its Doctolib session and logged in again.
+func (m *Monitor) RecordRelogin() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.session.Relogins++
+	m.session.LastRelogin = time.Now()
+}
+
+// RecordThrottled records that Doctolib throttled a request.
+func (m *Monitor) RecordThrottled() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.session.Throttled++
+	m.session.LastThrottled = time.Now()
+}
+
+// Session returns the status of the Doctolib sessions.
+func (m *Monitor) Session() SessionStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.session
+}
+
+// RemoveWorker forgets worker once it stopped.
+func (m *Monitor) RemoveWorker(worker string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.workers, worker)
+}
+
+// Workers returns the state of the running workers, sorted by name.
+func (m *Monitor) Workers() []WorkerState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	workers := make([]WorkerState, 0, len(m.workers))
+	for _, state := range m.workers {
+		workers = append(workers, *state)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		if len(workers[i].Name) != len(workers[j].Name) { // "Worker 10" comes after "Worker 9"
+			return len(workers[i].Name) < len(workers[j].Name)
+		}
+		return workers[i].Name < workers[j].Name
+	})
+
+	return workers
+}
+
+// Center returns the state of center.
+func (m *Monitor) Center(center string) CenterState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if state := m.centers[center]; state != nil {
+		return *state
+	}
+	return CenterState{}
+}
+
+// Sightings returns the recent slot sightings, most recent first.
+func (m *Monitor) Sightings() []Sighting {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sightings := make([]Sighting, len(m.sightings))
+	for i, sighting := range m.sightings {
+		sightings[len(m.sightings)-1-i] = sighting
+	}
+
+	return sightings
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{
+		workers: make(map[string]*WorkerState),
+		centers: make(map[string]*CenterState),
+	}
+}
@@ -0,0 +1,430 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventSlotFound           EventType = "slot_found"
+	EventBookingConfirmed    EventType = "booking_confirmed"
+	EventBookingFailed       EventType = "booking_failed"
+	EventSessionLost         EventType = "session_lost"
+	EventAppointmentCanceled EventType = "appointment_canceled"
+	EventCancellationFailed  EventType = "cancellation_failed"
+)
+
+var eventTitles = map[EventType]string{
+	EventSlotFound:           "Vaccination slot found",
+	EventBookingConfirmed:    "Vaccination appointment confirmed",
+	EventBookingFailed:       "Vaccination appointment booking failed",
+	EventSessionLost:         "Doctolib session lost",
+	EventAppointmentCanceled: "Previous vaccination appointment canceled",
+	EventCancellationFailed:  "Previous vaccination appointment not canceled",
+}
+
+type Event struct {
+	Type              EventType `json:"type"`
+	Time              time.Time `json:"time"`
+	Worker            string    `json:"worker"`
+	VaccinationCenter string    `json:"vaccination_center,omitempty"`
+	StartDate         string    `json:"start_date,omitempty"`
+	AppointmentId     string    `json:"appointment_id,omitempty"`
+	Message           string    `json:"message"`
+}
+
+func (e *Event) Title() string {
+	return eventTitles[e.Type]
+}
+
+// Notifier sends events to an external sink (webhook, email, etc.).
+type Notifier interface {
+	Notify(event *Event) error
+}
+
+type WebhookNotifier struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+type EmailNotifier struct {
+	smtpAddress       string
+	auth              smtp.Auth
+	from              string
+	defaultRecipients []string
+	recipients        map[EventType][]string
+}
+
+type CommandNotifier struct {
+	command string
+	timeout time.Duration
+}
+
+type DesktopNotifier struct {
+	command string
+}
+
+// NotifierConfig describes a notification sink. Only the fields relevant to Type are used.
+type NotifierConfig struct {
+	Type   string      `json:"type" yaml:"type"`
+	Events []EventType `json:"events" yaml:"events"`
+
+	// webhook
+	Url     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers" yaml:"headers"`
+
+	// email
+	SmtpHost   string                 `json:"smtp_host" yaml:"smtp_host"`
+	SmtpPort   int                    `json:"smtp_port" yaml:"smtp_port"`
+	Username   string                 `json:"username" yaml:"username"`
+	Password   string                 `json:"password" yaml:"password"`
+	From       string                 `json:"from" yaml:"from"`
+	To         []string               `json:"to" yaml:"to"`
+	Recipients map[EventType][]string `json:"recipients" yaml:"recipients"`
+
+	// command, desktop
+	Command string `json:"command" yaml:"command"`
+}
+
+type notificationRoute struct {
+	name     string
+	notifier Notifier
+	events   []EventType
+}
+
+// Notifications dispatches events to the notifiers subscribed to them.
+type Notifications struct {
+	mutex     sync.RWMutex
+	routes    []notificationRoute
+	waitGroup sync.WaitGroup
+	logger    *slog.Logger
+}
+
+const notifiersTimeout = 10 * time.Second
+
+func (n *WebhookNotifier) Notify(event *Event) error {
+	payloadBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("govaccine.WebhookNotifier.Notify(): cannot marshal event: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("govaccine.WebhookNotifier.Notify(): cannot create request %s: %w", n.url, err)
+	}
+
+	req.Header.Set("content-type", "application/json; charset=utf-8")
+	for name, value := range n.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("govaccine.WebhookNotifier.Notify(): cannot do request %s: %w", n.url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("govaccine.WebhookNotifier.Notify(): unexpected response status code (%d) for %s",
+			resp.StatusCode, n.url)
+	}
+
+	return nil
+}
+
+func (n *EmailNotifier) Notify(event *Event) error {
+	recipients, ok := n.recipients[event.Type]
+	if !ok {
+		recipients = n.defaultRecipients
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	var message bytes.Buffer
+	_, _ = fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	_, _ = fmt.Fprintf(&message, "To: %s\r\n", strings.Join(recipients, ", "))
+	_, _ = fmt.Fprintf(&message, "Subject: [govaccine] %s\r\n", event.Title())
+	_, _ = fmt.Fprintf(&message, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	_, _ = fmt.Fprintf(&message, "%s\r\n\r\n", event.Message)
+	_, _ = fmt.Fprintf(&message, "Worker: %s\r\n", event.Worker)
+	if event.VaccinationCenter != "" {
+		_, _ = fmt.Fprintf(&message, "Vaccination center: %s\r\n", event.VaccinationCenter)
+	}
+	if event.StartDate != "" {
+		_, _ = fmt.Fprintf(&message, "Start date: %s\r\n", event.StartDate)
+	}
+	if event.AppointmentId != "" {
+		_, _ = fmt.Fprintf(&message, "Appointment ID: %s\r\n", event.AppointmentId)
+	}
+
+	err := smtp.SendMail(n.smtpAddress, n.auth, n.from, recipients, message.Bytes())
+	if err != nil {
+		return fmt.Errorf("govaccine.EmailNotifier.Notify(): cannot send email through %s: %w", n.smtpAddress, err)
+	}
+
+	return nil
+}
+
+func eventEnvironment(event *Event) []string {
+	return []string{
+		"GOVACCINE_EVENT_TYPE=" + string(event.Type),
+		"GOVACCINE_EVENT_TIME=" + event.Time.Format(time.RFC3339),
+		"GOVACCINE_EVENT_WORKER=" + event.Worker,
+		"GOVACCINE_EVENT_VACCINATION_CENTER=" + event.VaccinationCenter,
+		"GOVACCINE_EVENT_START_DATE=" + event.StartDate,
+		"GOVACCINE_EVENT_APPOINTMENT_ID=" + event.AppointmentId,
+		"GOVACCINE_EVENT_MESSAGE=" + event.Message,
+	}
+}
+
+// Notify runs the command through the shell, with the event in GOVACCINE_EVENT_* environment variables and as
+// JSON on the standard input.
+func (n *CommandNotifier) Notify(event *Event) error {
+	payloadBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("govaccine.CommandNotifier.Notify(): cannot marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.command)
+	cmd.Env = append(os.Environ(), eventEnvironment(event)...)
+	cmd.Stdin = bytes.NewReader(payloadBytes)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("govaccine.CommandNotifier.Notify(): command \"%s\" failed: %w (output: %s)",
+			n.command, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (n *DesktopNotifier) Notify(event *Event) error {
+	var cmd *exec.Cmd
+	switch {
+	case n.command != "":
+		cmd = exec.Command(n.command, event.Title(), event.Message)
+	case runtime.GOOS == "darwin":
+		script := fmt.Sprintf("display notification %s with title %s",
+			appleScriptQuote(event.Message), appleScriptQuote(event.Title()))
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=govaccine", event.Title(), event.Message)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("govaccine.DesktopNotifier.Notify(): command %s failed: %w (output: %s)",
+			cmd.Path, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func appleScriptQuote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+func newNotifier(config *NotifierConfig) (Notifier, error) {
+	switch config.Type {
+	case "webhook":
+		if config.Url == "" {
+			return nil, fmt.Errorf("webhook notifier requires \"url\"")
+		}
+
+		return &WebhookNotifier{
+			url:        config.Url,
+			headers:    config.Headers,
+			httpClient: &http.Client{Timeout: notifiersTimeout},
+		}, nil
+	case "email":
+		if config.SmtpHost == "" || config.From == "" {
+			return nil, fmt.Errorf("email notifier requires \"smtp_host\" and \"from\"")
+		}
+		if len(config.To) == 0 && len(config.Recipients) == 0 {
+			return nil, fmt.Errorf("email notifier requires \"to\" or \"recipients\"")
+		}
+		for eventType := range config.Recipients {
+			if _, ok := eventTitles[eventType]; !ok {
+				return nil, fmt.Errorf("unknown event type \"%s\" in \"recipients\"", eventType)
+			}
+		}
+
+		smtpPort := config.SmtpPort
+		if smtpPort == 0 {
+			smtpPort = 587
+		}
+		notifier := &EmailNotifier{
+			smtpAddress:       fmt.Sprintf("%s:%d", config.SmtpHost, smtpPort),
+			from:              config.From,
+			defaultRecipients: config.To,
+			recipients:        config.Recipients,
+		}
+		if config.Username != "" {
+			notifier.auth = smtp.PlainAuth("", config.Username, config.Password, config.SmtpHost)
+		}
+
+		return notifier, nil
+	case "command":
+		if config.Command == "" {
+			return nil, fmt.Errorf("command notifier requires \"command\"")
+		}
+
+		return &CommandNotifier{command: config.Command, timeout: notifiersTimeout}, nil
+	case "desktop":
+		return &DesktopNotifier{command: config.Command}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type \"%s\"", config.Type)
+	}
+}
+
+func (n *Notifications) Notify(event *Event) {
+	if n == nil {
+		return
+	}
+
+	n.mutex.RLock()
+	routes := n.routes
+	n.mutex.RUnlock()
+	for _, route := range routes {
+		if len(route.events) > 0 && !eventTypeSliceContains(route.events, event.Type) {
+			continue
+		}
+
+		n.waitGroup.Add(1)
+		go func(route notificationRoute) {
+			defer n.waitGroup.Done()
+
+			if err := route.notifier.Notify(event); err != nil {
+				n.logger.Warn("Failed to send notification", "event", event.Type, "notifier", route.name,
+					logging.ErrorKey, err)
+			}
+		}(route)
+	}
+}
+
+// Wait blocks until all pending notifications have been sent.
+func (n *Notifications) Wait() {
+	if n == nil {
+		return
+	}
+
+	n.waitGroup.Wait()
+}
+
+func eventTypeSliceContains(s []EventType, v EventType) bool {
+	for _, current := range s {
+		if current == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newNotificationRoutes(configs []NotifierConfig) ([]notificationRoute, error) {
+	var routes []notificationRoute
+	for i := range configs {
+		for _, eventType := range configs[i].Events {
+			if _, ok := eventTitles[eventType]; !ok {
+				return nil, fmt.Errorf("notifier #%d: unknown event type \"%s\"", i+1, eventType)
+			}
+		}
+
+		notifier, err := newNotifier(&configs[i])
+		if err != nil {
+			return nil, fmt.Errorf("notifier #%d: %s", i+1, err)
+		}
+
+		routes = append(routes, notificationRoute{
+			name:     fmt.Sprintf("%s notifier #%d", configs[i].Type, i+1),
+			notifier: notifier,
+			events:   configs[i].Events,
+		})
+	}
+
+	return routes, nil
+}
+
+// Update replaces the notifiers by the ones of configs. The notifications being sent aren't interrupted.
+func (n *Notifications) Update(configs []NotifierConfig) error {
+	routes, err := newNotificationRoutes(configs)
+	if err != nil {
+		return fmt.Errorf("govaccine.Notifications.Update(): %w", err)
+	}
+
+	n.mutex.Lock()
+	n.routes = routes
+	n.mutex.Unlock()
+
+	return nil
+}
+
+func NewNotifications(configs []NotifierConfig, logger *slog.Logger) (*Notifications, error) {
+	routes, err := newNotificationRoutes(configs)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.NewNotifications(): %w", err)
+	}
+
+	return &Notifications{routes: routes, logger: logger}, nil
+}
+
+// LoadNotifierConfigs reads a JSON file containing a list of notifier configurations.
+func LoadNotifierConfigs(configFilepath string) ([]NotifierConfig, error) {
+	configBytes, err := ioutil.ReadFile(configFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("govaccine.LoadNotifierConfigs(): failed to read file %s: %s", configFilepath, err)
+	}
+
+	var configs []NotifierConfig
+	if err := json.Unmarshal(configBytes, &configs); err != nil {
+		return nil, fmt.Errorf("govaccine.LoadNotifierConfigs(): failed to parse file %s: %s", configFilepath, err)
+	}
+
+	return configs, nil
+}
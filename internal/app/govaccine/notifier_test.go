@@ -0,0 +1,311 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testEvent = &Event{
+	Type:              EventBookingConfirmed,
+	Time:              time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+	Worker:            "worker-1",
+	VaccinationCenter: "centre-de-vaccination-paris",
+	StartDate:         "2021-06-02T09:00:00.000+02:00",
+	AppointmentId:     "42",
+	Message:           "Appointment booked",
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var request *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		request = req
+		body, _ = io.ReadAll(req.Body)
+	}))
+	defer server.Close()
+
+	notifier, err := newNotifier(&NotifierConfig{
+		Type:    "webhook",
+		Url:     server.URL + "/hook",
+		Headers: map[string]string{"Authorization": "Bearer token", "X-Custom": "value"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Method != "POST" || request.URL.Path != "/hook" {
+		t.Errorf("unexpected request %s %s", request.Method, request.URL.Path)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":  "application/json; charset=utf-8",
+		"Authorization": "Bearer token",
+		"X-Custom":      "value",
+	} {
+		if got := request.Header.Get(name); got != want {
+			t.Errorf("header %s: got %q, want %q", name, got, want)
+		}
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid payload %s: %s", body, err)
+	}
+	want := map[string]string{
+		"type":               "booking_confirmed",
+		"time":               "2021-06-01T10:00:00Z",
+		"worker":             "worker-1",
+		"vaccination_center": "centre-de-vaccination-paris",
+		"start_date":         "2021-06-02T09:00:00.000+02:00",
+		"appointment_id":     "42",
+		"message":            "Appointment booked",
+	}
+	if len(payload) != len(want) {
+		t.Errorf("got payload %v, want %v", payload, want)
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("payload %s: got %q, want %q", key, payload[key], value)
+		}
+	}
+}
+
+func TestWebhookNotifierErrorStatus(t *testing.T) {
+	statusCodes := []int{http.StatusMultipleChoices, http.StatusBadRequest, http.StatusInternalServerError}
+	for _, statusCode := range statusCodes {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(statusCode)
+		}))
+
+		notifier, err := newNotifier(&NotifierConfig{Type: "webhook", Url: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := notifier.Notify(testEvent); err == nil {
+			t.Errorf("status %d: no error", statusCode)
+		}
+		server.Close()
+	}
+}
+
+func TestNotificationsRouting(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, req.URL.Path)
+	}))
+	defer server.Close()
+
+	notifications, err := NewNotifications([]NotifierConfig{
+		{Type: "webhook", Url: server.URL + "/all"},
+		{Type: "webhook", Url: server.URL + "/failures", Events: []EventType{EventBookingFailed}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications.Notify(testEvent)
+	notifications.Wait()
+
+	if len(received) != 1 || received[0] != "/all" {
+		t.Errorf("got requests %v, want [/all]", received)
+	}
+}
+
+type smtpMessage struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// serveSmtp runs a minimal SMTP server accepting every message, and returns its address.
+func serveSmtp(t *testing.T, messages chan<- smtpMessage) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSmtpConn(conn, messages)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveSmtpConn(conn net.Conn, messages chan<- smtpMessage) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+
+	var message smtpMessage
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.recipients = append(message.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			message.data = data.String()
+			messages <- message
+			message = smtpMessage{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	messages := make(chan smtpMessage, 1)
+	host, port, err := net.SplitHostPort(serveSmtp(t, messages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier, err := newNotifier(&NotifierConfig{
+		Type:       "email",
+		SmtpHost:   host,
+		SmtpPort:   smtpPort,
+		From:       "govaccine@example.com",
+		To:         []string{"default@example.com"},
+		Recipients: map[EventType][]string{EventBookingConfirmed: {"a@example.com", "b@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	message := <-messages
+	if message.from != "govaccine@example.com" {
+		t.Errorf("got sender %q", message.from)
+	}
+	if strings.Join(message.recipients, ",") != "a@example.com,b@example.com" {
+		t.Errorf("got recipients %v, want the ones of booking_confirmed", message.recipients)
+	}
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: [govaccine] Vaccination appointment confirmed\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nAppointment booked\r\n",
+		"Vaccination center: centre-de-vaccination-paris\r\n",
+		"Appointment ID: 42\r\n",
+	} {
+		if !strings.Contains(message.data, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, message.data)
+		}
+	}
+
+	failedEvent := *testEvent
+	failedEvent.Type = EventBookingFailed
+	if err := notifier.Notify(&failedEvent); err != nil {
+		t.Fatal(err)
+	}
+	message = <-messages
+	if strings.Join(message.recipients, ",") != "default@example.com" {
+		t.Errorf("got recipients %v, want the default ones", message.recipients)
+	}
+}
+
+func TestEmailNotifierUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().(*net.TCPAddr)
+	_ = listener.Close()
+
+	notifier, err := newNotifier(&NotifierConfig{
+		Type:     "email",
+		SmtpHost: address.IP.String(),
+		SmtpPort: address.Port,
+		From:     "govaccine@example.com",
+		To:       []string{"default@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(testEvent); err == nil {
+		t.Error("no error with an unreachable SMTP server")
+	}
+}
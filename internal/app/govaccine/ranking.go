@@ -0,0 +1,118 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"github.com/GuiTeK/govaccine/internal/pkg/utils"
+	"sort"
+	"sync"
+)
+
+// CenterRanking knows where the vaccination centers are, and ranks them by priority then by distance from home.
+// It is shared by the orchestrator and the workers, which locate the centers as they check them.
+type CenterRanking struct {
+	mutex       sync.RWMutex
+	home        *geo.Coordinates
+	maxDistance float64
+	locations   map[string]geo.Coordinates
+}
+
+// Locate records the location of center, taken from the place of its booking settings serving practiceIds. Centers
+// without a known place keep the coordinates given in their centers file, if any.
+func (r *CenterRanking) Locate(center *VaccinationCenter, places []doctolib.BookingPlace, practiceIds []int) {
+	for _, place := range places {
+		if place.Latitude == 0 && place.Longitude == 0 {
+			continue
+		}
+		for _, practiceId := range place.PracticeIds {
+			if utils.IntSliceContains(practiceIds, practiceId) {
+				r.mutex.Lock()
+				r.locations[center.Name] = geo.Coordinates{Latitude: place.Latitude, Longitude: place.Longitude}
+				r.mutex.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// Distance returns the distance (in km) between home and center, and false if either location is unknown.
+func (r *CenterRanking) Distance(center *VaccinationCenter) (float64, bool) {
+	if r.home == nil {
+		return 0, false
+	}
+
+	r.mutex.RLock()
+	coordinates, ok := r.locations[center.Name]
+	r.mutex.RUnlock()
+	if !ok {
+		coordinates = center.Coordinates
+	}
+	if coordinates.IsZero() {
+		return 0, false
+	}
+
+	return geo.Distance(*r.home, coordinates), true
+}
+
+// Accepts reports whether center isn't known to be farther from home than its maximum distance.
+func (r *CenterRanking) Accepts(center *VaccinationCenter) bool {
+	maxDistance := r.maxDistance
+	if center.MaxDistance > 0 {
+		maxDistance = center.MaxDistance
+	}
+	if maxDistance == 0 {
+		return true
+	}
+
+	distance, ok := r.Distance(center)
+	return !ok || distance <= maxDistance
+}
+
+// Sort sorts centers by decreasing priority, then by increasing distance from home (centers of unknown location
+// last). Without home, centers of the same priority keep their order.
+func (r *CenterRanking) Sort(centers []*VaccinationCenter) {
+	sort.SliceStable(centers, func(i, j int) bool {
+		if centers[i].Priority != centers[j].Priority {
+			return centers[i].Priority > centers[j].Priority
+		}
+
+		distanceI, okI := r.Distance(centers[i])
+		distanceJ, okJ := r.Distance(centers[j])
+		if okI != okJ {
+			return okI
+		}
+		return distanceI < distanceJ
+	})
+}
+
+// NewCenterRanking creates a ranking from the home location of config, which must have been located.
+func NewCenterRanking(config *Config) *CenterRanking {
+	return &CenterRanking{
+		home:        config.Home.location,
+		maxDistance: config.Home.MaxDistance,
+		locations:   make(map[string]geo.Coordinates),
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/geo"
+	"slices"
+	"testing"
+)
+
+var (
+	testHome = geo.Coordinates{Latitude: 48.8566, Longitude: 2.3522} // Paris
+	testNear = geo.Coordinates{Latitude: 48.8049, Longitude: 2.1204} // Versailles, 18 km away
+	testFar  = geo.Coordinates{Latitude: 45.764, Longitude: 4.8357}  // Lyon, 392 km away
+	testMid  = geo.Coordinates{Latitude: 49.4432, Longitude: 1.0999} // Rouen, 112 km away
+)
+
+func newTestRanking(home *geo.Coordinates, maxDistance float64) *CenterRanking {
+	config := DefaultConfig()
+	config.Home.location = home
+	config.Home.MaxDistance = maxDistance
+
+	return NewCenterRanking(config)
+}
+
+func TestCenterRankingDistance(t *testing.T) {
+	ranking := newTestRanking(&testHome, 0)
+	ranking.Locate(&VaccinationCenter{Name: "located"}, []doctolib.BookingPlace{
+		{PracticeIds: []int{1}, Latitude: testFar.Latitude, Longitude: testFar.Longitude},
+		{PracticeIds: []int{2}, Latitude: testNear.Latitude, Longitude: testNear.Longitude},
+	}, []int{2, 3})
+	ranking.Locate(&VaccinationCenter{Name: "unlocated-place", Coordinates: testMid}, []doctolib.BookingPlace{
+		{PracticeIds: []int{1}},
+	}, []int{1})
+
+	tests := []struct {
+		center *VaccinationCenter
+		want   float64
+		wantOk bool
+	}{
+		{center: &VaccinationCenter{Name: "located", Coordinates: testFar}, want: 18, wantOk: true},
+		{center: &VaccinationCenter{Name: "unlocated-place", Coordinates: testMid}, want: 112, wantOk: true},
+		{center: &VaccinationCenter{Name: "from-file", Coordinates: testFar}, want: 392, wantOk: true},
+		{center: &VaccinationCenter{Name: "unknown"}},
+	}
+	for _, test := range tests {
+		distance, ok := ranking.Distance(test.center)
+		if ok != test.wantOk || (ok && (distance < test.want-1 || distance > test.want+1)) {
+			t.Errorf("Distance(%s) = %.1f, %t, want %.0f, %t", test.center.Name, distance, ok, test.want,
+				test.wantOk)
+		}
+	}
+
+	if _, ok := newTestRanking(nil, 0).Distance(&VaccinationCenter{Name: "from-file", Coordinates: testFar}); ok {
+		t.Error("Distance() without home is known")
+	}
+}
+
+func TestCenterRankingAccepts(t *testing.T) {
+	ranking := newTestRanking(&testHome, 100)
+	tests := []struct {
+		center *VaccinationCenter
+		want   bool
+	}{
+		{center: &VaccinationCenter{Name: "near", Coordinates: testNear}, want: true},
+		{center: &VaccinationCenter{Name: "far", Coordinates: testFar}, want: false},
+		{center: &VaccinationCenter{Name: "far-allowed", Coordinates: testFar, MaxDistance: 500}, want: true},
+		{center: &VaccinationCenter{Name: "near-restricted", Coordinates: testNear, MaxDistance: 10}, want: false},
+		{center: &VaccinationCenter{Name: "unknown"}, want: true},
+	}
+	for _, test := range tests {
+		if got := ranking.Accepts(test.center); got != test.want {
+			t.Errorf("Accepts(%s) = %t, want %t", test.center.Name, got, test.want)
+		}
+	}
+}
+
+func TestCenterRankingSort(t *testing.T) {
+	newCenters := func() []*VaccinationCenter {
+		return []*VaccinationCenter{
+			{Name: "unknown"},
+			{Name: "far", Coordinates: testFar},
+			{Name: "near-low", Coordinates: testNear, Priority: -1},
+			{Name: "mid", Coordinates: testMid},
+			{Name: "unknown-high", Priority: 2},
+			{Name: "near", Coordinates: testNear},
+			{Name: "far-high", Coordinates: testFar, Priority: 2},
+		}
+	}
+	tests := []struct {
+		name    string
+		ranking *CenterRanking
+		want    []string
+	}{
+		{
+			name:    "priority then distance",
+			ranking: newTestRanking(&testHome, 0),
+			want:    []string{"far-high", "unknown-high", "near", "mid", "far", "unknown", "near-low"},
+		},
+		{
+			name:    "priority only without home",
+			ranking: newTestRanking(nil, 0),
+			want:    []string{"unknown-high", "far-high", "unknown", "far", "mid", "near", "near-low"},
+		},
+	}
+
+	for _, test := range tests {
+		centers := newCenters()
+		test.ranking.Sort(centers)
+		var names []string
+		for _, center := range centers {
+			names = append(names, center.Name)
+		}
+		if !slices.Equal(names, test.want) {
+			t.Errorf("%s: Sort() = %v, want %v", test.name, names, test.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Reloader reloads the configuration and the vaccination centers files when they change or on demand. It hands the
+// new set of centers to the scheduler, and applies the new notifiers and arbitration settings. The other settings are
+// only applied on restart.
+type Reloader struct {
+	configFilepath string
+	config         *Config
+	load           func() (*Config, error)
+	scheduler      *Scheduler
+	arbiter        *Arbiter
+	notifications  *Notifications
+	files          []string
+	fileStates     map[string]fileState
+	stop           chan bool
+	logger         *slog.Logger
+}
+
+// liveSections are the sections of the configuration applied on reload.
+var liveSections = map[string]bool{
+	"centers":       true,
+	"notifications": true,
+	"arbitration":   true,
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchedFiles returns the configuration file, if any, and the centers files of config.
+func (r *Reloader) watchedFiles(config *Config) []string {
+	var files []string
+	if r.configFilepath != "" {
+		files = append(files, r.configFilepath)
+	}
+	return append(files, config.Centers.Files...)
+}
+
+// checkFiles records the state of the watched files and returns true if any of them changed since the last call.
+func (r *Reloader) checkFiles(files []string) bool {
+	changed := false
+	fileStates := make(map[string]fileState, len(files))
+	for _, file := range files {
+		var state fileState
+		if fileInfo, err := os.Stat(file); err == nil {
+			state = fileState{modTime: fileInfo.ModTime(), size: fileInfo.Size()}
+		}
+		if previousState, ok := r.fileStates[file]; !ok || previousState != state {
+			changed = true
+		}
+		fileStates[file] = state
+	}
+	r.fileStates = fileStates
+
+	return changed
+}
+
+// centersDiff returns the names of the centers of updated which aren't in current, and of the centers of current which
+// aren't in updated.
+func centersDiff(current []*VaccinationCenter, updated []*VaccinationCenter) (added []string, removed []string) {
+	currentNames := make(map[string]bool, len(current))
+	for _, center := range current {
+		currentNames[center.Name] = true
+	}
+	updatedNames := make(map[string]bool, len(updated))
+	for _, center := range updated {
+		updatedNames[center.Name] = true
+		if !currentNames[center.Name] {
+			added = append(added, center.Name)
+		}
+	}
+	for _, center := range current {
+		if !updatedNames[center.Name] {
+			removed = append(removed, center.Name)
+		}
+	}
+
+	return added, removed
+}
+
+// Reload loads the configuration and the vaccination centers again. The running configuration is kept if they are
+// invalid.
+func (r *Reloader) Reload(reason string) {
+	logger := r.logger.With("reason", reason)
+	config, err := r.load()
+	if err != nil {
+		logger.Error("Failed to reload configuration", logging.ErrorKey, err)
+		return
+	}
+	r.files = r.watchedFiles(config) // Watch the new centers files
+	r.checkFiles(r.files)
+	vaccinationCenters, err := LoadVaccinationCenters(config)
+	if err != nil {
+		logger.Error("Failed to reload vaccination centers", logging.ErrorKey, err)
+		return
+	}
+
+	if err := r.notifications.Update(config.Notifications); err != nil {
+		logger.Error("Failed to reload notifiers", logging.ErrorKey, err)
+		return
+	}
+	r.arbiter.Update(&config.Arbitration)
+
+	var appliedSections, ignoredSections []string
+	for _, section := range r.config.ChangedSections(config) {
+		if liveSections[section] {
+			appliedSections = append(appliedSections, section)
+		} else {
+			ignoredSections = append(ignoredSections, section)
+		}
+	}
+	if len(ignoredSections) > 0 {
+		logger.Warn("Ignoring configuration changes which require a restart", "sections", ignoredSections)
+	}
+	r.config = config
+
+	scheduledCenters, err := r.scheduler.Centers()
+	if err != nil {
+		return // Stopping
+	}
+	currentCenters := make([]*VaccinationCenter, len(scheduledCenters))
+	for i, scheduledCenter := range scheduledCenters {
+		currentCenters[i] = scheduledCenter.Center
+	}
+	if err := r.scheduler.UpdateCenters(vaccinationCenters); err != nil {
+		return // Stopping
+	}
+	added, removed := centersDiff(currentCenters, vaccinationCenters)
+	logger.Info("Reloaded configuration", "sections", appliedSections, "centers", len(vaccinationCenters),
+		"added", added, "removed", removed)
+}
+
+// Run reloads on every signal received from signals and, if watchInterval isn't 0, whenever a watched file changes,
+// until the stop channel is closed.
+func (r *Reloader) Run(signals <-chan os.Signal, watchInterval time.Duration) {
+	var watch <-chan time.Time
+	if watchInterval > 0 {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		watch = ticker.C
+	}
+
+	for {
+		select {
+		case received := <-signals:
+			r.Reload(received.String())
+		case <-watch:
+			if r.checkFiles(r.files) {
+				r.Reload("file changed")
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// NewReloader creates a Reloader of config, read from configFilepath (if not empty) by load.
+func NewReloader(configFilepath string, config *Config, load func() (*Config, error), scheduler *Scheduler,
+	arbiter *Arbiter, notifications *Notifications, stop chan bool, logger *slog.Logger) *Reloader {
+	reloader := &Reloader{
+		configFilepath: configFilepath,
+		config:         config,
+		load:           load,
+		scheduler:      scheduler,
+		arbiter:        arbiter,
+		notifications:  notifications,
+		stop:           stop,
+		logger:         logger,
+	}
+	reloader.files = reloader.watchedFiles(config)
+	reloader.checkFiles(reloader.files)
+
+	return reloader
+}
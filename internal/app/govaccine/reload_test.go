@@ -0,0 +1,252 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCentersDiff(t *testing.T) {
+	centers := func(names ...string) []*VaccinationCenter {
+		var centers []*VaccinationCenter
+		for _, name := range names {
+			centers = append(centers, &VaccinationCenter{Name: name})
+		}
+		return centers
+	}
+	tests := []struct {
+		name        string
+		current     []*VaccinationCenter
+		updated     []*VaccinationCenter
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "unchanged", current: centers("a", "b"), updated: centers("b", "a")},
+		{name: "added", current: centers("a"), updated: centers("a", "b", "c"), wantAdded: []string{"b", "c"}},
+		{name: "removed", current: centers("a", "b", "c"), updated: centers("b"), wantRemoved: []string{"a", "c"}},
+		{
+			name:        "added and removed",
+			current:     centers("a", "b"),
+			updated:     centers("b", "c"),
+			wantAdded:   []string{"c"},
+			wantRemoved: []string{"a"},
+		},
+		{name: "from none", updated: centers("a"), wantAdded: []string{"a"}},
+		{name: "to none", current: centers("a"), wantRemoved: []string{"a"}},
+	}
+
+	for _, test := range tests {
+		added, removed := centersDiff(test.current, test.updated)
+		if !slices.Equal(added, test.wantAdded) || !slices.Equal(removed, test.wantRemoved) {
+			t.Errorf("%s: centersDiff() = %v, %v, want %v, %v", test.name, added, removed, test.wantAdded,
+				test.wantRemoved)
+		}
+	}
+}
+
+func TestReloaderCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "govaccine.yaml")
+	centersFile := filepath.Join(dir, "centers.txt")
+	writeFile := func(file string, content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(configFile, "centers:\n  files: [centers.txt]\n")
+	writeFile(centersFile, "https://www.doctolib.fr/c/paris/centre-a\n")
+	reloader := &Reloader{}
+	files := []string{configFile, centersFile}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   bool
+	}{
+		{name: "first check", change: func() {}, want: true},
+		{name: "no change", change: func() {}, want: false},
+		{
+			name: "content changed",
+			change: func() {
+				writeFile(centersFile, "https://www.doctolib.fr/c/paris/centre-a\n"+
+					"https://www.doctolib.fr/c/paris/centre-b\n")
+			},
+			want: true,
+		},
+		{
+			name: "modification time changed",
+			change: func() {
+				modTime := time.Now().Add(time.Hour)
+				if err := os.Chtimes(configFile, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{name: "no change again", change: func() {}, want: false},
+		{
+			name: "file removed",
+			change: func() {
+				if err := os.Remove(centersFile); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{name: "still removed", change: func() {}, want: false},
+		{
+			name:   "file created",
+			change: func() { writeFile(centersFile, "https://www.doctolib.fr/c/paris/centre-c\n") },
+			want:   true,
+		},
+	}
+
+	for _, step := range steps {
+		step.change()
+		if got := reloader.checkFiles(files); got != step.want {
+			t.Errorf("%s: checkFiles() = %t, want %t", step.name, got, step.want)
+		}
+	}
+
+	// A newly watched file is a change
+	if !reloader.checkFiles(append(files, filepath.Join(dir, "other.txt"))) {
+		t.Error("checkFiles() = false with a new watched file, want true")
+	}
+}
+
+func TestReload(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, req.URL.Path)
+	}))
+	defer server.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	dir := t.TempDir()
+	centersFile := filepath.Join(dir, "centers.txt")
+	if err := os.WriteFile(centersFile, []byte("https://www.doctolib.fr/c/paris/centre-d\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Centers.Urls = []string{"https://www.doctolib.fr/c/paris/centre-a",
+		"https://www.doctolib.fr/c/paris/centre-b"}
+	config.Notifications = []NotifierConfig{{Type: "webhook", Url: server.URL + "/old"}}
+	config.Arbitration.Prefer = []string{PreferPriority, PreferDate}
+	centers, err := LoadVaccinationCenters(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan bool)
+	defer close(stop)
+	scheduler := NewScheduler(centers, config, NewCenterRanking(config), stop, logger)
+	go scheduler.Run(NewWorkerPool(nil, nil, nil))
+	arbiter := NewArbiter(config, NewBookingCoordinator(nil, stop), stop, logger)
+	notifications, err := NewNotifications(config.Notifications, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded *Config
+	var loadErr error
+	reloader := NewReloader("", config, func() (*Config, error) {
+		return loaded, loadErr
+	}, scheduler, arbiter, notifications, stop, logger)
+
+	checkState := func(step string, wantConfig *Config, wantCenters []string, wantPreferences []string,
+		wantNotified string) {
+		t.Helper()
+		if reloader.config != wantConfig {
+			t.Errorf("%s: the running configuration wasn't updated", step)
+		}
+		scheduledCenters, err := scheduler.Centers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, center := range scheduledCenters {
+			names = append(names, center.Center.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, wantCenters) {
+			t.Errorf("%s: scheduled centers %v, want %v", step, names, wantCenters)
+		}
+		if !slices.Equal(arbiter.preferences, wantPreferences) {
+			t.Errorf("%s: arbitration preferences %v, want %v", step, arbiter.preferences, wantPreferences)
+		}
+
+		mutex.Lock()
+		received = nil
+		mutex.Unlock()
+		notifications.Notify(testEvent)
+		notifications.Wait()
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !slices.Equal(received, []string{wantNotified}) {
+			t.Errorf("%s: notified %v, want [%s]", step, received, wantNotified)
+		}
+	}
+
+	updatedConfig := DefaultConfig()
+	updatedConfig.Centers.Urls = []string{"https://www.doctolib.fr/c/paris/centre-b",
+		"https://www.doctolib.fr/c/paris/centre-c"}
+	updatedConfig.Centers.Files = []string{centersFile}
+	updatedConfig.Notifications = []NotifierConfig{{Type: "webhook", Url: server.URL + "/new"}}
+	updatedConfig.Arbitration.Prefer = []string{PreferDate}
+	updatedConfig.Scheduling.Workers = 2 // Only applied on restart
+	loaded = updatedConfig
+	reloader.Reload("test")
+	checkState("reload", updatedConfig, []string{"centre-b", "centre-c", "centre-d"}, []string{PreferDate}, "/new")
+	if !slices.Contains(reloader.files, centersFile) {
+		t.Errorf("watched files %v, want %s", reloader.files, centersFile)
+	}
+
+	loaded, loadErr = nil, errors.New("invalid configuration")
+	reloader.Reload("invalid configuration")
+	checkState("invalid configuration", updatedConfig, []string{"centre-b", "centre-c", "centre-d"},
+		[]string{PreferDate}, "/new")
+
+	invalidNotifiersConfig := DefaultConfig()
+	invalidNotifiersConfig.Centers.Urls = []string{"https://www.doctolib.fr/c/paris/centre-a"}
+	invalidNotifiersConfig.Notifications = []NotifierConfig{{Type: "pigeon"}}
+	loaded, loadErr = invalidNotifiersConfig, nil
+	reloader.Reload("invalid notifiers")
+	checkState("invalid notifiers", updatedConfig, []string{"centre-b", "centre-c", "centre-d"},
+		[]string{PreferDate}, "/new")
+}
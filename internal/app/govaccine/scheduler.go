@@ -0,0 +1,682 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"github.com/GuiTeK/govaccine/internal/pkg/metrics"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// throttleCooldown is the time during which the worker pool doesn't grow after Doctolib throttled requests.
+const throttleCooldown = time.Minute
+
+var (
+	// ErrUnknownCenter is returned when a vaccination center isn't scheduled.
+	ErrUnknownCenter = errors.New("unknown vaccination center")
+	// ErrCenterExists is returned when adding a vaccination center which is already scheduled.
+	ErrCenterExists = errors.New("vaccination center already scheduled")
+	// ErrSchedulerStopped is returned once the scheduler stopped.
+	ErrSchedulerStopped = errors.New("the scheduler stopped")
+	// ErrNoPendingAccount is returned when growing the worker pool while no account has patients waiting.
+	ErrNoPendingAccount = errors.New("no account has patients waiting for an appointment")
+)
+
+// SchedulerStatus is a snapshot of the state of the Scheduler and its worker pool.
+type SchedulerStatus struct {
+	Workers    int `json:"workers"`
+	MinWorkers int `json:"min_workers"`
+	MaxWorkers int `json:"max_workers"`
+	// Backlog is the number of centers which haven't been checked for more than the target latency
+	Backlog int  `json:"backlog"`
+	Paused  bool `json:"paused"`
+}
+
+// ScheduledCenter is a vaccination center along with its scheduling state.
+type ScheduledCenter struct {
+	Center *VaccinationCenter
+	Paused bool
+	// LastDispatched is the last time a worker took the center to check it
+	LastDispatched time.Time
+}
+
+// Scheduler dispatches the vaccination centers to the workers in turn, and resizes the worker pool so that every
+// center is checked at least once per target latency without getting throttled by Doctolib.
+type Scheduler struct {
+	mutex          sync.Mutex
+	centers        []*VaccinationCenter
+	lastDispatched map[*VaccinationCenter]time.Time
+	backlog        int
+	paused         bool
+	pausedCenters  map[string]bool
+	position       int             // Position of the next center to dispatch in the current rotation
+	dispatched     map[string]bool // Centers dispatched during the current rotation, by name
+	urgent         []*VaccinationCenter
+	jobs           chan *VaccinationCenter
+	taken          chan *VaccinationCenter
+	commands       chan func()
+	throttled      chan struct{}
+	ranking        *CenterRanking
+	minWorkers     int
+	maxWorkers     int
+	targetLatency  time.Duration
+	stop           chan bool
+	logger         *slog.Logger
+	pool           *WorkerPool
+	grown          chan error
+	growing        bool // A worker is being added to the pool
+	grownAt        time.Time
+	throttledAt    time.Time
+}
+
+// Worker checks the vaccination centers dispatched by the scheduler until quit is closed.
+type Worker interface {
+	TryBookVaccine(quit <-chan struct{})
+}
+
+// WorkerPool runs a variable number of workers, identified by a number starting at 1. Each worker uses one of the
+// accounts, and only the accounts with patients waiting for an appointment get new workers.
+type WorkerPool struct {
+	mutex     sync.Mutex
+	accounts  []*AccountConfig
+	pending   func() (map[string][]PatientConfig, error)
+	newWorker func(id int, account *AccountConfig) (Worker, error)
+	workers   map[int]*pooledWorker
+	waitGroup sync.WaitGroup
+}
+
+type pooledWorker struct {
+	account string
+	quit    chan struct{}
+}
+
+var (
+	schedulerBacklog = metrics.NewGaugeVec("govaccine_scheduler_backlog",
+		"Number of vaccination centers which haven't been checked for more than the target latency")
+	throttledRequestsTotal = metrics.NewCounterVec("govaccine_throttled_requests_total",
+		"Number of requests throttled by Doctolib")
+	workerPoolResizesTotal = metrics.NewCounterVec("govaccine_worker_pool_resizes_total",
+		"Number of times the worker pool was resized, by direction (grow, shrink)", "direction")
+)
+
+// Jobs returns the channel from which workers receive the vaccination centers to check.
+func (s *Scheduler) Jobs() <-chan *VaccinationCenter {
+	return s.jobs
+}
+
+// JobTaken tells the scheduler that a worker took center from the jobs channel to check it.
+func (s *Scheduler) JobTaken(center *VaccinationCenter) {
+	select {
+	case s.taken <- center:
+	case <-s.stop:
+	}
+}
+
+// do runs command in the Run goroutine, which owns the vaccination centers, and waits for it to complete.
+func (s *Scheduler) do(command func()) error {
+	done := make(chan struct{})
+	select {
+	case s.commands <- func() {
+		command()
+		close(done)
+	}:
+	case <-s.stop:
+		return ErrSchedulerStopped
+	}
+	<-done
+
+	return nil
+}
+
+func (s *Scheduler) findCenter(name string) *VaccinationCenter {
+	for _, center := range s.centers {
+		if center.Name == name {
+			return center
+		}
+	}
+	return nil
+}
+
+// UpdateCenters replaces the vaccination centers to dispatch. Centers being checked or booked aren't interrupted.
+func (s *Scheduler) UpdateCenters(vaccinationCenters []*VaccinationCenter) error {
+	return s.do(func() {
+		s.setCenters(vaccinationCenters)
+	})
+}
+
+// AddCenter adds center to the vaccination centers to dispatch.
+func (s *Scheduler) AddCenter(center *VaccinationCenter) error {
+	var err error
+	doErr := s.do(func() {
+		if s.findCenter(center.Name) != nil {
+			err = ErrCenterExists
+			return
+		}
+		s.setCenters(append(append([]*VaccinationCenter(nil), s.centers...), center))
+	})
+
+	return errors.Join(doErr, err)
+}
+
+// RemoveCenter stops dispatching the vaccination center called name. A check in progress isn't interrupted.
+func (s *Scheduler) RemoveCenter(name string) error {
+	var err error
+	doErr := s.do(func() {
+		centers := make([]*VaccinationCenter, 0, len(s.centers))
+		for _, center := range s.centers {
+			if center.Name != name {
+				centers = append(centers, center)
+			}
+		}
+		if len(centers) == len(s.centers) {
+			err = ErrUnknownCenter
+			return
+		}
+		s.setCenters(centers)
+	})
+
+	return errors.Join(doErr, err)
+}
+
+// PauseCenter stops (or resumes, if paused is false) dispatching the vaccination center called name.
+func (s *Scheduler) PauseCenter(name string, paused bool) error {
+	var err error
+	doErr := s.do(func() {
+		if s.findCenter(name) == nil {
+			err = ErrUnknownCenter
+			return
+		}
+		if paused {
+			s.pausedCenters[name] = true
+		} else {
+			delete(s.pausedCenters, name)
+		}
+	})
+
+	return errors.Join(doErr, err)
+}
+
+// Pause stops (or resumes, if paused is false) dispatching all the vaccination centers.
+func (s *Scheduler) Pause(paused bool) error {
+	return s.do(func() {
+		s.mutex.Lock()
+		s.paused = paused
+		s.mutex.Unlock()
+	})
+}
+
+// CheckNow dispatches the vaccination center called name before the others, even if it is paused.
+func (s *Scheduler) CheckNow(name string) error {
+	var err error
+	doErr := s.do(func() {
+		center := s.findCenter(name)
+		if center == nil {
+			err = ErrUnknownCenter
+			return
+		}
+		s.urgent = append(s.urgent, center)
+	})
+
+	return errors.Join(doErr, err)
+}
+
+// Centers returns the vaccination centers in the order of the current rotation.
+func (s *Scheduler) Centers() ([]ScheduledCenter, error) {
+	var centers []ScheduledCenter
+	err := s.do(func() {
+		centers = make([]ScheduledCenter, len(s.centers))
+		for i, center := range s.centers {
+			centers[i] = ScheduledCenter{
+				Center:         center,
+				Paused:         s.pausedCenters[center.Name],
+				LastDispatched: s.lastDispatched[center],
+			}
+		}
+	})
+
+	return centers, err
+}
+
+// setCenters replaces the vaccination centers by vaccinationCenters, keeping the state of the unchanged ones, and
+// goes on with the current rotation.
+func (s *Scheduler) setCenters(vaccinationCenters []*VaccinationCenter) {
+	currentCenters := make(map[string]*VaccinationCenter, len(s.centers))
+	for _, center := range s.centers {
+		currentCenters[center.Name] = center
+	}
+
+	now := time.Now()
+	centers := make([]*VaccinationCenter, 0, len(vaccinationCenters))
+	lastDispatched := make(map[*VaccinationCenter]time.Time, len(vaccinationCenters))
+	for _, center := range vaccinationCenters {
+		currentCenter, ok := currentCenters[center.Name]
+		switch {
+		case !ok:
+			s.logger.Info("Added vaccination center", logging.CenterKey, center.Name)
+			lastDispatched[center] = now
+		case *currentCenter == *center:
+			center = currentCenter
+			lastDispatched[center] = s.lastDispatched[currentCenter]
+		default:
+			s.logger.Info("Updated vaccination center", logging.CenterKey, center.Name)
+			lastDispatched[center] = s.lastDispatched[currentCenter]
+		}
+		delete(currentCenters, center.Name)
+		centers = append(centers, center)
+	}
+	for name := range currentCenters {
+		s.logger.Info("Removed vaccination center", logging.CenterKey, name)
+		delete(s.dispatched, name)
+		delete(s.pausedCenters, name)
+	}
+	urgent := s.urgent[:0]
+	for _, center := range s.urgent {
+		if currentCenters[center.Name] == nil {
+			urgent = append(urgent, center)
+		}
+	}
+	s.urgent = urgent
+
+	// The centers already dispatched during the current rotation come first, so that the rotation goes on with the
+	// other ones
+	s.ranking.Sort(centers)
+	sort.SliceStable(centers, func(i, j int) bool {
+		return s.dispatched[centers[i].Name] && !s.dispatched[centers[j].Name]
+	})
+	s.centers = centers
+	s.lastDispatched = lastDispatched
+	s.position = len(s.dispatched)
+}
+
+// ReportThrottled tells the scheduler that Doctolib throttled a request, so that it shrinks the worker pool.
+func (s *Scheduler) ReportThrottled() {
+	throttledRequestsTotal.Inc()
+	select {
+	case s.throttled <- struct{}{}:
+	default: // Already reported since the last autoscaling decision
+	}
+}
+
+// Status returns the current state of the scheduler.
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := SchedulerStatus{MinWorkers: s.minWorkers, MaxWorkers: s.maxWorkers, Backlog: s.backlog, Paused: s.paused}
+	if s.pool != nil {
+		status.Workers = s.pool.Size()
+	}
+	return status
+}
+
+// updateBacklog counts the centers no worker took for more than the target latency, paused ones
+// excepted.
+func (s *Scheduler) updateBacklog(now time.Time) int {
+	s.mutex.Lock()
+	paused := s.paused
+	s.mutex.Unlock()
+
+	backlog := 0
+	for _, center := range s.centers {
+		if !paused && !s.pausedCenters[center.Name] && now.Sub(s.lastDispatched[center]) > s.targetLatency {
+			backlog++
+		}
+	}
+
+	s.mutex.Lock()
+	s.backlog = backlog
+	s.mutex.Unlock()
+	schedulerBacklog.Set(float64(backlog))
+
+	return backlog
+}
+
+// autoscale shrinks the worker pool if Doctolib throttled requests since the last call, or grows it if some centers
+// are overdue. The pool grows by a single worker per target latency, to give the new worker time to catch up.
+func (s *Scheduler) autoscale(now time.Time) {
+	backlog := s.updateBacklog(now)
+	if stopped := s.pool.StopIdleWorkers(); stopped > 0 {
+		workerPoolResizesTotal.Add(float64(stopped), "shrink")
+		s.logger.Info("Worker pool resized", "reason", "no patient waiting", "workers", s.pool.Size(),
+			"backlog", backlog)
+	}
+	size := s.pool.Size()
+
+	select {
+	case <-s.throttled:
+		s.throttledAt = now
+		if size > s.minWorkers && s.pool.Shrink() {
+			workerPoolResizesTotal.Inc("shrink")
+			s.logger.Info("Worker pool resized", "reason", "throttled", "workers", s.pool.Size(), "backlog", backlog)
+		}
+		return
+	default:
+	}
+
+	if s.growing || backlog == 0 || size >= s.maxWorkers || now.Sub(s.throttledAt) < throttleCooldown ||
+		now.Sub(s.grownAt) < s.targetLatency {
+		return
+	}
+	// The new worker logs in to Doctolib: don't hold the dispatch up meanwhile
+	s.growing = true
+	s.grownAt = now
+	go func() {
+		err := s.pool.Grow()
+		select {
+		case s.grown <- err:
+		case <-s.stop:
+		}
+	}()
+}
+
+// grew records the outcome of the growth of the worker pool started by autoscale.
+func (s *Scheduler) grew(err error) {
+	s.growing = false
+	if errors.Is(err, ErrNoPendingAccount) {
+		s.logger.Debug("Not growing the worker pool", "reason", err)
+		return
+	}
+	if err != nil {
+		s.grownAt = time.Time{} // Try again at the next autoscaling decision
+		s.logger.Warn("Failed to grow the worker pool", logging.ErrorKey, err)
+		return
+	}
+
+	workerPoolResizesTotal.Inc("grow")
+	s.logger.Info("Worker pool resized", "reason", "backlog", "workers", s.pool.Size(), "backlog", s.backlog)
+}
+
+// nextCenter returns the next vaccination center to dispatch, nil if there is none.
+func (s *Scheduler) nextCenter() *VaccinationCenter {
+	if len(s.urgent) > 0 {
+		return s.urgent[0]
+	}
+	s.mutex.Lock()
+	paused := s.paused
+	s.mutex.Unlock()
+	if paused {
+		return nil
+	}
+
+	for n := 0; n < len(s.centers); n++ { // At most a whole rotation, skipping the paused centers
+		if s.position >= len(s.centers) {
+			s.position = 0
+			s.dispatched = make(map[string]bool, len(s.centers))
+			s.ranking.Sort(s.centers) // Centers located by the workers during the last rotation come first
+		}
+		if center := s.centers[s.position]; !s.pausedCenters[center.Name] {
+			return center
+		}
+		s.position++
+	}
+
+	return nil
+}
+
+// Run dispatches the vaccination centers to the workers of pool until the stop channel is closed, then closes the jobs
+// channel.
+func (s *Scheduler) Run(pool *WorkerPool) {
+	s.mutex.Lock()
+	s.pool = pool
+	s.mutex.Unlock()
+
+	var autoscaling <-chan time.Time
+	if s.targetLatency > 0 && s.minWorkers < s.maxWorkers {
+		ticker := time.NewTicker(min(max(s.targetLatency/4, time.Second), 10*time.Second))
+		defer ticker.Stop()
+		autoscaling = ticker.C
+	}
+
+	for {
+		var jobs chan *VaccinationCenter
+		center := s.nextCenter()
+		if center != nil {
+			jobs = s.jobs // Nothing to dispatch until centers are added or resumed otherwise
+		}
+
+		select {
+		case jobs <- center:
+			if len(s.urgent) > 0 && s.urgent[0] == center {
+				s.urgent = s.urgent[1:]
+			} else {
+				s.dispatched[center.Name] = true
+				s.position++
+			}
+		case center := <-s.taken:
+			if _, ok := s.lastDispatched[center]; ok { // Not removed since it was dispatched
+				s.lastDispatched[center] = time.Now()
+			}
+		case command := <-s.commands:
+			command()
+		case now := <-autoscaling:
+			s.autoscale(now)
+		case err := <-s.grown:
+			s.grew(err)
+		case <-s.stop:
+			s.logger.Info("Scheduler received stop signal")
+			close(s.jobs)
+			return
+		}
+	}
+}
+
+// Size returns the number of workers in the pool.
+func (p *WorkerPool) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.workers)
+}
+
+// pendingAccounts returns whether each account has patients waiting for an appointment.
+func (p *WorkerPool) pendingAccounts() map[string]bool {
+	pendingAccounts := make(map[string]bool, len(p.accounts))
+	if p.pending == nil {
+		for _, account := range p.accounts {
+			pendingAccounts[account.Username] = true
+		}
+		return pendingAccounts
+	}
+
+	pendingPatients, err := p.pending()
+	if err != nil {
+		return pendingAccounts // Stopped: nobody is waiting anymore
+	}
+	for _, account := range p.accounts {
+		pendingAccounts[account.Username] = len(pendingPatients[account.Username]) > 0
+	}
+	return pendingAccounts
+}
+
+// accountWorkers counts the workers of each account.
+func (p *WorkerPool) accountWorkers() map[string]int {
+	accountWorkers := make(map[string]int, len(p.accounts))
+	for _, worker := range p.workers {
+		accountWorkers[worker.account]++
+	}
+	return accountWorkers
+}
+
+// Grow creates a worker with the smallest free ID and starts it. It uses the account with patients waiting which has
+// the fewest workers.
+func (p *WorkerPool) Grow() error {
+	pendingAccounts := p.pendingAccounts()
+
+	p.mutex.Lock()
+	accountWorkers := p.accountWorkers()
+	var account *AccountConfig
+	for _, candidate := range p.accounts {
+		if pendingAccounts[candidate.Username] &&
+			(account == nil || accountWorkers[candidate.Username] < accountWorkers[account.Username]) {
+			account = candidate
+		}
+	}
+	if account == nil {
+		p.mutex.Unlock()
+		return fmt.Errorf("govaccine.WorkerPool.Grow(): %w", ErrNoPendingAccount)
+	}
+	id := 1
+	for ; p.workers[id] != nil; id++ {
+	}
+	worker := &pooledWorker{account: account.Username, quit: make(chan struct{})}
+	p.workers[id] = worker // Reserves the ID while the worker logs in
+	p.mutex.Unlock()
+
+	bot, err := p.newWorker(id, account)
+	if err != nil {
+		p.remove(id, worker)
+		return fmt.Errorf("govaccine.WorkerPool.Grow(): cannot create worker %d: %w", id, err)
+	}
+
+	p.waitGroup.Add(1)
+	go func() {
+		defer p.waitGroup.Done()
+		defer p.remove(id, worker)
+		bot.TryBookVaccine(worker.quit)
+	}()
+
+	return nil
+}
+
+// Shrink stops a worker once it is done with its current job: one of an account without patients waiting if any,
+// else one of the account with the most workers, the one with the greatest ID first. It returns false if the pool is
+// empty.
+func (p *WorkerPool) Shrink() bool {
+	pendingAccounts := p.pendingAccounts()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	accountWorkers := p.accountWorkers()
+	stoppedId := 0
+	for id, worker := range p.workers {
+		if stoppedId == 0 {
+			stoppedId = id
+			continue
+		}
+		stopped := p.workers[stoppedId]
+		if pendingAccounts[worker.account] != pendingAccounts[stopped.account] {
+			if !pendingAccounts[worker.account] {
+				stoppedId = id
+			}
+		} else if accountWorkers[worker.account] != accountWorkers[stopped.account] {
+			if accountWorkers[worker.account] > accountWorkers[stopped.account] {
+				stoppedId = id
+			}
+		} else if id > stoppedId {
+			stoppedId = id
+		}
+	}
+	if stoppedId == 0 {
+		return false
+	}
+	close(p.workers[stoppedId].quit)
+	delete(p.workers, stoppedId)
+
+	return true
+}
+
+// StopIdleWorkers stops the workers of the accounts without patients waiting, once they are done with their current
+// job, and returns how many were stopped.
+func (p *WorkerPool) StopIdleWorkers() int {
+	pendingAccounts := p.pendingAccounts()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stopped := 0
+	for id, worker := range p.workers {
+		if !pendingAccounts[worker.account] {
+			close(worker.quit)
+			delete(p.workers, id)
+			stopped++
+		}
+	}
+
+	return stopped
+}
+
+func (p *WorkerPool) remove(id int, worker *pooledWorker) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.workers[id] == worker { // Not already removed by Shrink
+		delete(p.workers, id)
+	}
+}
+
+// Wait waits for all the workers to exit.
+func (p *WorkerPool) Wait() {
+	p.waitGroup.Wait()
+}
+
+// NewScheduler creates a Scheduler dispatching vaccinationCenters until stop is closed.
+func NewScheduler(vaccinationCenters []*VaccinationCenter, config *Config, ranking *CenterRanking, stop chan bool,
+	logger *slog.Logger) *Scheduler {
+	scheduler := &Scheduler{
+		centers:        vaccinationCenters,
+		lastDispatched: make(map[*VaccinationCenter]time.Time, len(vaccinationCenters)),
+		pausedCenters:  make(map[string]bool),
+		dispatched:     make(map[string]bool, len(vaccinationCenters)),
+		jobs:           make(chan *VaccinationCenter, config.Scheduling.MinWorkers),
+		taken:          make(chan *VaccinationCenter),
+		commands:       make(chan func()),
+		grown:          make(chan error),
+		throttled:      make(chan struct{}, 1),
+		ranking:        ranking,
+		minWorkers:     max(int(config.Scheduling.MinWorkers), len(config.Accounts)),
+		maxWorkers:     int(config.Scheduling.MaxWorkers),
+		targetLatency:  config.Scheduling.TargetLatency,
+		stop:           stop,
+		logger:         logger,
+	}
+
+	now := time.Now()
+	for _, center := range vaccinationCenters {
+		scheduler.lastDispatched[center] = now
+	}
+
+	return scheduler
+}
+
+// NewWorkerPool creates an empty WorkerPool whose workers are created by newWorker for one of accounts. pending
+// returns the patients waiting for an appointment by account, every account has some if it is nil.
+func NewWorkerPool(accounts []AccountConfig, pending func() (map[string][]PatientConfig, error),
+	newWorker func(id int, account *AccountConfig) (Worker, error)) *WorkerPool {
+	pool := &WorkerPool{
+		pending:   pending,
+		newWorker: newWorker,
+		workers:   make(map[int]*pooledWorker),
+	}
+	for i := range accounts {
+		pool.accounts = append(pool.accounts, &accounts[i])
+	}
+
+	return pool
+}
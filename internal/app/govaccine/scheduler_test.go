@@ -0,0 +1,222 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWorker runs until it is told to quit.
+type testWorker struct{}
+
+func (w *testWorker) TryBookVaccine(quit <-chan struct{}) {
+	<-quit
+}
+
+// testPool is a worker pool of test workers for the accounts a and b, whose patients waiting can be changed.
+type testPool struct {
+	*WorkerPool
+	mutex   sync.Mutex
+	pending map[string][]PatientConfig
+	failing bool
+}
+
+func newTestPool(t *testing.T) *testPool {
+	t.Helper()
+	pool := &testPool{
+		pending: map[string][]PatientConfig{"a": {{Id: 1}}, "b": {{Id: 2}}},
+	}
+	accounts := []AccountConfig{{Username: "a"}, {Username: "b"}}
+	pool.WorkerPool = NewWorkerPool(accounts, func() (map[string][]PatientConfig, error) {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		return maps.Clone(pool.pending), nil
+	}, func(id int, account *AccountConfig) (Worker, error) {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		if pool.failing {
+			return nil, errors.New("login failed")
+		}
+		return &testWorker{}, nil
+	})
+	t.Cleanup(func() {
+		for pool.Shrink() {
+		}
+		pool.Wait()
+	})
+
+	return pool
+}
+
+func (p *testPool) setPending(username string, patients ...PatientConfig) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pending[username] = patients
+}
+
+// running returns the accounts of the running workers, by ID.
+func (p *testPool) running() map[int]string {
+	p.WorkerPool.mutex.Lock()
+	defer p.WorkerPool.mutex.Unlock()
+	running := make(map[int]string, len(p.workers))
+	for id, worker := range p.workers {
+		running[id] = worker.account
+	}
+	return running
+}
+
+func TestWorkerPoolGrowShrink(t *testing.T) {
+	pool := newTestPool(t)
+	grow := func(wantErr error) {
+		t.Helper()
+		if err := pool.Grow(); !errors.Is(err, wantErr) || (wantErr == nil) != (err == nil) {
+			t.Fatalf("Grow() error = %v, want %v", err, wantErr)
+		}
+	}
+	check := func(step string, want map[int]string) {
+		t.Helper()
+		if got := pool.running(); !maps.Equal(got, want) {
+			t.Errorf("%s: running workers %v, want %v", step, got, want)
+		}
+		if pool.Size() != len(want) {
+			t.Errorf("%s: Size() = %d, want %d", step, pool.Size(), len(want))
+		}
+	}
+
+	grow(nil)
+	grow(nil)
+	grow(nil)
+	check("accounts in turn", map[int]string{1: "a", 2: "b", 3: "a"})
+
+	if !pool.Shrink() {
+		t.Fatal("Shrink() = false")
+	}
+	check("shrink the account with the most workers", map[int]string{1: "a", 2: "b"})
+	grow(nil)
+	check("ID reused", map[int]string{1: "a", 2: "b", 3: "a"})
+
+	pool.setPending("a")
+	grow(nil)
+	check("only accounts with patients waiting", map[int]string{1: "a", 2: "b", 3: "a", 4: "b"})
+	pool.Shrink()
+	check("shrink an account without patients waiting", map[int]string{1: "a", 2: "b", 4: "b"})
+
+	grow(nil)
+	check("smallest free ID", map[int]string{1: "a", 2: "b", 3: "b", 4: "b"})
+	if stopped := pool.StopIdleWorkers(); stopped != 1 {
+		t.Errorf("StopIdleWorkers() = %d, want 1", stopped)
+	}
+	check("idle workers stopped", map[int]string{2: "b", 3: "b", 4: "b"})
+
+	pool.setPending("b")
+	grow(ErrNoPendingAccount)
+	check("no account with patients waiting", map[int]string{2: "b", 3: "b", 4: "b"})
+
+	pool.setPending("a", PatientConfig{Id: 1})
+	pool.mutex.Lock()
+	pool.failing = true
+	pool.mutex.Unlock()
+	if err := pool.Grow(); err == nil || errors.Is(err, ErrNoPendingAccount) {
+		t.Fatalf("Grow() error = %v, want a login error", err)
+	}
+	check("ID released on error", map[int]string{2: "b", 3: "b", 4: "b"})
+	pool.mutex.Lock()
+	pool.failing = false
+	pool.mutex.Unlock()
+	grow(nil)
+	check("ID released on error reused", map[int]string{1: "a", 2: "b", 3: "b", 4: "b"})
+
+	for pool.Shrink() {
+	}
+	check("empty", map[int]string{})
+	if pool.Shrink() {
+		t.Error("Shrink() = true on an empty pool")
+	}
+}
+
+func TestSchedulerAutoscale(t *testing.T) {
+	const targetLatency = 30 * time.Second
+	config := DefaultConfig()
+	config.Accounts = []AccountConfig{{Username: "a"}, {Username: "b"}}
+	config.Scheduling.MinWorkers = 1 // Raised to the number of accounts
+	config.Scheduling.MaxWorkers = 4
+	config.Scheduling.TargetLatency = targetLatency
+	center, err := newVaccinationCenter(VaccinationCenter{Url: "https://www.doctolib.fr/c/paris/centre-a"},
+		config.DoctolibUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan bool)
+	defer close(stop)
+	scheduler := NewScheduler([]*VaccinationCenter{center}, config, NewCenterRanking(config), stop,
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	pool := newTestPool(t)
+	scheduler.pool = pool.WorkerPool
+	for i := 0; i < 2; i++ {
+		if err := pool.Grow(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	autoscale := func(step string, elapsed time.Duration, wantWorkers int) {
+		t.Helper()
+		scheduler.autoscale(now.Add(elapsed))
+		if scheduler.growing {
+			scheduler.grew(<-scheduler.grown)
+		}
+		if size := pool.Size(); size != wantWorkers {
+			t.Errorf("%s: %d workers, want %d", step, size, wantWorkers)
+		}
+	}
+
+	autoscale("no backlog", 0, 2)
+	autoscale("backlog", 2*targetLatency, 3)
+	autoscale("backlog, just grown", 2*targetLatency+time.Second, 3)
+	autoscale("backlog again", 3*targetLatency+time.Second, 4)
+	autoscale("backlog at max", 5*targetLatency, 4)
+
+	scheduler.ReportThrottled()
+	autoscale("throttled", 5*targetLatency, 3)
+	autoscale("backlog during cooldown", 5*targetLatency+throttleCooldown/2, 3)
+	scheduler.ReportThrottled()
+	autoscale("throttled again", 5*targetLatency+throttleCooldown/2, 2)
+	scheduler.ReportThrottled()
+	autoscale("throttled at min", 5*targetLatency+throttleCooldown/2, 2)
+	autoscale("backlog after cooldown", 5*targetLatency+2*throttleCooldown, 3)
+
+	pool.setPending("b")
+	autoscale("account without patients waiting", 5*targetLatency+2*throttleCooldown, 2)
+	for id, account := range pool.running() {
+		if account != "a" {
+			t.Errorf("worker %d of account %s still running", id, account)
+		}
+	}
+}
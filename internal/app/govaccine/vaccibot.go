@@ -24,55 +24,72 @@
 package govaccine
 
 import (
+	"errors"
 	"fmt"
 	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
 	"github.com/GuiTeK/govaccine/internal/pkg/utils"
-	"sync"
+	"log/slog"
+	"math"
+	"strings"
 	"time"
 )
 
 type Vaccibot struct {
 	name             string
-	jobs             chan string
+	account          *AccountConfig
+	config           *Config
+	scheduler        *Scheduler
 	stop             chan bool
-	mutex            *sync.Mutex
+	coordinator      *BookingCoordinator
+	ranking          *CenterRanking
+	arbiter          *Arbiter
+	monitor          *Monitor
 	doctolibClient   *doctolib.Client
-	sleepDuration    time.Duration
+	notifications    *Notifications
+	auditLog         *AuditLog
+	history          *HistoryStore
+	logger           *slog.Logger
 	currentCsrfToken string
 }
 
 type vaccinationSettings struct {
-	profileId      int
-	visitMotiveIds []int
-	agendaIds      []int
-	practiceIds    []int
-	csrfToken      string
+	profileId       int
+	visitMotiveName string
+	motiveRank      int
+	visitMotiveIds  []int
+	agendaIds       []int
+	practiceIds     []int
+	csrfToken       string
 }
 
 const PfizerBiontechVaccineVisitMotiveName = "1re injection vaccin COVID-19 (Pfizer-BioNTech)"
 
-func (v *Vaccibot) getVaccinationSettings(vaccinationCenter string, csrfToken string) (*vaccinationSettings, error) {
+func (v *Vaccibot) getVaccinationSettings(center *VaccinationCenter, csrfToken string) (*vaccinationSettings, error) {
+	vaccinationCenter := center.Name
 	bookingResponse, err := v.doctolibClient.GetBooking(vaccinationCenter, csrfToken)
 	if err != nil {
-		return nil, fmt.Errorf("govaccine.getVaccinationSettings(): failed to get booking for %s: %s",
+		return nil, fmt.Errorf("govaccine.getVaccinationSettings(): failed to get booking for %s: %w",
 			vaccinationCenter, err)
 	}
 
 	vacSettings := &vaccinationSettings{
 		profileId: bookingResponse.Data.Profile.Id,
 	}
-	for _, visitMotive := range bookingResponse.Data.VisitMotives {
-		if visitMotive.Name != PfizerBiontechVaccineVisitMotiveName {
-			continue
-		}
-
-		if len(vacSettings.visitMotiveIds) > 0 {
+	// Motive selectors are sorted by preference: use the first one matching a visit motive of the center
+	for rank, motiveSelector := range v.config.centerMotiveSelectors(center) {
+		visitMotives := matchVisitMotives(&motiveSelector, bookingResponse.Data.VisitMotives)
+		if len(visitMotives) > 1 {
 			return nil, fmt.Errorf(
-				"govaccine.getVaccinationSettings(): unhandled case: vaccination center %s has multiple choices for Pfizer-BioNTech 1st injection",
-				vaccinationCenter)
+				"govaccine.getVaccinationSettings(): unhandled case: vaccination center %s has multiple visit motives matching %s",
+				vaccinationCenter, &motiveSelector)
+		}
+		if len(visitMotives) == 1 {
+			vacSettings.visitMotiveName = visitMotives[0].Name
+			vacSettings.motiveRank = rank
+			vacSettings.visitMotiveIds = append(vacSettings.visitMotiveIds, visitMotives[0].Id)
+			break
 		}
-
-		vacSettings.visitMotiveIds = append(vacSettings.visitMotiveIds, visitMotive.Id)
 	}
 
 	if len(vacSettings.visitMotiveIds) == 0 {
@@ -87,9 +104,7 @@ func (v *Vaccibot) getVaccinationSettings(vaccinationCenter string, csrfToken st
 		}
 
 		if agenda.BookingDisabled || agenda.BookingTemporaryDisabled {
-			fmt.Printf(
-				"govaccine.getVaccinationSettings(): warning: agenda %d is disabled for vaccination center %s\n",
-				agenda.Id, vaccinationCenter)
+			v.logger.Warn("Agenda is disabled", logging.CenterKey, vaccinationCenter, "agenda_id", agenda.Id)
 			continue
 		}
 
@@ -106,160 +121,800 @@ func (v *Vaccibot) getVaccinationSettings(vaccinationCenter string, csrfToken st
 			vaccinationCenter)
 	}
 
+	v.ranking.Locate(center, bookingResponse.Data.Places, vacSettings.practiceIds)
 	vacSettings.csrfToken = bookingResponse.CsrfToken
 
 	return vacSettings, nil
 }
 
-func (v *Vaccibot) TryBookVaccine() {
-	for vaccinationCenter := range v.jobs {
-		fmt.Printf("[INFO] Vaccibot \"%s\" is checking %s\n", v.name, vaccinationCenter)
+func (v *Vaccibot) notify(eventType EventType, vaccinationCenter string, startDate string, appointmentId string,
+	message string) {
+	v.notifications.Notify(&Event{
+		Type:              eventType,
+		Time:              time.Now(),
+		Worker:            v.name,
+		VaccinationCenter: vaccinationCenter,
+		StartDate:         startDate,
+		AppointmentId:     appointmentId,
+		Message:           message,
+	})
+}
 
-		if utils.IsBoolChannelClosed(v.stop) {
-			fmt.Printf("[INFO] Vaccibot \"%s\" received stop signal\n", v.name)
-			return
+// auditRun records a booking run in the audit log.
+type auditRun struct {
+	id                string
+	vaccibot          *Vaccibot
+	vaccinationCenter string
+	slot              string
+}
+
+func (v *Vaccibot) audit(record *AuditRecord) {
+	record.Time = time.Now()
+	record.Worker = v.name
+
+	if err := v.auditLog.Write(record); err != nil {
+		v.logger.Warn("Failed to write audit record", logging.ErrorKey, err)
+	}
+}
+
+// startAuditRun records the beginning of a booking run and every Doctolib request sent until it finishes.
+func (v *Vaccibot) startAuditRun(vaccinationCenter string, vaccinationSettings *vaccinationSettings,
+	slot string) *auditRun {
+	run := &auditRun{id: newRunId(), vaccibot: v, vaccinationCenter: vaccinationCenter, slot: slot}
+	if v.auditLog == nil {
+		return run
+	}
+
+	v.audit(&AuditRecord{
+		RunId:          run.id,
+		Event:          AuditRunStarted,
+		Center:         vaccinationCenter,
+		ProfileId:      vaccinationSettings.profileId,
+		VisitMotiveIds: vaccinationSettings.visitMotiveIds,
+		AgendaIds:      vaccinationSettings.agendaIds,
+		PracticeIds:    vaccinationSettings.practiceIds,
+		Slot:           slot,
+	})
+	v.doctolibClient.SetCallObserver(func(call *doctolib.Call) {
+		v.audit(&AuditRecord{
+			RunId:  run.id,
+			Event:  AuditApiCall,
+			Center: vaccinationCenter,
+			Call:   newAuditCall(call),
+		})
+	})
+
+	return run
+}
+
+func (r *auditRun) finish(appointmentId string, err error) {
+	if r.vaccibot.auditLog == nil {
+		return
+	}
+	r.vaccibot.doctolibClient.SetCallObserver(nil)
+
+	record := &AuditRecord{
+		RunId:         r.id,
+		Event:         AuditRunSucceeded,
+		Center:        r.vaccinationCenter,
+		Slot:          r.slot,
+		AppointmentId: appointmentId,
+	}
+	if err != nil {
+		record.Event = AuditRunFailed
+		record.Error = err.Error()
+	} else if r.vaccibot.config.DryRun {
+		record.Event = AuditRunDryRun
+	}
+	r.vaccibot.audit(record)
+}
+
+func (v *Vaccibot) login() error {
+	loginResponse, err := v.doctolibClient.Login(v.account.Username, v.account.secret.Bytes())
+	if err != nil {
+		return fmt.Errorf("govaccine.login(): failed to login: %w", err)
+	}
+	v.logger.Info("Logged in", "full_name", loginResponse.FullName, "user_id", loginResponse.Id)
+
+	v.currentCsrfToken = loginResponse.CsrfToken
+
+	return nil
+}
+
+// recordBookingStage records that a booking stage went through, or failed with err, in the metrics and the monitor.
+func (v *Vaccibot) recordBookingStage(stage string, err error) {
+	recordBookingStage(stage, err)
+	v.monitor.RecordBookingStage(v.name, stage, err)
+}
+
+// checkError logs in again if err shows that the Doctolib session was lost, and reports throttled requests to the
+// scheduler.
+func (v *Vaccibot) checkError(err error) {
+	if doctolib.IsThrottled(err) {
+		v.scheduler.ReportThrottled()
+		v.monitor.RecordThrottled()
+		return
+	}
+	if !doctolib.IsUnauthorized(err) {
+		return
+	}
+
+	v.logger.Warn("Lost Doctolib session, logging in again", logging.RequestIdKey, doctolib.RequestId(err))
+	v.notify(EventSessionLost, "", "", "", fmt.Sprintf("Vaccibot \"%s\" lost its Doctolib session", v.name))
+	sessionReloginsTotal.Inc()
+	v.monitor.RecordRelogin()
+
+	if err := v.login(); err != nil {
+		v.logger.Error("Failed to log in again", logging.ErrorKey, err)
+	}
+}
+
+// checkBookedPatients stops the search for the patients of the account waiting for an appointment who already hold
+// an appointment, upcoming or past, for a visit motive matching a motive selector. When rescheduling, the patients
+// holding an upcoming appointment keep waiting for a better one.
+func (v *Vaccibot) checkBookedPatients() error {
+	pendingPatients, err := v.coordinator.PendingPatients()
+	if err != nil || len(pendingPatients[v.account.Username]) == 0 {
+		return nil
+	}
+
+	masterPatientsResponse, err := v.doctolibClient.GetMasterPatients(v.currentCsrfToken)
+	if err != nil {
+		v.checkError(err)
+		return fmt.Errorf("govaccine.checkBookedPatients(): failed to get master patients: %w", err)
+	}
+	v.currentCsrfToken = masterPatientsResponse.CsrfToken
+
+	for _, patient := range pendingPatients[v.account.Username] {
+		for _, masterPatient := range masterPatientsResponse.MasterPatients {
+			if !patient.Matches(masterPatient.Id, masterPatient.FirstName, masterPatient.LastName) {
+				continue
+			}
+
+			appointmentsResponse, err := v.doctolibClient.ListAppointments(masterPatient.Id, v.currentCsrfToken)
+			if err != nil {
+				v.checkError(err)
+				return fmt.Errorf("govaccine.checkBookedPatients(): failed to list appointments of patient %d: %w",
+					masterPatient.Id, err)
+			}
+			v.currentCsrfToken = appointmentsResponse.CsrfToken
+
+			appointment, upcoming, motiveRank := v.config.BookedAppointment(appointmentsResponse)
+			if appointment != nil {
+				v.coordinator.RecordAppointment(v.account.Username, &masterPatient)
+			}
+			if appointment != nil && upcoming && v.config.Reschedule.Enabled {
+				v.holdAppointment(patient, appointment, motiveRank)
+				break
+			}
+			// Another worker of the account may have stopped the search first
+			if appointment != nil && v.coordinator.StopSearch(v.account.Username, patient) == nil {
+				v.logger.Info("Not looking for an appointment, the patient already has one", "patient",
+					patient.String(), logging.AppointmentIdKey, appointment.Id, "start_date", appointment.StartDate,
+					"visit_motive", appointment.VisitMotiveName)
+			}
+			break
 		}
-		time.Sleep(v.sleepDuration)
+	}
 
-		vaccinationSettings, err := v.getVaccinationSettings(vaccinationCenter, v.currentCsrfToken)
-		if err != nil {
-			fmt.Printf("[WARNING] Vaccibot \"%s\" failed to get vaccination settings: %s\n", v.name, err)
+	return nil
+}
+
+// holdAppointment records that patient holds appointment, matching the motive selector motiveRank, so that it is
+// only replaced by a better slot.
+func (v *Vaccibot) holdAppointment(patient PatientConfig, appointment *doctolib.Appointment, motiveRank int) {
+	slotStart, err := time.Parse(doctolib.DatetimeLayout, appointment.StartDate)
+	if err != nil {
+		v.logger.Warn("Not rescheduling the appointment, its start date is invalid", "patient", patient.String(),
+			logging.AppointmentIdKey, appointment.Id, "start_date", appointment.StartDate)
+		_ = v.coordinator.StopSearch(v.account.Username, patient)
+		return
+	}
+
+	held := &HeldAppointment{
+		Appointment: *appointment,
+		Candidate:   &Candidate{SlotStart: slotStart, Distance: -1, MotiveRank: motiveRank},
+	}
+	if v.coordinator.HoldAppointment(v.account.Username, patient, held) == nil {
+		v.logger.Info("Looking for a better slot than the appointment already held", "patient", patient.String(),
+			logging.AppointmentIdKey, appointment.Id, "start_date", appointment.StartDate,
+			"visit_motive", appointment.VisitMotiveName)
+	}
+}
+
+// improvedPatients returns the patients of ticket who hold no appointment, or one worse than candidate. An
+// appointment booked while searching is only worse than the slots earlier by the minimum improvement.
+func (v *Vaccibot) improvedPatients(ticket *BookingTicket, candidate *Candidate) []PatientConfig {
+	var patients []PatientConfig
+	for _, patient := range ticket.Patients() {
+		held := ticket.Held(patient)
+		switch {
+		case held == nil:
+		case held.Upgrade:
+			improvement := held.Candidate.SlotStart.Sub(candidate.SlotStart)
+			if improvement <= 0 || improvement < v.config.Upgrade.MinImprovement {
+				continue
+			}
+		case !v.arbiter.Improves(candidate, held.Candidate):
 			continue
 		}
-		v.currentCsrfToken = vaccinationSettings.csrfToken
+		patients = append(patients, patient)
+	}
 
-		startDate := time.Now().AddDate(0, 0, 1)
-		firstShotAvailabilitiesResponse, err := v.doctolibClient.GetAvailabilities(startDate, nil,
-			vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
-			1, v.currentCsrfToken)
-		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to get first shot availabilities: %s\n", v.name, err)
-			continue
+	return patients
+}
+
+// reportBooked reports to ticket that patient got appointment, starting at slotStart. If upgrades are enabled, the
+// appointment is held while looking for an earlier slot.
+func (v *Vaccibot) reportBooked(ticket *BookingTicket, patient PatientConfig, appointment doctolib.Appointment,
+	slotStart time.Time, motiveRank int) {
+	// No slot can be earlier enough if the appointment is that soon
+	if !v.config.Upgrade.Enabled || time.Until(slotStart) <= v.config.Upgrade.MinImprovement {
+		ticket.Booked(patient)
+		return
+	}
+
+	ticket.BookedAndHeld(patient, &HeldAppointment{
+		Appointment: appointment,
+		Candidate:   &Candidate{SlotStart: slotStart, Distance: -1, MotiveRank: motiveRank},
+		Upgrade:     true,
+	})
+	v.logger.Info("Looking for an earlier slot than the appointment booked", "patient", patient.String(),
+		logging.AppointmentIdKey, appointment.Id, "start_date", appointment.StartDate,
+		"min_improvement", v.config.Upgrade.MinImprovement)
+}
+
+// cancelHeldAppointment cancels the appointment held by patient, once a better one was booked.
+func (v *Vaccibot) cancelHeldAppointment(patient *doctolib.MasterPatient, held *HeldAppointment) {
+	var err error
+	v.currentCsrfToken, err = cancelAppointment(v.doctolibClient, &held.Appointment, v.currentCsrfToken)
+	v.recordBookingStage("cancel_previous", err)
+	if err != nil {
+		v.logger.Error("Failed to cancel the previous appointment, cancel it on Doctolib", logging.AppointmentIdKey,
+			held.Appointment.Id, logging.RequestIdKey, doctolib.RequestId(err), logging.ErrorKey, err)
+		message := fmt.Sprintf("Failed to cancel the previous appointment (ID %s) on %s for %s %s, cancel it on "+
+			"Doctolib: %s", held.Appointment.Id, held.Appointment.StartDate, patient.FirstName, patient.LastName, err)
+		var cancellationErr *CancellationError
+		if errors.As(err, &cancellationErr) {
+			message = fmt.Sprintf("Canceled the previous appointment (ID %s) on %s for %s %s but not its linked "+
+				"appointment (ID %s), cancel it on Doctolib: %s", held.Appointment.Id, held.Appointment.StartDate,
+				patient.FirstName, patient.LastName, cancellationErr.LinkedId, cancellationErr.Err)
 		}
-		v.currentCsrfToken = firstShotAvailabilitiesResponse.CsrfToken
-		if firstShotAvailabilitiesResponse.Total == 0 {
-			continue // No availability for now
+		v.notify(EventCancellationFailed, "", held.Appointment.StartDate, held.Appointment.Id, message)
+		v.checkError(err)
+		return
+	}
+
+	v.logger.Info("Canceled the previous appointment", logging.AppointmentIdKey, held.Appointment.Id,
+		"start_date", held.Appointment.StartDate)
+	v.notify(EventAppointmentCanceled, "", held.Appointment.StartDate, held.Appointment.Id,
+		fmt.Sprintf("Previous appointment (ID %s) on %s canceled for %s %s", held.Appointment.Id,
+			held.Appointment.StartDate, patient.FirstName, patient.LastName))
+}
+
+// selectPatient returns the first master patient matching one of pendingPatients that ticket could claim.
+func (v *Vaccibot) selectPatient(ticket *BookingTicket, masterPatients []doctolib.MasterPatient,
+	pendingPatients []PatientConfig) (*doctolib.MasterPatient, *PatientConfig, error) {
+	var claimErr error
+	for i := range pendingPatients {
+		for j := range masterPatients {
+			if !pendingPatients[i].Matches(masterPatients[j].Id, masterPatients[j].FirstName,
+				masterPatients[j].LastName) {
+				continue
+			}
+
+			// The patient may be listed by another account too
+			if claimErr = ticket.Claim(pendingPatients[i], &masterPatients[j]); claimErr != nil {
+				v.logger.Info("Not booking for the patient", "patient", pendingPatients[i].String(),
+					"reason", claimErr)
+				break
+			}
+			return &masterPatients[j], &pendingPatients[i], nil
 		}
+	}
+	if claimErr != nil {
+		return nil, nil, fmt.Errorf("govaccine.selectPatient(): %w", claimErr)
+	}
 
-		v.mutex.Lock() // Prevent two appointment bookings at the same time
+	var pendingPatientNames []string
+	for _, pendingPatient := range pendingPatients {
+		pendingPatientNames = append(pendingPatientNames, pendingPatient.String())
+	}
 
-		// Make sure no appointment was booked by another worker while we were waiting to acquire the lock
-		if utils.IsBoolChannelClosed(v.stop) {
-			fmt.Printf("[INFO] Vaccibot \"%s\" received stop signal\n", v.name)
-			v.mutex.Unlock()
-			return
+	return nil, nil, fmt.Errorf(
+		"govaccine.selectPatient(): none of the patients waiting for an appointment (%s) was found in the Doctolib account",
+		strings.Join(pendingPatientNames, ", "))
+}
+
+// selectSlot returns the first slot within the configured time window, and the second dose window in second dose
+// mode, or nil if there is none.
+func (v *Vaccibot) selectSlot(availabilitiesResponse *doctolib.AvailabilitiesResponse) *doctolib.AvailabilitySlot {
+	for i := range availabilitiesResponse.Availabilities {
+		for j, slot := range availabilitiesResponse.Availabilities[i].Slots {
+			slotStart, err := time.Parse(doctolib.DatetimeLayout, slot.StartDate)
+			if err != nil {
+				v.logger.Warn("Invalid slot start date", "start_date", slot.StartDate, logging.ErrorKey, err)
+				continue
+			}
+
+			if v.config.SecondDose.Enabled() && !v.config.SecondDose.Contains(slotStart) {
+				continue
+			}
+			if v.config.TimeWindow.Contains(slotStart) {
+				return &availabilitiesResponse.Availabilities[i].Slots[j]
+			}
 		}
+	}
 
-		createFirstShotAppointmentResponse, err := v.doctolibClient.CreateAppointment(
-			firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate, "",
+	return nil
+}
+
+// maxAvailabilitiesDays is the maximum number of days of availabilities Doctolib returns at once.
+const maxAvailabilitiesDays = 7
+
+var errSecondDoseWindowPassed = errors.New("the second dose window has passed")
+
+// getAvailabilities returns the first shot availabilities within the time window, and the date they start at. In
+// second dose mode, the second dose window is searched week after week until a slot is available.
+func (v *Vaccibot) getAvailabilities(vaccinationSettings *vaccinationSettings) (time.Time,
+	*doctolib.AvailabilitiesResponse, error) {
+	startDate := time.Now().AddDate(0, 0, v.config.TimeWindow.StartAfterDays)
+	if !v.config.SecondDose.Enabled() {
+		availabilitiesResponse, err := v.doctolibClient.GetAvailabilities(startDate, nil,
 			vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
-			vaccinationSettings.profileId, v.currentCsrfToken)
+			v.config.TimeWindow.Days, v.currentCsrfToken)
+		return startDate, availabilitiesResponse, err
+	}
+
+	earliest, latest := v.config.SecondDose.Window()
+	if startDate.Before(earliest) {
+		startDate = earliest
+	}
+	if v.config.SecondDose.WindowPassed(startDate) {
+		return startDate, nil, errSecondDoseWindowPassed
+	}
+
+	var availabilitiesResponse *doctolib.AvailabilitiesResponse
+	for chunkStart := startDate; chunkStart.Before(latest); {
+		var err error
+		availabilitiesResponse, err = v.doctolibClient.GetAvailabilities(chunkStart, nil,
+			vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
+			maxAvailabilitiesDays, v.currentCsrfToken)
 		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to create first shot appointment: %s\n", v.name, err)
-			v.mutex.Unlock()
-			continue
+			return startDate, nil, err
+		}
+		v.currentCsrfToken = availabilitiesResponse.CsrfToken
+		if availabilitiesResponse.Total > 0 {
+			return chunkStart, availabilitiesResponse, nil
 		}
-		v.currentCsrfToken = createFirstShotAppointmentResponse.CsrfToken
-		fmt.Printf("[INFO] Vaccibot \"%s\" created first shot appointment (ID %s)\n",
-			v.name, createFirstShotAppointmentResponse.Id)
+		chunkStart = chunkStart.AddDate(0, 0, maxAvailabilitiesDays)
+	}
 
-		secondShotStartDatetime, err := time.Parse("2006-01-02T15:04:05.000-07:00",
-			firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].Steps[1].StartDate)
-		if err != nil {
-			fmt.Printf(
-				"[ERROR] Vaccibot \"%s\" failed to parse second shot start datetime (%s): %s\n",
-				v.name, firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].Steps[1].StartDate, err)
-			v.mutex.Unlock()
-			continue
+	return startDate, availabilitiesResponse, nil
+}
+
+// bookSecondShot books the second shot matching firstShotSlot, whose temporary appointment is firstAppointmentId,
+// and returns its start date and appointment ID.
+func (v *Vaccibot) bookSecondShot(vaccinationCenter string, vaccinationSettings *vaccinationSettings,
+	firstShotSlot *doctolib.AvailabilitySlot, firstShotDatetime time.Time, firstAppointmentId string) (string, string,
+	error) {
+	if len(firstShotSlot.Steps) < 2 {
+		return "", "", fmt.Errorf("govaccine.bookSecondShot(): no second shot step in slot %s",
+			firstShotSlot.StartDate)
+	}
+	secondShotStartDatetime, err := time.Parse(doctolib.DatetimeLayout, firstShotSlot.Steps[1].StartDate)
+	if err != nil {
+		return "", "", fmt.Errorf("govaccine.bookSecondShot(): failed to parse second shot start datetime (%s): %w",
+			firstShotSlot.Steps[1].StartDate, err)
+	}
+	secondShotAvailabilitiesResponse, err := v.doctolibClient.GetAvailabilities(secondShotStartDatetime,
+		&firstShotDatetime,
+		vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
+		4, v.currentCsrfToken)
+	if err != nil {
+		return "", "", fmt.Errorf("govaccine.bookSecondShot(): failed to get second shot availabilities: %w", err)
+	}
+	v.currentCsrfToken = secondShotAvailabilitiesResponse.CsrfToken
+	if secondShotAvailabilitiesResponse.Total == 0 {
+		return "", "", fmt.Errorf("govaccine.bookSecondShot(): second shot no more available for appointment (ID %s)",
+			firstAppointmentId)
+	}
+	secondShotSlot := secondShotAvailabilitiesResponse.Availabilities[0].Slots[0]
+
+	createSecondShotAppointmentResponse, err := v.doctolibClient.CreateAppointment(firstShotSlot.StartDate,
+		secondShotSlot.StartDate,
+		vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
+		vaccinationSettings.profileId, v.currentCsrfToken)
+	v.recordBookingStage("create_second", err)
+	if err != nil {
+		return "", "", fmt.Errorf("govaccine.bookSecondShot(): failed to create second shot appointment (ID %s): %w",
+			firstAppointmentId, err)
+	}
+	v.currentCsrfToken = createSecondShotAppointmentResponse.CsrfToken
+	v.logger.Info("Created second shot appointment", logging.CenterKey, vaccinationCenter,
+		logging.AppointmentIdKey, createSecondShotAppointmentResponse.Id)
+
+	return secondShotSlot.StartDate, createSecondShotAppointmentResponse.Id, nil
+}
+
+// releaseAppointments releases the temporary appointments not yet confirmed at the center of vaccinationSettings:
+// Doctolib destroys them when availabilities starting at startDate are requested again.
+func (v *Vaccibot) releaseAppointments(vaccinationSettings *vaccinationSettings, startDate time.Time) error {
+	releaseResponse, err := v.doctolibClient.GetAvailabilities(startDate, nil,
+		vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
+		v.config.TimeWindow.Days, v.currentCsrfToken)
+	if err != nil {
+		return fmt.Errorf("govaccine.releaseAppointments(): %w", err)
+	}
+	v.currentCsrfToken = releaseResponse.CsrfToken
+
+	return nil
+}
+
+// skipCenter pauses vaccinationCenter, whose appointments can't be booked until the configuration is fixed because
+// of err. The center can be resumed with the control API.
+func (v *Vaccibot) skipCenter(vaccinationCenter string, err error) {
+	if pauseErr := v.scheduler.PauseCenter(vaccinationCenter, true); pauseErr != nil {
+		v.logger.Warn("Failed to pause the vaccination center", logging.CenterKey, vaccinationCenter,
+			logging.ErrorKey, pauseErr)
+		return
+	}
+	v.logger.Warn("Paused the vaccination center until the configuration is fixed", logging.CenterKey,
+		vaccinationCenter, "reason", err)
+}
+
+// bookAppointment books firstShotSlot and, unless in second dose mode, the matching second shot for one of patients,
+// of ticket. The appointment the patient held, if any, is canceled once the new one is confirmed. The temporary
+// appointments are released if the booking fails.
+func (v *Vaccibot) bookAppointment(vaccinationCenter string, vaccinationSettings *vaccinationSettings,
+	startDate time.Time, firstShotSlot *doctolib.AvailabilitySlot, ticket *BookingTicket,
+	patients []PatientConfig) (err error) {
+	run := v.startAuditRun(vaccinationCenter, vaccinationSettings, firstShotSlot.StartDate)
+	v.monitor.StartBooking(v.name, vaccinationCenter, firstShotSlot.StartDate)
+	appointmentId := ""
+	defer func() {
+		run.finish(appointmentId, err)
+		v.monitor.FinishBooking(v.name, err)
+	}()
+
+	masterPatientsResponse, err := v.doctolibClient.GetMasterPatients(v.currentCsrfToken)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to get master patients: %w", err)
+	}
+	v.currentCsrfToken = masterPatientsResponse.CsrfToken
+	masterPatient, patient, err := v.selectPatient(ticket, masterPatientsResponse.MasterPatients, patients)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): %w", err)
+	}
+
+	// The patient's status at the center decides whether an appointment can be booked, before taking one
+	patientStatus, err := v.doctolibClient.GetPatientStatus(masterPatient.Id, vaccinationSettings.profileId,
+		vaccinationSettings.practiceIds, v.currentCsrfToken)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to get status of patient %d: %w", masterPatient.Id,
+			err)
+	}
+	v.currentCsrfToken = patientStatus.CsrfToken
+	if patientStatus.MismatchInsurance {
+		return fmt.Errorf("govaccine.bookAppointment(): cannot book appointment at %s for %s %s: %w",
+			vaccinationCenter, masterPatient.FirstName, masterPatient.LastName, ErrInsuranceMismatch)
+	}
+	masterPatient.Consented = patientStatus.Consented
+
+	createFirstShotAppointmentResponse, err := v.doctolibClient.CreateAppointment(firstShotSlot.StartDate, "",
+		vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
+		vaccinationSettings.profileId, v.currentCsrfToken)
+	v.recordBookingStage("create_first", err)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to create first shot appointment: %w", err)
+	}
+	v.currentCsrfToken = createFirstShotAppointmentResponse.CsrfToken
+	appointmentId = createFirstShotAppointmentResponse.Id
+	v.logger.Info("Created first shot appointment", logging.CenterKey, vaccinationCenter,
+		logging.AppointmentIdKey, createFirstShotAppointmentResponse.Id)
+	defer func() {
+		if err == nil {
+			return
 		}
-		firstShotDatetime, err := time.Parse("2006-01-02T15:04:05.000-07:00",
-			firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate)
-		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to parse first shot datetime (%s): %s\n",
-				v.name, firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate, err)
-			v.mutex.Unlock()
-			continue
+		// Confirmed appointments aren't released, so it doesn't matter how far the booking went
+		if releaseErr := v.releaseAppointments(vaccinationSettings, startDate); releaseErr != nil {
+			v.logger.Warn("Failed to release the temporary appointment", logging.CenterKey, vaccinationCenter,
+				logging.AppointmentIdKey, createFirstShotAppointmentResponse.Id, logging.ErrorKey, releaseErr)
 		}
-		secondShotAvailabilitiesResponse, err := v.doctolibClient.GetAvailabilities(secondShotStartDatetime,
-			&firstShotDatetime,
-			vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
-			4, v.currentCsrfToken)
+	}()
+
+	// Check the answers the confirmation requires before going any further
+	appointmentDetails, err := v.doctolibClient.GetAppointment(createFirstShotAppointmentResponse.Id,
+		v.currentCsrfToken)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to get appointment (ID %s): %w",
+			createFirstShotAppointmentResponse.Id, err)
+	}
+	v.currentCsrfToken = appointmentDetails.CsrfToken
+	confirmation, err := v.config.Confirmation.confirmation(appointmentDetails)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): cannot confirm appointment (ID %s): %w",
+			createFirstShotAppointmentResponse.Id, err)
+	}
+	confirmation.NewPatient = patientStatus.NewPatient
+
+	firstShotDatetime, err := time.Parse(doctolib.DatetimeLayout, firstShotSlot.StartDate)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to parse first shot datetime (%s): %w",
+			firstShotSlot.StartDate, err)
+	}
+	// Only the second dose is booked in second dose mode, as a single appointment
+	secondShotAppointmentId := ""
+	secondShotDescription := ""
+	if !v.config.SecondDose.Enabled() {
+		var secondShotStartDate string
+		secondShotStartDate, secondShotAppointmentId, err = v.bookSecondShot(vaccinationCenter, vaccinationSettings,
+			firstShotSlot, firstShotDatetime, createFirstShotAppointmentResponse.Id)
 		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to get second shot availabilities: %s\n", v.name, err)
-			v.mutex.Unlock()
-			continue
+			return fmt.Errorf("govaccine.bookAppointment(): %w", err)
 		}
-		v.currentCsrfToken = secondShotAvailabilitiesResponse.CsrfToken
-		if secondShotAvailabilitiesResponse.Total == 0 {
-			fmt.Printf(
-				"[INFO] Vaccibot \"%s\" second shot no more available for appointment (ID %s)\n",
-				v.name, createFirstShotAppointmentResponse.Id)
-			v.mutex.Unlock()
-			continue
+		secondShotDescription = fmt.Sprintf(" (second shot on %s)", secondShotStartDate)
+	}
+
+	bookedAppointment := doctolib.Appointment{
+		Id:              createFirstShotAppointmentResponse.Id,
+		StartDate:       firstShotSlot.StartDate,
+		VisitMotiveName: vaccinationSettings.visitMotiveName,
+		ProfileName:     vaccinationCenter,
+	}
+	if secondShotAppointmentId != createFirstShotAppointmentResponse.Id {
+		bookedAppointment.LinkedAppointmentId = secondShotAppointmentId
+	}
+
+	if v.config.DryRun {
+		// Release the temporary appointments instead of confirming them
+		if err := v.releaseAppointments(vaccinationSettings, startDate); err != nil {
+			return fmt.Errorf("govaccine.bookAppointment(): failed to release temporary appointment (ID %s): %w",
+				createFirstShotAppointmentResponse.Id, err)
 		}
 
-		createSecondShotAppointmentResponse, err := v.doctolibClient.CreateAppointment(
-			firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate,
-			secondShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate,
-			vaccinationSettings.visitMotiveIds, vaccinationSettings.agendaIds, vaccinationSettings.practiceIds,
-			vaccinationSettings.profileId, v.currentCsrfToken)
-		if err != nil {
-			fmt.Printf(
-				"[ERROR] Vaccibot \"%s\" failed to create second shot appointment (ID %s): %s\n",
-				v.name, createFirstShotAppointmentResponse.Id, err)
-			v.mutex.Unlock()
-			continue
+		message := fmt.Sprintf(
+			"Dry run: would have confirmed appointment (ID %s) at %s on %s%s for %s %s",
+			createFirstShotAppointmentResponse.Id, vaccinationCenter, firstShotSlot.StartDate,
+			secondShotDescription, masterPatient.FirstName, masterPatient.LastName)
+		v.logger.Info(message, logging.CenterKey, vaccinationCenter,
+			logging.AppointmentIdKey, createFirstShotAppointmentResponse.Id)
+		v.notify(EventBookingConfirmed, vaccinationCenter, firstShotSlot.StartDate,
+			createFirstShotAppointmentResponse.Id, message)
+		if held := ticket.Held(*patient); held != nil {
+			v.logger.Info("Dry run: would have canceled the previous appointment", logging.AppointmentIdKey,
+				held.Appointment.Id, "start_date", held.Appointment.StartDate)
 		}
-		v.currentCsrfToken = createSecondShotAppointmentResponse.CsrfToken
-		fmt.Printf("[INFO] Vaccibot \"%s\" created second shot appointment (ID %s)\n",
-			v.name, createSecondShotAppointmentResponse.Id)
+		v.reportBooked(ticket, *patient, bookedAppointment, firstShotDatetime, vaccinationSettings.motiveRank)
 
-		masterPatientsResponse, err := v.doctolibClient.GetMasterPatients(v.currentCsrfToken)
-		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to get master patients: %s\n", v.name, err)
-			v.mutex.Unlock()
-			continue
+		return nil
+	}
+
+	_, err = v.doctolibClient.ConfirmAppointment(createFirstShotAppointmentResponse.Id, firstShotSlot.StartDate,
+		*masterPatient, confirmation, v.currentCsrfToken)
+	v.recordBookingStage("confirm", err)
+	if err != nil {
+		return fmt.Errorf("govaccine.bookAppointment(): failed to confirm appointment (ID %s): %w",
+			createFirstShotAppointmentResponse.Id, err)
+	}
+	v.logger.Info("Successfully confirmed the appointment, congratulations!", logging.CenterKey, vaccinationCenter,
+		logging.AppointmentIdKey, createFirstShotAppointmentResponse.Id)
+	v.notify(EventBookingConfirmed, vaccinationCenter, firstShotSlot.StartDate,
+		createFirstShotAppointmentResponse.Id,
+		fmt.Sprintf("Appointment (ID %s) confirmed at %s on %s%s for %s %s",
+			createFirstShotAppointmentResponse.Id, vaccinationCenter, firstShotSlot.StartDate,
+			secondShotDescription, masterPatient.FirstName, masterPatient.LastName))
+	if held := ticket.Held(*patient); held != nil {
+		v.cancelHeldAppointment(masterPatient, held)
+	}
+	v.reportBooked(ticket, *patient, bookedAppointment, firstShotDatetime, vaccinationSettings.motiveRank)
+
+	return nil
+}
+
+// checkVaccinationCenter looks for an available slot in vaccinationCenter and books it. It returns false if the
+// Vaccibot must stop.
+func (v *Vaccibot) checkVaccinationCenter(center *VaccinationCenter) bool {
+	busyWorkers.Add(1)
+	defer busyWorkers.Add(-1)
+
+	vaccinationCenter := center.Name
+	if !v.ranking.Accepts(center) {
+		return true
+	}
+	vaccinationSettings, err := v.getVaccinationSettings(center, v.currentCsrfToken)
+	if err != nil {
+		v.logger.Warn("Failed to get vaccination settings", logging.CenterKey, vaccinationCenter,
+			logging.RequestIdKey, doctolib.RequestId(err), logging.ErrorKey, err)
+		v.monitor.RecordError(vaccinationCenter, err)
+		v.checkError(err)
+		return true
+	}
+	v.currentCsrfToken = vaccinationSettings.csrfToken
+	if !v.ranking.Accepts(center) { // Located for the first time
+		v.logger.Info("Vaccination center is too far from home, skipping it", logging.CenterKey, vaccinationCenter)
+		return true
+	}
+
+	startDate, firstShotAvailabilitiesResponse, err := v.getAvailabilities(vaccinationSettings)
+	if err == errSecondDoseWindowPassed {
+		// No worker can book anything anymore, stop them all
+		v.logger.Warn("Stopping the search", "reason", err)
+		v.coordinator.Shutdown()
+		return false
+	}
+	if err != nil {
+		v.logger.Error("Failed to get first shot availabilities", logging.CenterKey, vaccinationCenter,
+			logging.MotiveKey, vaccinationSettings.visitMotiveIds, logging.RequestIdKey, doctolib.RequestId(err),
+			logging.ErrorKey, err)
+		v.monitor.RecordError(vaccinationCenter, err)
+		v.checkError(err)
+		return true
+	}
+	v.currentCsrfToken = firstShotAvailabilitiesResponse.CsrfToken
+	if firstShotAvailabilitiesResponse.Total == 0 {
+		return true // No availability for now
+	}
+
+	slotsSeenTotal.Add(float64(firstShotAvailabilitiesResponse.Total), vaccinationCenter,
+		vaccinationSettings.visitMotiveName)
+	err = v.history.RecordAvailabilities(vaccinationCenter, vaccinationSettings.visitMotiveName, time.Now(),
+		firstShotAvailabilitiesResponse)
+	if err != nil {
+		v.logger.Warn("Failed to record availabilities in history", logging.CenterKey, vaccinationCenter,
+			logging.ErrorKey, err)
+	}
+	firstShotSlot := v.selectSlot(firstShotAvailabilitiesResponse)
+	firstShotStartDate := ""
+	if firstShotSlot != nil {
+		firstShotStartDate = firstShotSlot.StartDate
+	}
+	v.monitor.RecordSighting(v.name, vaccinationCenter, vaccinationSettings.visitMotiveName,
+		firstShotAvailabilitiesResponse.Total, firstShotStartDate)
+	if firstShotSlot == nil {
+		v.logger.Debug("No available slot within the time window", logging.CenterKey, vaccinationCenter)
+		return true
+	}
+	candidate := &Candidate{Center: center, Worker: v.name, Username: v.account.Username, Distance: -1,
+		MotiveRank: vaccinationSettings.motiveRank}
+	candidate.SlotStart, _ = time.Parse(doctolib.DatetimeLayout, firstShotStartDate) // Checked by selectSlot
+	logger := v.logger
+	if distance, ok := v.ranking.Distance(center); ok {
+		candidate.Distance = distance
+		logger = logger.With("distance_km", math.Round(distance*10)/10)
+	}
+	logger.Info("Found available slot", logging.CenterKey, vaccinationCenter,
+		logging.MotiveKey, vaccinationSettings.visitMotiveIds, "start_date", firstShotStartDate)
+	v.notify(EventSlotFound, vaccinationCenter, firstShotStartDate, "",
+		fmt.Sprintf("Slot found at %s on %s", center, firstShotStartDate))
+	if v.config.WatchOnly {
+		return true
+	}
+
+	if !v.arbiter.Submit(candidate) {
+		v.logger.Info("Another worker found a better slot", logging.CenterKey, vaccinationCenter,
+			"start_date", firstShotStartDate)
+		return !utils.IsBoolChannelClosed(v.stop)
+	}
+
+	ticket, err := v.coordinator.RequestBooking(v.account.Username)
+	if errors.Is(err, ErrBookingInProgress) {
+		v.logger.Info("Not booking the slot", logging.CenterKey, vaccinationCenter, "reason", err)
+		return true
+	}
+	if err != nil {
+		v.logger.Info("Stopping", "reason", err)
+		return false
+	}
+	defer ticket.Release()
+	patients := v.improvedPatients(ticket, candidate)
+	if len(patients) == 0 {
+		v.logger.Info("Not booking the slot", logging.CenterKey, vaccinationCenter,
+			"reason", "not better than the appointments already held")
+		return true
+	}
+
+	err = v.bookAppointment(vaccinationCenter, vaccinationSettings, startDate, firstShotSlot, ticket, patients)
+	if errors.Is(err, ErrPatientBookingInProgress) || errors.Is(err, ErrPatientAlreadyBooked) {
+		v.logger.Info("Not booking the slot", logging.CenterKey, vaccinationCenter, "reason", err)
+		return !utils.IsBoolChannelClosed(v.stop)
+	}
+	if errors.Is(err, ErrStopped) {
+		v.logger.Info("Stopping", "reason", err)
+		return false
+	}
+	if err != nil {
+		v.logger.Error("Failed to book appointment", logging.CenterKey, vaccinationCenter,
+			logging.MotiveKey, vaccinationSettings.visitMotiveIds, logging.RequestIdKey, doctolib.RequestId(err),
+			logging.ErrorKey, err)
+		v.notify(EventBookingFailed, vaccinationCenter, firstShotStartDate, "",
+			fmt.Sprintf("Failed to book appointment at %s on %s: %s", center, firstShotStartDate, err))
+		v.monitor.RecordError(vaccinationCenter, err)
+		v.checkError(err)
+		// Every appointment of the center would fail the same way
+		if errors.Is(err, ErrMissingAnswers) {
+			v.skipCenter(vaccinationCenter, ErrMissingAnswers)
+		} else if errors.Is(err, ErrInsuranceMismatch) {
+			v.skipCenter(vaccinationCenter, ErrInsuranceMismatch)
 		}
-		v.currentCsrfToken = masterPatientsResponse.CsrfToken
+		return true
+	}
 
-		_, err = v.doctolibClient.ConfirmAppointment(createFirstShotAppointmentResponse.Id,
-			firstShotAvailabilitiesResponse.Availabilities[0].Slots[0].StartDate,
-			masterPatientsResponse.MasterPatients[0], v.currentCsrfToken)
-		if err != nil {
-			fmt.Printf("[ERROR] Vaccibot \"%s\" failed to confirm appointment (ID %s): %s\n",
-				v.name, createSecondShotAppointmentResponse.Id, err)
-			v.mutex.Unlock()
-			continue
+	return !utils.IsBoolChannelClosed(v.stop)
+}
+
+// TryBookVaccine checks the vaccination centers dispatched by the scheduler until there is no more job, the Vaccibot
+// must stop or quit is closed.
+func (v *Vaccibot) TryBookVaccine(quit <-chan struct{}) {
+	workersTotal.Add(1)
+	defer workersTotal.Add(-1)
+	v.monitor.AddWorker(v.name, v.account.Username)
+	defer v.monitor.RemoveWorker(v.name)
+
+	if err := v.checkBookedPatients(); err != nil {
+		v.logger.Warn("Failed to check the appointments already booked", logging.ErrorKey, err)
+	}
+
+	for {
+		var vaccinationCenter *VaccinationCenter
+		select {
+		case <-quit:
+			v.logger.Info("Leaving the worker pool")
+			return
+		case center, ok := <-v.scheduler.Jobs():
+			if !ok {
+				return
+			}
+			vaccinationCenter = center
+			v.scheduler.JobTaken(center)
+		}
+		v.logger.Info("Checking vaccination center", logging.CenterKey, vaccinationCenter.Name)
+
+		if utils.IsBoolChannelClosed(v.stop) {
+			v.logger.Info("Received stop signal")
+			return
+		}
+		time.Sleep(v.config.Scheduling.Sleep)
+
+		v.monitor.StartCheck(v.name, vaccinationCenter.Name)
+		carryOn := v.checkVaccinationCenter(vaccinationCenter)
+		v.monitor.FinishCheck(v.name, vaccinationCenter.Name)
+		if !carryOn {
+			return
 		}
-		fmt.Printf("[INFO] Vaccibot \"%s\" successfully confirmed the appointment, congratulations!\n", v.name)
-		close(v.stop)
-		v.mutex.Unlock()
 	}
 }
 
-func NewVaccibot(name string, doctolibUsername string, doctolibPassword string, jobs chan string, stop chan bool,
-	mutex *sync.Mutex, sleepDuration time.Duration, requestsTimeout time.Duration) (*Vaccibot, error) {
-	doctolibClient, err := doctolib.NewClient(requestsTimeout)
+// NewVaccibot creates a Vaccibot booking appointments for the patients of account, and logs it in.
+func NewVaccibot(name string, account *AccountConfig, config *Config, scheduler *Scheduler, stop chan bool,
+	coordinator *BookingCoordinator, ranking *CenterRanking, arbiter *Arbiter, monitor *Monitor,
+	notifications *Notifications, auditLog *AuditLog, history *HistoryStore, logger *slog.Logger) (*Vaccibot, error) {
+	logger = logger.With(logging.WorkerKey, name)
+	doctolibClient, err := doctolib.NewClient(config.DoctolibUrl, config.Scheduling.RequestsTimeout, logger)
 	if err != nil {
 		return nil, fmt.Errorf("govaccine.NewVaccibot(): cannot create Doctolib client: %w", err)
 	}
 
 	vaccibot := &Vaccibot{
 		name:           name,
-		jobs:           jobs,
+		account:        account,
+		config:         config,
+		scheduler:      scheduler,
 		stop:           stop,
-		mutex:          mutex,
+		coordinator:    coordinator,
+		ranking:        ranking,
+		arbiter:        arbiter,
+		monitor:        monitor,
 		doctolibClient: doctolibClient,
-		sleepDuration:  sleepDuration,
+		notifications:  notifications,
+		auditLog:       auditLog,
+		history:        history,
+		logger:         logger,
 	}
 
-	loginResponse, err := vaccibot.doctolibClient.Login(doctolibUsername, doctolibPassword)
-	if err != nil {
-		return nil, fmt.Errorf("govaccine.NewVaccibot(): failed to login: %w", err)
+	if err := vaccibot.login(); err != nil {
+		return nil, fmt.Errorf("govaccine.NewVaccibot(): %w", err)
 	}
-	fmt.Printf("[INFO] Vaccibot \"%s\" logged in as %s (ID %d)\n", name, loginResponse.FullName, loginResponse.Id)
-
-	vaccibot.currentCsrfToken = loginResponse.CsrfToken
 
 	return vaccibot, nil
 }
@@ -0,0 +1,166 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"github.com/GuiTeK/govaccine/internal/pkg/utils"
+	"net/http"
+)
+
+// CenterReport describes how a vaccination center is set up on Doctolib, as seen with the configured visit
+// motive selectors.
+type CenterReport struct {
+	Center     *VaccinationCenter
+	StatusCode int
+	ProfileId  int
+	// VisitMotives are the visit motives of the center matching a selector, the first one being the one booked
+	VisitMotives      []doctolib.BookingVisitMotive
+	EnabledAgendaIds  []int
+	DisabledAgendaIds []int
+	PracticeIds       []int
+	// Problem tells why the center can never be booked, it is empty if it can
+	Problem string
+	Err     error
+}
+
+// CheckVaccinationCenter fetches the booking settings of center and checks whether an appointment could be booked
+// there with the visit motive selectors of config.
+func CheckVaccinationCenter(doctolibClient *doctolib.Client, config *Config,
+	center *VaccinationCenter) *CenterReport {
+	report := &CenterReport{Center: center}
+
+	bookingResponse, err := doctolibClient.GetBooking(center.Name, "")
+	if err != nil {
+		report.Err = err
+		var statusErr *doctolib.StatusError
+		if errors.As(err, &statusErr) {
+			report.StatusCode = statusErr.StatusCode
+			if statusErr.StatusCode == http.StatusNotFound {
+				report.Problem = "the center doesn't exist (anymore)"
+			}
+		}
+		return report
+	}
+	report.StatusCode = http.StatusOK
+	report.ProfileId = bookingResponse.Data.Profile.Id
+
+	for _, motiveSelector := range config.centerMotiveSelectors(center) {
+		visitMotives := matchVisitMotives(&motiveSelector, bookingResponse.Data.VisitMotives)
+		if len(report.VisitMotives) == 0 && len(visitMotives) > 1 {
+			report.Problem = fmt.Sprintf("multiple visit motives match %s, use a more specific selector",
+				&motiveSelector)
+		}
+		for _, visitMotive := range visitMotives {
+			alreadyMatched := false
+			for _, matchedVisitMotive := range report.VisitMotives {
+				alreadyMatched = alreadyMatched || matchedVisitMotive.Id == visitMotive.Id
+			}
+			if !alreadyMatched {
+				report.VisitMotives = append(report.VisitMotives, visitMotive)
+			}
+		}
+	}
+	if len(report.VisitMotives) == 0 {
+		report.Problem = "no visit motive matches the configured motive selectors"
+		return report
+	}
+
+	for _, agenda := range bookingResponse.Data.Agendas {
+		if !utils.IntSliceContains(agenda.VisitMotiveIds, report.VisitMotives[0].Id) {
+			continue
+		}
+
+		if agenda.BookingDisabled || agenda.BookingTemporaryDisabled {
+			report.DisabledAgendaIds = append(report.DisabledAgendaIds, agenda.Id)
+			continue
+		}
+		report.EnabledAgendaIds = append(report.EnabledAgendaIds, agenda.Id)
+		if !utils.IntSliceContains(report.PracticeIds, agenda.PracticeId) {
+			report.PracticeIds = append(report.PracticeIds, agenda.PracticeId)
+		}
+	}
+	if len(report.EnabledAgendaIds) == 0 && report.Problem == "" {
+		report.Problem = fmt.Sprintf("no enabled agenda for visit motive \"%s\"", report.VisitMotives[0].Name)
+	}
+
+	return report
+}
+
+// AccountReport describes a Doctolib account and which of its patients the configured patients designate.
+type AccountReport struct {
+	Username       string
+	FullName       string
+	UserId         int
+	MasterPatients []doctolib.MasterPatient
+	PatientMatches []PatientMatch
+	Err            error
+}
+
+// PatientMatch is the master patient designated by a configured patient, nil if none.
+type PatientMatch struct {
+	Patient       PatientConfig
+	MasterPatient *doctolib.MasterPatient
+}
+
+// CheckAccount logs in with account, whose credentials must be loaded, and matches its configured patients against
+// the patients of the Doctolib account.
+func CheckAccount(doctolibClient *doctolib.Client, account *AccountConfig) *AccountReport {
+	report := &AccountReport{Username: account.Username}
+
+	loginResponse, err := doctolibClient.Login(account.Username, account.secret.Bytes())
+	if err != nil {
+		report.Err = fmt.Errorf("govaccine.CheckAccount(): failed to login: %w", err)
+		return report
+	}
+	report.FullName = loginResponse.FullName
+	report.UserId = loginResponse.Id
+
+	masterPatientsResponse, err := doctolibClient.GetMasterPatients(loginResponse.CsrfToken)
+	if err != nil {
+		report.Err = fmt.Errorf("govaccine.CheckAccount(): failed to get patients: %w", err)
+		return report
+	}
+	report.MasterPatients = masterPatientsResponse.MasterPatients
+
+	patients := account.Patients
+	if len(patients) == 0 {
+		patients = []PatientConfig{{}}
+	}
+	for _, patient := range patients {
+		match := PatientMatch{Patient: patient}
+		for i := range report.MasterPatients {
+			masterPatient := &report.MasterPatients[i]
+			if patient.Matches(masterPatient.Id, masterPatient.FirstName, masterPatient.LastName) {
+				match.MasterPatient = masterPatient
+				break
+			}
+		}
+		report.PatientMatches = append(report.PatientMatches, match)
+	}
+
+	return report
+}
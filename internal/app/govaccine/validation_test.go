@@ -0,0 +1,169 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package govaccine
+
+import (
+	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/doctolib"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testBookings are the booking settings served by the stand-in Doctolib server, by center name.
+var testBookings = map[string]string{
+	"bookable": `{"data": {
+		"profile": {"id": 7},
+		"visit_motives": [{"id": 1, "name": "1re injection vaccin COVID-19 (Pfizer-BioNTech)"},
+			{"id": 2, "name": "2de injection vaccin COVID-19 (Pfizer-BioNTech)"}],
+		"agendas": [{"id": 10, "visit_motive_ids": [1, 2], "practice_id": 100},
+			{"id": 11, "visit_motive_ids": [1], "practice_id": 101, "booking_disabled": true},
+			{"id": 12, "visit_motive_ids": [1], "practice_id": 100},
+			{"id": 13, "visit_motive_ids": [2], "practice_id": 102}]
+	}}`,
+	"other-motives": `{"data": {
+		"profile": {"id": 8},
+		"visit_motives": [{"id": 3, "name": "Consultation de suivi"}],
+		"agendas": [{"id": 20, "visit_motive_ids": [3], "practice_id": 200}]
+	}}`,
+	"disabled-agendas": `{"data": {
+		"profile": {"id": 9},
+		"visit_motives": [{"id": 1, "name": "1re injection vaccin COVID-19 (Pfizer-BioNTech)"}],
+		"agendas": [{"id": 30, "visit_motive_ids": [1], "practice_id": 300, "booking_disabled": true},
+			{"id": 31, "visit_motive_ids": [1], "practice_id": 300, "booking_temporary_disabled": true},
+			{"id": 32, "visit_motive_ids": [4], "practice_id": 301}]
+	}}`,
+}
+
+func TestCheckVaccinationCenter(t *testing.T) {
+	client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+		booking, ok := testBookings[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/booking/"), ".json")]
+		if r.Method != http.MethodGet || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("x-csrf-token", "token")
+		_, _ = io.WriteString(w, booking)
+	})
+	config := DefaultConfig()
+
+	tests := []struct {
+		center                string
+		wantStatusCode        int
+		wantProfileId         int
+		wantVisitMotiveIds    []int
+		wantEnabledAgendaIds  []int
+		wantDisabledAgendaIds []int
+		wantPracticeIds       []int
+		wantProblem           string
+		wantErr               bool
+	}{
+		{
+			center:                "bookable",
+			wantStatusCode:        http.StatusOK,
+			wantProfileId:         7,
+			wantVisitMotiveIds:    []int{1},
+			wantEnabledAgendaIds:  []int{10, 12},
+			wantDisabledAgendaIds: []int{11},
+			wantPracticeIds:       []int{100},
+		},
+		{
+			center:         "other-motives",
+			wantStatusCode: http.StatusOK,
+			wantProfileId:  8,
+			wantProblem:    "no visit motive matches the configured motive selectors",
+		},
+		{
+			center:                "disabled-agendas",
+			wantStatusCode:        http.StatusOK,
+			wantProfileId:         9,
+			wantVisitMotiveIds:    []int{1},
+			wantDisabledAgendaIds: []int{30, 31},
+			wantProblem: fmt.Sprintf("no enabled agenda for visit motive %q",
+				PfizerBiontechVaccineVisitMotiveName),
+		},
+		{
+			center:         "removed",
+			wantStatusCode: http.StatusNotFound,
+			wantProblem:    "the center doesn't exist (anymore)",
+			wantErr:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.center, func(t *testing.T) {
+			report := CheckVaccinationCenter(client, config, &VaccinationCenter{Name: test.center})
+
+			if (report.Err != nil) != test.wantErr {
+				t.Errorf("CheckVaccinationCenter() error = %v, want an error: %t", report.Err, test.wantErr)
+			}
+			if report.StatusCode != test.wantStatusCode || report.ProfileId != test.wantProfileId {
+				t.Errorf("CheckVaccinationCenter() status code %d and profile %d, want %d and %d", report.StatusCode,
+					report.ProfileId, test.wantStatusCode, test.wantProfileId)
+			}
+			visitMotiveIds := make([]int, 0, len(report.VisitMotives))
+			for _, visitMotive := range report.VisitMotives {
+				visitMotiveIds = append(visitMotiveIds, visitMotive.Id)
+			}
+			if !slices.Equal(visitMotiveIds, test.wantVisitMotiveIds) {
+				t.Errorf("CheckVaccinationCenter() visit motives %v, want %v", visitMotiveIds,
+					test.wantVisitMotiveIds)
+			}
+			if !slices.Equal(report.EnabledAgendaIds, test.wantEnabledAgendaIds) ||
+				!slices.Equal(report.DisabledAgendaIds, test.wantDisabledAgendaIds) {
+				t.Errorf("CheckVaccinationCenter() agendas %v (disabled %v), want %v (disabled %v)",
+					report.EnabledAgendaIds, report.DisabledAgendaIds, test.wantEnabledAgendaIds,
+					test.wantDisabledAgendaIds)
+			}
+			if !slices.Equal(report.PracticeIds, test.wantPracticeIds) {
+				t.Errorf("CheckVaccinationCenter() practices %v, want %v", report.PracticeIds, test.wantPracticeIds)
+			}
+			if report.Problem != test.wantProblem {
+				t.Errorf("CheckVaccinationCenter() problem %q, want %q", report.Problem, test.wantProblem)
+			}
+		})
+	}
+}
+
+func TestCheckVaccinationCenterAmbiguousMotive(t *testing.T) {
+	client := newTestDoctolibClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-csrf-token", "token")
+		_, _ = io.WriteString(w, testBookings["bookable"])
+	})
+	config := DefaultConfig()
+	config.Motives = []MotiveSelector{{Pattern: "Pfizer"}}
+	config.Motives[0].regexp = regexp.MustCompile(config.Motives[0].Pattern)
+
+	report := CheckVaccinationCenter(client, config, &VaccinationCenter{Name: "bookable"})
+	if !strings.HasPrefix(report.Problem, "multiple visit motives match") {
+		t.Errorf("CheckVaccinationCenter() problem %q, want multiple visit motives", report.Problem)
+	}
+	if len(report.VisitMotives) != 2 || report.VisitMotives[0] != (doctolib.BookingVisitMotive{Id: 1,
+		Name: "1re injection vaccin COVID-19 (Pfizer-BioNTech)"}) {
+		t.Errorf("CheckVaccinationCenter() visit motives %+v, want both Pfizer-BioNTech motives", report.VisitMotives)
+	}
+}
@@ -25,25 +25,62 @@ package doctolib
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/GuiTeK/govaccine/internal/pkg/logging"
+	"github.com/GuiTeK/govaccine/internal/pkg/metrics"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	url2 "net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Client struct {
-	httpClient *http.Client
+	rootUrl      string
+	httpClient   *http.Client
+	logger       *slog.Logger
+	callObserver CallObserver
 }
 
+// Call describes a request sent to the Doctolib API, as reported to a CallObserver.
+type Call struct {
+	RequestId   string
+	Endpoint    string
+	Method      string
+	Url         string
+	RequestBody []byte
+	StatusCode  int
+	Duration    time.Duration
+	Err         error
+}
+
+// CallObserver is notified of every request sent by a Client once its response has been received.
+type CallObserver func(call *Call)
+
+// StatusError is returned when the Doctolib API answers with an unexpected HTTP status code.
+type StatusError struct {
+	StatusCode int
+	Url        string
+	RequestId  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status code (%d) for %s (request ID %s)", e.StatusCode, e.Url,
+		e.RequestId)
+}
+
+// loginPayload is the login payload but the password, which is added by marshalLoginPayload.
 type loginPayload struct {
 	Remember         bool   `json:"remember"`
 	RememberUsername bool   `json:"remember_username"`
 	Username         string `json:"username"`
-	Password         string `json:"password"`
 	Kind             string `json:"kind"`
 }
 
@@ -70,10 +107,23 @@ type BookingAgenda struct {
 	PracticeId               int   `json:"practice_id"`
 }
 
+type BookingPlace struct {
+	Id          string  `json:"id"`
+	PracticeIds []int   `json:"practice_ids"`
+	Name        string  `json:"name"`
+	Address     string  `json:"address"`
+	Zipcode     string  `json:"zipcode"`
+	City        string  `json:"city"`
+	FullAddress string  `json:"full_address"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+}
+
 type BookingResponseData struct {
 	Profile      BookingProfile       `json:"profile"`
 	VisitMotives []BookingVisitMotive `json:"visit_motives"`
 	Agendas      []BookingAgenda      `json:"agendas"`
+	Places       []BookingPlace       `json:"places"`
 }
 
 type BookingResponse struct {
@@ -81,22 +131,46 @@ type BookingResponse struct {
 	CsrfToken string
 }
 
-type availabilitySlotStep struct {
+type SearchPosition struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type SearchDoctor struct {
+	Id            int            `json:"id"`
+	Link          string         `json:"link"`
+	NameWithTitle string         `json:"name_with_title"`
+	Address       string         `json:"address"`
+	Zipcode       string         `json:"zipcode"`
+	City          string         `json:"city"`
+	Position      SearchPosition `json:"position"`
+}
+
+type SearchResponseData struct {
+	Doctors []SearchDoctor `json:"doctors"`
+	Total   int            `json:"total"`
+}
+
+type SearchResponse struct {
+	Data SearchResponseData `json:"data"`
+}
+
+type AvailabilitySlotStep struct {
 	StartDate string `json:"start_date"`
 }
 
-type availabilitySlot struct {
+type AvailabilitySlot struct {
 	StartDate string                 `json:"start_date"`
-	Steps     []availabilitySlotStep `json:"steps"`
+	Steps     []AvailabilitySlotStep `json:"steps"`
 }
 
-type availability struct {
+type Availability struct {
 	Date  string             `json:"date"`
-	Slots []availabilitySlot `json:"slots"`
+	Slots []AvailabilitySlot `json:"slots"`
 }
 
 type AvailabilitiesResponse struct {
-	Availabilities []availability `json:"availabilities"`
+	Availabilities []Availability `json:"availabilities"`
 	Total          int            `json:"total"`
 	CsrfToken      string
 }
@@ -147,6 +221,69 @@ type MasterPatientsResponse struct {
 	CsrfToken      string
 }
 
+// Appointment is an appointment of a patient, as listed in the Doctolib account.
+type Appointment struct {
+	Id              string `json:"id"`
+	StartDate       string `json:"start_date"`
+	Status          string `json:"status"`
+	VisitMotiveName string `json:"visit_motive_name"`
+	ProfileName     string `json:"profile_name"`
+	Address         string `json:"address"`
+	// LinkedAppointmentId is the ID of the appointment booked along with this one (e.g. its second shot), if any
+	LinkedAppointmentId string `json:"linked_appointment_id"`
+}
+
+type AppointmentsResponse struct {
+	Upcoming  []Appointment `json:"upcoming"`
+	Past      []Appointment `json:"past"`
+	CsrfToken string
+}
+
+type CancelAppointmentResponse struct {
+	CsrfToken string
+}
+
+// QualificationQuestion is a question the patient must answer to book an appointment (e.g. eligibility).
+type QualificationQuestion struct {
+	Id       int    `json:"id"`
+	Label    string `json:"label"`
+	Required bool   `json:"required"`
+}
+
+// CustomField is a field the patient fills in to book an appointment (e.g. social security number).
+type CustomField struct {
+	Id       int    `json:"id"`
+	Label    string `json:"label"`
+	Required bool   `json:"required"`
+}
+
+// AppointmentDetails is a temporary appointment, with the questions and fields to fill in to confirm it.
+type AppointmentDetails struct {
+	Id                     string                  `json:"id"`
+	QualificationQuestions []QualificationQuestion `json:"qualification_questions"`
+	CustomFields           []CustomField           `json:"custom_fields"`
+	CsrfToken              string
+}
+
+// PatientStatus is the status of a master patient at the practices of a profile.
+type PatientStatus struct {
+	// NewPatient is true if the patient never had an appointment at the practices
+	NewPatient bool `json:"new_patient"`
+	// MismatchInsurance is true if the insurance of the patient isn't accepted by the practices
+	MismatchInsurance bool `json:"mismatch_insurance"`
+	// Consented is true if the patient consented to share their data with the practices
+	Consented bool `json:"consented"`
+	CsrfToken string
+}
+
+// Confirmation holds the values sent along with the confirmation of an appointment: whether the patient is new to
+// the practice, the answers to its qualification questions and the values of its custom fields, by ID.
+type Confirmation struct {
+	NewPatient           bool
+	QualificationAnswers map[string]string
+	CustomFieldsValues   map[string]string
+}
+
 type confirmedAppointment struct {
 	QualificationAnswers map[string]string `json:"qualification_answers"`
 	NewPatient           bool              `json:"new_patient"`
@@ -169,8 +306,103 @@ type ConfirmAppointmentResponse struct {
 	CsrfToken string
 }
 
+// AppointmentCanceled is the status of a canceled appointment.
+const AppointmentCanceled = "canceled"
+
+// RootUrl is the root URL of the French Doctolib website, used by default.
 const RootUrl = "https://doctolib.fr"
 
+// DatetimeLayout is the layout of the datetimes (e.g. slot start dates) used by the Doctolib API.
+const DatetimeLayout = "2006-01-02T15:04:05.000-07:00"
+
+var (
+	requestsTotal = metrics.NewCounterVec("govaccine_doctolib_requests_total",
+		"Number of requests sent to the Doctolib API, by endpoint and response status", "endpoint", "status")
+	requestDurationSeconds = metrics.NewHistogramVec("govaccine_doctolib_request_duration_seconds",
+		"Duration of the requests sent to the Doctolib API, by endpoint", metrics.DefaultBuckets, "endpoint")
+)
+
+// IsUnauthorized reports whether err was caused by the Doctolib API rejecting the session (e.g. expired login).
+func IsUnauthorized(err error) bool {
+	var statusError *StatusError
+	return errors.As(err, &statusError) && statusError.StatusCode == http.StatusUnauthorized
+}
+
+// IsThrottled reports whether err was caused by the Doctolib API rate limiting requests.
+func IsThrottled(err error) bool {
+	var statusError *StatusError
+	return errors.As(err, &statusError) && statusError.StatusCode == http.StatusTooManyRequests
+}
+
+// RequestId returns the ID of the request which caused err, if any.
+func RequestId(err error) string {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.RequestId
+	}
+
+	return ""
+}
+
+func newRequestId() string {
+	requestIdBytes := make([]byte, 8)
+	_, _ = rand.Read(requestIdBytes)
+
+	return hex.EncodeToString(requestIdBytes)
+}
+
+// do sends req and logs it along with a request ID which is returned to be attached to errors.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, string, error) {
+	requestId := newRequestId()
+	startTime := time.Now()
+
+	resp, err := c.httpClient.Do(req)
+	requestDurationSeconds.Observe(time.Since(startTime).Seconds(), endpoint)
+	if err != nil {
+		requestsTotal.Inc(endpoint, "error")
+		c.logger.Debug("Doctolib request failed", logging.RequestIdKey, requestId, "endpoint", endpoint,
+			"method", req.Method, "duration", time.Since(startTime), logging.ErrorKey, err)
+		c.observeCall(req, endpoint, requestId, 0, time.Since(startTime), err)
+		return nil, requestId, err
+	}
+	requestsTotal.Inc(endpoint, strconv.Itoa(resp.StatusCode))
+
+	c.logger.Debug("Doctolib request done", logging.RequestIdKey, requestId, "endpoint", endpoint,
+		"method", req.Method, "status", resp.StatusCode, "duration", time.Since(startTime))
+	c.observeCall(req, endpoint, requestId, resp.StatusCode, time.Since(startTime), nil)
+
+	return resp, requestId, nil
+}
+
+func (c *Client) observeCall(req *http.Request, endpoint string, requestId string, statusCode int,
+	duration time.Duration, err error) {
+	if c.callObserver == nil {
+		return
+	}
+
+	call := &Call{
+		RequestId:  requestId,
+		Endpoint:   endpoint,
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		StatusCode: statusCode,
+		Duration:   duration,
+		Err:        err,
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			call.RequestBody, _ = ioutil.ReadAll(body)
+		}
+	}
+
+	c.callObserver(call)
+}
+
+// SetCallObserver registers observer to be notified of every request, or unregisters it if observer is nil.
+func (c *Client) SetCallObserver(observer CallObserver) {
+	c.callObserver = observer
+}
+
 func addCommonHeaders(req *http.Request, isFetchJson bool, csrfToken string) {
 	if isFetchJson {
 		req.Header.Set("accept", "application/json")
@@ -188,25 +420,79 @@ func addCommonHeaders(req *http.Request, isFetchJson bool, csrfToken string) {
 	}
 }
 
+// GetAppointment returns the temporary appointment appointmentId, to find out the questions and fields its
+// confirmation requires.
+func (c *Client) GetAppointment(appointmentId string, csrfToken string) (*AppointmentDetails, error) {
+	url := fmt.Sprintf("%s/appointments/%s.json", c.rootUrl, appointmentId)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): cannot create request %s: %w", url, err)
+	}
+
+	addCommonHeaders(req, true, csrfToken)
+
+	resp, requestId, err := c.do(req, "appointments_get")
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): cannot do request %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
+	}
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): cannot read response of request %s: %w", url, err)
+	}
+
+	var response AppointmentDetails
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): cannot unmarshal response of request %s: %w", url, err)
+	}
+
+	response.CsrfToken = resp.Header.Get("x-csrf-token")
+	if response.CsrfToken == "" {
+		return nil, fmt.Errorf("doctolib.GetAppointment(): no CSRF token found in response")
+	}
+
+	return &response, nil
+}
+
+// ConfirmAppointment confirms the temporary appointment appointmentId for masterPatient, with the values of
+// confirmation.
 func (c *Client) ConfirmAppointment(appointmentId string, startDatetime string, masterPatient MasterPatient,
-	csrfToken string) (*ConfirmAppointmentResponse, error) {
-	url := fmt.Sprintf("%s/appointments/%s.json", RootUrl, appointmentId)
+	confirmation *Confirmation, csrfToken string) (*ConfirmAppointmentResponse, error) {
+	url := fmt.Sprintf("%s/appointments/%s.json", c.rootUrl, appointmentId)
 
 	var payloadBytes []byte
 	var err error
 
+	qualificationAnswers := confirmation.QualificationAnswers
+	if qualificationAnswers == nil {
+		qualificationAnswers = make(map[string]string)
+	}
+	customFieldsValues := confirmation.CustomFieldsValues
+	if customFieldsValues == nil {
+		customFieldsValues = make(map[string]string)
+	}
+
 	payload := confirmAppointmentPayload{
-		NewPatient:                         true,
+		NewPatient:                         confirmation.NewPatient,
 		BypassMandatoryRelativeContactInfo: false,
 		PhoneNumber:                        nil,
 		Email:                              nil,
 		MasterPatient:                      masterPatient,
 		Patient:                            nil,
 		Appointment: confirmedAppointment{
-			QualificationAnswers: make(map[string]string),
-			NewPatient:           true,
+			QualificationAnswers: qualificationAnswers,
+			NewPatient:           confirmation.NewPatient,
 			StartDate:            startDatetime,
-			CustomFieldsValues:   make(map[string]string),
+			CustomFieldsValues:   customFieldsValues,
 			ReferrerId:           nil,
 		},
 	}
@@ -223,7 +509,7 @@ func (c *Client) ConfirmAppointment(appointmentId string, startDatetime string,
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "appointments_confirm")
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.ConfirmAppointment(): cannot do request %s: %w", url, err)
 	}
@@ -231,8 +517,8 @@ func (c *Client) ConfirmAppointment(appointmentId string, startDatetime string,
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.ConfirmAppointment(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.ConfirmAppointment(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -256,7 +542,7 @@ func (c *Client) ConfirmAppointment(appointmentId string, startDatetime string,
 }
 
 func (c *Client) GetMasterPatients(csrfToken string) (*MasterPatientsResponse, error) {
-	url := fmt.Sprintf("%s/account/master_patients.json", RootUrl)
+	url := fmt.Sprintf("%s/account/master_patients.json", c.rootUrl)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -265,7 +551,7 @@ func (c *Client) GetMasterPatients(csrfToken string) (*MasterPatientsResponse, e
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "master_patients")
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.GetMasterPatients(): cannot do request %s: %w", url, err)
 	}
@@ -273,8 +559,8 @@ func (c *Client) GetMasterPatients(csrfToken string) (*MasterPatientsResponse, e
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.GetMasterPatients(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.GetMasterPatients(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -289,11 +575,6 @@ func (c *Client) GetMasterPatients(csrfToken string) (*MasterPatientsResponse, e
 			url, err)
 	}
 
-	for _, masterPatient := range masterPatients {
-		masterPatient.MismatchInsurance = false
-		masterPatient.Consented = true
-	}
-
 	response := MasterPatientsResponse{
 		MasterPatients: masterPatients,
 		CsrfToken:      resp.Header.Get("x-csrf-token"),
@@ -305,9 +586,129 @@ func (c *Client) GetMasterPatients(csrfToken string) (*MasterPatientsResponse, e
 	return &response, nil
 }
 
+// GetPatientStatus returns the status of the master patient masterPatientId at the practices practiceIds of the
+// profile profileId.
+func (c *Client) GetPatientStatus(masterPatientId int, profileId int, practiceIds []int,
+	csrfToken string) (*PatientStatus, error) {
+	formattedPracticeIds := strings.Trim(strings.Join(strings.Split(fmt.Sprint(practiceIds), " "), "-"), "[]")
+	url := fmt.Sprintf("%s/master_patients/%d/status.json?profile_id=%d&practice_ids=%s", c.rootUrl,
+		masterPatientId, profileId, formattedPracticeIds)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): cannot create request %s: %w", url, err)
+	}
+
+	addCommonHeaders(req, true, csrfToken)
+
+	resp, requestId, err := c.do(req, "master_patient_status")
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): cannot do request %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
+	}
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): cannot read response of request %s: %w", url, err)
+	}
+
+	var response PatientStatus
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): cannot unmarshal response of request %s: %w", url, err)
+	}
+
+	response.CsrfToken = resp.Header.Get("x-csrf-token")
+	if response.CsrfToken == "" {
+		return nil, fmt.Errorf("doctolib.GetPatientStatus(): no CSRF token found in response")
+	}
+
+	return &response, nil
+}
+
+// ListAppointments lists the upcoming and past appointments of the master patient masterPatientId.
+func (c *Client) ListAppointments(masterPatientId int, csrfToken string) (*AppointmentsResponse, error) {
+	url := fmt.Sprintf("%s/account/appointments.json?master_patient_id=%d", c.rootUrl, masterPatientId)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): cannot create request %s: %w", url, err)
+	}
+
+	addCommonHeaders(req, true, csrfToken)
+
+	resp, requestId, err := c.do(req, "appointments_list")
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): cannot do request %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
+	}
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): cannot read response of request %s: %w", url, err)
+	}
+
+	var response AppointmentsResponse
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): cannot unmarshal response of request %s: %w",
+			url, err)
+	}
+
+	response.CsrfToken = resp.Header.Get("x-csrf-token")
+	if response.CsrfToken == "" {
+		return nil, fmt.Errorf("doctolib.ListAppointments(): no CSRF token found in response")
+	}
+
+	return &response, nil
+}
+
+// CancelAppointment cancels the confirmed appointment appointmentId. The appointments linked to it aren't canceled.
+func (c *Client) CancelAppointment(appointmentId string, csrfToken string) (*CancelAppointmentResponse, error) {
+	url := fmt.Sprintf("%s/appointments/%s.json", c.rootUrl, appointmentId)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.CancelAppointment(): cannot create request %s: %w", url, err)
+	}
+
+	addCommonHeaders(req, true, csrfToken)
+
+	resp, requestId, err := c.do(req, "appointments_cancel")
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.CancelAppointment(): cannot do request %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctolib.CancelAppointment(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
+	}
+
+	response := CancelAppointmentResponse{CsrfToken: resp.Header.Get("x-csrf-token")}
+	if response.CsrfToken == "" {
+		return nil, fmt.Errorf("doctolib.CancelAppointment(): no CSRF token found in response")
+	}
+
+	return &response, nil
+}
+
 func (c *Client) CreateAppointment(startDatetime string, secondSlotDatetime string, visitMotiveIds []int,
 	agendaIds []int, practiceIds []int, profileId int, csrfToken string) (*CreateAppointmentResponse, error) {
-	url := fmt.Sprintf("%s/appointments.json", RootUrl)
+	url := fmt.Sprintf("%s/appointments.json", c.rootUrl)
 
 	formattedAgendaIds := strings.Trim(strings.Join(strings.Split(fmt.Sprint(agendaIds), " "), "-"),
 		"[]")
@@ -354,7 +755,7 @@ func (c *Client) CreateAppointment(startDatetime string, secondSlotDatetime stri
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "appointments_create")
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.CreateAppointment(): cannot do request %s: %w", url, err)
 	}
@@ -362,8 +763,8 @@ func (c *Client) CreateAppointment(startDatetime string, secondSlotDatetime stri
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.CreateAppointment(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.CreateAppointment(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -393,10 +794,12 @@ func (c *Client) CreateAppointment(startDatetime string, secondSlotDatetime stri
 
 func (c *Client) GetAvailabilities(startDate time.Time, firstSlotDatetime *time.Time, visitMotiveIds []int,
 	agendaIds []int, practiceIds []int, limit int, csrfToken string) (*AvailabilitiesResponse, error) {
-	url := fmt.Sprintf("%s/availabilities.json", RootUrl)
+	url := fmt.Sprintf("%s/availabilities.json", c.rootUrl)
+	endpoint := "availabilities"
 
 	if firstSlotDatetime != nil {
-		url = fmt.Sprintf("%s/second_shot_availabilities.json", RootUrl)
+		url = fmt.Sprintf("%s/second_shot_availabilities.json", c.rootUrl)
+		endpoint = "second_shot_availabilities"
 	}
 
 	formattedStartDate := startDate.Format("2006-01-02")
@@ -411,7 +814,7 @@ func (c *Client) GetAvailabilities(startDate time.Time, firstSlotDatetime *time.
 		url, formattedStartDate, limit, formattedVisitMotiveIds, formattedAgendaIds, formattedPracticeIds)
 
 	if firstSlotDatetime != nil {
-		formattedFirstSlot := url2.QueryEscape(firstSlotDatetime.Format("2006-01-02T15:04:05.000-07:00"))
+		formattedFirstSlot := url2.QueryEscape(firstSlotDatetime.Format(DatetimeLayout))
 		url = fmt.Sprintf("%s&first_slot=%s", url, formattedFirstSlot)
 	} else {
 		url = fmt.Sprintf("%s&destroy_temporary=true", url) // Destroys any appointment not yet confirmed
@@ -424,7 +827,7 @@ func (c *Client) GetAvailabilities(startDate time.Time, firstSlotDatetime *time.
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.GetAvailabilities(): cannot do request %s: %w", url, err)
 	}
@@ -432,8 +835,8 @@ func (c *Client) GetAvailabilities(startDate time.Time, firstSlotDatetime *time.
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.GetAvailabilities(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.GetAvailabilities(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -457,7 +860,7 @@ func (c *Client) GetAvailabilities(startDate time.Time, firstSlotDatetime *time.
 }
 
 func (c *Client) GetBooking(placeName string, csrfToken string) (*BookingResponse, error) {
-	url := fmt.Sprintf("%s/booking/%s.json", RootUrl, placeName)
+	url := fmt.Sprintf("%s/booking/%s.json", c.rootUrl, placeName)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -466,7 +869,7 @@ func (c *Client) GetBooking(placeName string, csrfToken string) (*BookingRespons
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "booking")
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.GetBooking(): cannot do request %s: %w", url, err)
 	}
@@ -474,8 +877,8 @@ func (c *Client) GetBooking(placeName string, csrfToken string) (*BookingRespons
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.GetBooking(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.GetBooking(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -497,8 +900,61 @@ func (c *Client) GetBooking(placeName string, csrfToken string) (*BookingRespons
 	return &response, nil
 }
 
+// locationSlugReplacer turns a city name into its slug in Doctolib URLs (e.g. "Saint-Étienne" -> "saint-etienne").
+var locationSlugReplacer = strings.NewReplacer(" ", "-", "'", "-", "à", "a", "â", "a", "ç", "c", "é", "e", "è", "e",
+	"ê", "e", "ë", "e", "î", "i", "ï", "i", "ô", "o", "ù", "u", "û", "u", "ü", "u", "ä", "a", "ö", "o", "ß", "ss",
+	"ÿ", "y", "œ", "oe", "æ", "ae")
+
+// locationSlug returns the slug of location, escaped so that it stays a single path segment.
+func locationSlug(location string) string {
+	return url2.PathEscape(locationSlugReplacer.Replace(strings.ToLower(strings.TrimSpace(location))))
+}
+
+// Search lists the practitioners of speciality (e.g. "vaccination-covid-19") around location (a city or a
+// postcode), as shown on a page of search results.
+func (c *Client) Search(speciality string, location string, page int) (*SearchResponse, error) {
+	url := fmt.Sprintf("%s/%s/%s.json?page=%d", c.rootUrl, url2.PathEscape(speciality), locationSlug(location), page)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.Search(): cannot create request %s: %w", url, err)
+	}
+
+	addCommonHeaders(req, true, "")
+
+	resp, requestId, err := c.do(req, "search")
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.Search(): cannot do request %s: %w", url, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctolib.Search(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
+	}
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.Search(): cannot read response of request %s: %w", url, err)
+	}
+
+	var response SearchResponse
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("doctolib.Search(): cannot unmarshal response of request %s: %w", url, err)
+	}
+
+	return &response, nil
+}
+
+// Url returns the absolute URL of path (e.g. the link of a SearchDoctor) on the Doctolib website.
+func (c *Client) Url(path string) string {
+	return c.rootUrl + path
+}
+
 func (c *Client) getInitialCsrfToken() (string, error) {
-	sessionsNewUrl := fmt.Sprintf("%s/sessions/new", RootUrl)
+	sessionsNewUrl := fmt.Sprintf("%s/sessions/new", c.rootUrl)
 
 	req, err := http.NewRequest("GET", sessionsNewUrl, nil)
 	if err != nil {
@@ -508,7 +964,7 @@ func (c *Client) getInitialCsrfToken() (string, error) {
 
 	addCommonHeaders(req, false, "")
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "sessions_new")
 	if err != nil {
 		return "", fmt.Errorf("doctolib.getInitialCsrfToken(): cannot do request %s: %w", sessionsNewUrl, err)
 	}
@@ -516,8 +972,8 @@ func (c *Client) getInitialCsrfToken() (string, error) {
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("doctolib.getInitialCsrfToken(): unexpected response status code (%d) for %s",
-			resp.StatusCode, sessionsNewUrl)
+		return "", fmt.Errorf("doctolib.getInitialCsrfToken(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: sessionsNewUrl})
 	}
 
 	csrfToken := resp.Header.Get("x-csrf-token")
@@ -528,33 +984,66 @@ func (c *Client) getInitialCsrfToken() (string, error) {
 	return csrfToken, nil
 }
 
-func (c *Client) Login(username string, password string) (*LoginResponse, error) {
+// marshalLoginPayload marshals payload along with password into a buffer which is the only copy of the password
+// made, so that it can be wiped. The password is escaped as a JSON string, without going through a Go string.
+func marshalLoginPayload(payload *loginPayload, password []byte) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	const hexDigits = "0123456789abcdef"
+	buffer := make([]byte, 0, len(payloadBytes)+len(`"password":"",`)+6*len(password))
+	buffer = append(buffer, `{"password":"`...)
+	for _, b := range password {
+		switch {
+		case b == '"' || b == '\\':
+			buffer = append(buffer, '\\', b)
+		case b < 0x20 || b == '<' || b == '>' || b == '&':
+			buffer = append(buffer, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+		default:
+			buffer = append(buffer, b)
+		}
+	}
+	buffer = append(buffer, `",`...)
+
+	return append(buffer, payloadBytes[1:]...), nil
+}
+
+// Login logs in with username and password. The password is kept out of logs, errors and call observers, and the
+// request payload holding it is wiped once sent. Copies made by the HTTP transport while sending it aren't.
+func (c *Client) Login(username string, password []byte) (*LoginResponse, error) {
 	csrfToken, err := c.getInitialCsrfToken()
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.Login(): cannot get CSRF token for login: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/login.json", RootUrl)
+	url := fmt.Sprintf("%s/login.json", c.rootUrl)
 	payload := loginPayload{
 		Remember:         true,
 		RememberUsername: true,
 		Username:         username,
-		Password:         password,
 		Kind:             "patient",
 	}
-	payloadBytes, err := json.Marshal(payload)
+	payloadBytes, err := marshalLoginPayload(&payload, password)
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.Login(): cannot marshal login payload: %w", err)
 	}
+	defer func() {
+		for i := range payloadBytes {
+			payloadBytes[i] = 0
+		}
+	}()
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.Login(): cannot create request %s: %w", url, err)
 	}
+	req.GetBody = nil // Call observers must not see the password
 
 	addCommonHeaders(req, true, csrfToken)
 
-	resp, err := c.httpClient.Do(req)
+	resp, requestId, err := c.do(req, "login")
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.Login(): cannot do request %s: %w", url, err)
 	}
@@ -562,8 +1051,8 @@ func (c *Client) Login(username string, password string) (*LoginResponse, error)
 		_ = resp.Body.Close()
 	}()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("doctolib.Login(): unexpected response status code (%d) for %s",
-			resp.StatusCode, url)
+		return nil, fmt.Errorf("doctolib.Login(): %w",
+			&StatusError{StatusCode: resp.StatusCode, RequestId: requestId, Url: url})
 	}
 
 	responseBytes, err := ioutil.ReadAll(resp.Body)
@@ -585,13 +1074,14 @@ func (c *Client) Login(username string, password string) (*LoginResponse, error)
 	return &response, nil
 }
 
-func NewClient(requestsTimeout time.Duration) (*Client, error) {
+// NewClient creates a client of the Doctolib website at rootUrl (e.g. RootUrl).
+func NewClient(rootUrl string, requestsTimeout time.Duration, logger *slog.Logger) (*Client, error) {
 	cookieJar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, fmt.Errorf("doctolib.NewClient(): cannot create cookie jar: %w", err)
 	}
 
-	doctolibClient := &Client{}
+	doctolibClient := &Client{rootUrl: strings.TrimSuffix(rootUrl, "/"), logger: logger}
 	doctolibClient.httpClient = &http.Client{
 		Transport:     nil,
 		CheckRedirect: nil,
@@ -0,0 +1,454 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package doctolib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client sending its requests to a stand-in server handled by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, 5*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	return client
+}
+
+func TestGetPatientStatus(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/master_patients/42/status.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("profile_id"); got != "7" {
+			t.Errorf("profile_id = %q, want %q", got, "7")
+		}
+		if got := r.URL.Query().Get("practice_ids"); got != "11-12" {
+			t.Errorf("practice_ids = %q, want %q", got, "11-12")
+		}
+		if got := r.Header.Get("x-csrf-token"); got != "token" {
+			t.Errorf("x-csrf-token = %q, want %q", got, "token")
+		}
+		w.Header().Set("x-csrf-token", "new-token")
+		_, _ = io.WriteString(w, `{"new_patient":false,"mismatch_insurance":true,"consented":true}`)
+	})
+
+	status, err := client.GetPatientStatus(42, 7, []int{11, 12}, "token")
+	if err != nil {
+		t.Fatalf("GetPatientStatus() error = %v", err)
+	}
+	want := PatientStatus{NewPatient: false, MismatchInsurance: true, Consented: true, CsrfToken: "new-token"}
+	if *status != want {
+		t.Errorf("GetPatientStatus() = %+v, want %+v", *status, want)
+	}
+}
+
+func TestGetPatientStatusErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "invalid JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-csrf-token", "new-token")
+				_, _ = io.WriteString(w, `[`)
+			},
+		},
+		{
+			name: "no CSRF token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, `{"new_patient":true}`)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, test.handler)
+
+			_, err := client.GetPatientStatus(42, 7, []int{11}, "token")
+			if err == nil {
+				t.Fatal("GetPatientStatus() error = nil, want an error")
+			}
+			var statusError *StatusError
+			if test.status != 0 && (!errors.As(err, &statusError) || statusError.StatusCode != test.status) {
+				t.Errorf("GetPatientStatus() error = %v, want status %d", err, test.status)
+			}
+		})
+	}
+}
+
+func TestMarshalLoginPayload(t *testing.T) {
+	passwords := []string{"secret", `quo"te\back`, "new\nline\ttab", "<&>", "pâss wörd"}
+	for _, password := range passwords {
+		payloadBytes, err := marshalLoginPayload(&loginPayload{Username: "user", Kind: "patient"}, []byte(password))
+		if err != nil {
+			t.Fatalf("marshalLoginPayload(%q) error = %v", password, err)
+		}
+
+		var payload struct {
+			Password string `json:"password"`
+			Username string `json:"username"`
+			Kind     string `json:"kind"`
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			t.Fatalf("marshalLoginPayload(%q) = %s, not valid JSON: %v", password, payloadBytes, err)
+		}
+		if payload.Password != password || payload.Username != "user" || payload.Kind != "patient" {
+			t.Errorf("marshalLoginPayload(%q) = %s", password, payloadBytes)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.EscapedPath() != "/vaccination-covid-19/saint-etienne.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.EscapedPath())
+		}
+		if got := r.URL.Query().Get("page"); got != "2" {
+			t.Errorf("page = %q, want %q", got, "2")
+		}
+		_, _ = io.WriteString(w, `{"data":{"total":1,"doctors":[{"id":1,`+
+			`"link":"/vaccination-covid-19/saint-etienne/c","name_with_title":"Centre","address":"1 rue","zipcode":"42000","city":"Saint-Étienne",`+
+			`"position":{"lat":45.43,"lng":4.39}}]}}`)
+	})
+
+	response, err := client.Search("vaccination-covid-19", " Saint-Étienne ", 2)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	want := SearchResponseData{Total: 1, Doctors: []SearchDoctor{{
+		Id:            1,
+		Link:          "/vaccination-covid-19/saint-etienne/c",
+		NameWithTitle: "Centre",
+		Address:       "1 rue",
+		Zipcode:       "42000",
+		City:          "Saint-Étienne",
+		Position:      SearchPosition{Lat: 45.43, Lng: 4.39},
+	}}}
+	if response.Data.Total != want.Total || len(response.Data.Doctors) != 1 ||
+		response.Data.Doctors[0] != want.Doctors[0] {
+		t.Errorf("Search() = %+v, want %+v", response.Data, want)
+	}
+}
+
+func TestSearchLocationSlug(t *testing.T) {
+	tests := []struct {
+		location string
+		wantPath string
+	}{
+		{location: "Paris", wantPath: "/vaccination-covid-19/paris.json"},
+		{location: "75001", wantPath: "/vaccination-covid-19/75001.json"},
+		{location: "L'Haÿ-les-Roses", wantPath: "/vaccination-covid-19/l-hay-les-roses.json"},
+		{location: "Aix en Provence", wantPath: "/vaccination-covid-19/aix-en-provence.json"},
+		{location: "Œuilly", wantPath: "/vaccination-covid-19/oeuilly.json"},
+		{location: "paris/../account", wantPath: "/vaccination-covid-19/paris%2F..%2Faccount.json"},
+		{location: "paris?page=9#x", wantPath: "/vaccination-covid-19/paris%3Fpage=9%23x.json"},
+	}
+
+	for _, test := range tests {
+		var gotPath, gotPage string
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			gotPath, gotPage = r.URL.EscapedPath(), r.URL.Query().Get("page")
+			_, _ = io.WriteString(w, `{"data":{"total":0,"doctors":[]}}`)
+		})
+
+		if _, err := client.Search("vaccination-covid-19", test.location, 1); err != nil {
+			t.Errorf("Search(%q) error = %v", test.location, err)
+			continue
+		}
+		if gotPath != test.wantPath || gotPage != "1" {
+			t.Errorf("Search(%q) requested %s?page=%s, want %s?page=1", test.location, gotPath, gotPage,
+				test.wantPath)
+		}
+	}
+}
+
+func TestSearchErrorStatus(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := client.Search("vaccination-covid-19", "paris", 1)
+	var statusError *StatusError
+	if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Search() error = %v, want a StatusError with status 429", err)
+	}
+}
+
+func TestListAppointments(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/account/appointments.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("master_patient_id"); got != "42" {
+			t.Errorf("master_patient_id = %q, want %q", got, "42")
+		}
+		if got := r.Header.Get("x-csrf-token"); got != "token" {
+			t.Errorf("x-csrf-token = %q, want %q", got, "token")
+		}
+		w.Header().Set("x-csrf-token", "new-token")
+		_, _ = io.WriteString(w, `{
+			"upcoming": [{"id": "a1", "start_date": "2021-06-02T09:00:00.000+02:00", "status": "confirmed",
+				"visit_motive_name": "1re injection vaccin COVID-19 (Pfizer-BioNTech)", "profile_name": "Centre A",
+				"address": "1 rue de Paris", "linked_appointment_id": "a2"}],
+			"past": [{"id": "p1", "start_date": "2021-01-04T10:00:00.000+01:00", "status": "done"}]
+		}`)
+	})
+
+	response, err := client.ListAppointments(42, "token")
+	if err != nil {
+		t.Fatalf("ListAppointments() error = %v", err)
+	}
+	wantUpcoming := Appointment{
+		Id:                  "a1",
+		StartDate:           "2021-06-02T09:00:00.000+02:00",
+		Status:              "confirmed",
+		VisitMotiveName:     "1re injection vaccin COVID-19 (Pfizer-BioNTech)",
+		ProfileName:         "Centre A",
+		Address:             "1 rue de Paris",
+		LinkedAppointmentId: "a2",
+	}
+	wantPast := Appointment{Id: "p1", StartDate: "2021-01-04T10:00:00.000+01:00", Status: "done"}
+	if len(response.Upcoming) != 1 || response.Upcoming[0] != wantUpcoming {
+		t.Errorf("ListAppointments() upcoming = %+v, want [%+v]", response.Upcoming, wantUpcoming)
+	}
+	if len(response.Past) != 1 || response.Past[0] != wantPast {
+		t.Errorf("ListAppointments() past = %+v, want [%+v]", response.Past, wantPast)
+	}
+	if response.CsrfToken != "new-token" {
+		t.Errorf("ListAppointments() CSRF token = %q, want %q", response.CsrfToken, "new-token")
+	}
+}
+
+func TestListAppointmentsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-csrf-token", "new-token")
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = io.WriteString(w, `{"upcoming": [], "past": []}`)
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "invalid JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-csrf-token", "new-token")
+				_, _ = io.WriteString(w, `{"upcoming": {}}`)
+			},
+		},
+		{
+			name: "no CSRF token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, `{"upcoming": [], "past": []}`)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, test.handler)
+
+			_, err := client.ListAppointments(42, "token")
+			if err == nil {
+				t.Fatal("ListAppointments() error = nil, want an error")
+			}
+			var statusError *StatusError
+			if test.status != 0 && (!errors.As(err, &statusError) || statusError.StatusCode != test.status) {
+				t.Errorf("ListAppointments() error = %v, want status %d", err, test.status)
+			}
+		})
+	}
+}
+
+func TestGetAppointment(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/appointments/abc-123.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("x-csrf-token"); got != "token" {
+			t.Errorf("x-csrf-token = %q, want %q", got, "token")
+		}
+		w.Header().Set("x-csrf-token", "new-token")
+		_, _ = io.WriteString(w, `{
+			"id": "abc-123",
+			"start_date": "2021-06-02T09:00:00.000+02:00",
+			"qualification_questions": [
+				{"id": 1, "label": "Avez-vous plus de 55 ans ?", "required": true},
+				{"id": 2, "label": "Commentaire", "required": false}
+			],
+			"custom_fields": [{"id": 10, "label": "Numéro de sécurité sociale", "required": true}]
+		}`)
+	})
+
+	appointment, err := client.GetAppointment("abc-123", "token")
+	if err != nil {
+		t.Fatalf("GetAppointment() error = %v", err)
+	}
+	wantQuestions := []QualificationQuestion{
+		{Id: 1, Label: "Avez-vous plus de 55 ans ?", Required: true},
+		{Id: 2, Label: "Commentaire", Required: false},
+	}
+	wantFields := []CustomField{{Id: 10, Label: "Numéro de sécurité sociale", Required: true}}
+	if appointment.Id != "abc-123" || !slices.Equal(appointment.QualificationQuestions, wantQuestions) ||
+		!slices.Equal(appointment.CustomFields, wantFields) || appointment.CsrfToken != "new-token" {
+		t.Errorf("GetAppointment() = %+v, want questions %+v and fields %+v", *appointment, wantQuestions, wantFields)
+	}
+}
+
+func TestGetAppointmentErrorStatus(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := client.GetAppointment("abc-123", "token")
+	var statusError *StatusError
+	if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusNotFound {
+		t.Errorf("GetAppointment() error = %v, want a StatusError with status 404", err)
+	}
+}
+
+func TestCancelAppointment(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/appointments/abc-123.json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("x-csrf-token"); got != "token" {
+			t.Errorf("x-csrf-token = %q, want %q", got, "token")
+		}
+		w.Header().Set("x-csrf-token", "new-token")
+	})
+
+	response, err := client.CancelAppointment("abc-123", "token")
+	if err != nil {
+		t.Fatalf("CancelAppointment() error = %v", err)
+	}
+	if response.CsrfToken != "new-token" {
+		t.Errorf("CancelAppointment() CSRF token = %q, want %q", response.CsrfToken, "new-token")
+	}
+}
+
+func TestCancelAppointmentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-csrf-token", "new-token")
+				w.WriteHeader(http.StatusNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "no CSRF token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, test.handler)
+
+			_, err := client.CancelAppointment("abc-123", "token")
+			if err == nil {
+				t.Fatal("CancelAppointment() error = nil, want an error")
+			}
+			var statusError *StatusError
+			if test.status != 0 && (!errors.As(err, &statusError) || statusError.StatusCode != test.status) {
+				t.Errorf("CancelAppointment() error = %v, want status %d", err, test.status)
+			}
+		})
+	}
+}
+
+func TestLoginCallObserver(t *testing.T) {
+	var loginBody []byte
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-csrf-token", "token")
+		if r.URL.Path == "/login.json" {
+			loginBody, _ = io.ReadAll(r.Body)
+			_, _ = io.WriteString(w, `{"id":1,"full_name":"Alice Martin"}`)
+		}
+	})
+	var calls []Call
+	client.SetCallObserver(func(call *Call) {
+		calls = append(calls, *call)
+	})
+
+	response, err := client.Login("a@example.com", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if response.Id != 1 || response.CsrfToken != "token" {
+		t.Errorf("Login() = %+v", *response)
+	}
+	// The password is sent to Doctolib but not shown to the observer
+	if !strings.Contains(string(loginBody), `"password":"secret"`) {
+		t.Errorf("login request body %s doesn't contain the password", loginBody)
+	}
+	if len(calls) != 2 || calls[0].Endpoint != "sessions_new" || calls[1].Endpoint != "login" {
+		t.Fatalf("got calls %+v, want sessions_new and login", calls)
+	}
+	if calls[1].RequestBody != nil {
+		t.Errorf("login call body = %s, want none", calls[1].RequestBody)
+	}
+}
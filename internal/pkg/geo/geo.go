@@ -0,0 +1,177 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddressApiUrl is the root URL of the French national address API, used by default to geocode addresses.
+const AddressApiUrl = "https://api-adresse.data.gouv.fr"
+
+const earthRadiusKm = 6371.0
+
+type Coordinates struct {
+	Latitude  float64 `json:"latitude,omitempty" yaml:"latitude"`
+	Longitude float64 `json:"longitude,omitempty" yaml:"longitude"`
+}
+
+// Location is a geocoded address.
+type Location struct {
+	Label       string
+	Coordinates Coordinates
+}
+
+type Geocoder struct {
+	apiUrl     string
+	httpClient *http.Client
+}
+
+type addressApiResponse struct {
+	Features []struct {
+		Geometry struct {
+			// Coordinates are the longitude then the latitude
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Label string `json:"label"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+func (c Coordinates) IsZero() bool {
+	return c.Latitude == 0 && c.Longitude == 0
+}
+
+func (c Coordinates) String() string {
+	return fmt.Sprintf("%.6f,%.6f", c.Latitude, c.Longitude)
+}
+
+// ParseCoordinates parses coordinates written as "LATITUDE,LONGITUDE".
+func ParseCoordinates(value string) (Coordinates, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return Coordinates{}, fmt.Errorf("geo.ParseCoordinates(): invalid coordinates \"%s\" (expected LATITUDE,LONGITUDE)",
+			value)
+	}
+
+	latitude, latitudeErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	longitude, longitudeErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if latitudeErr != nil || longitudeErr != nil || math.Abs(latitude) > 90 || math.Abs(longitude) > 180 {
+		return Coordinates{}, fmt.Errorf("geo.ParseCoordinates(): invalid coordinates \"%s\" (expected LATITUDE,LONGITUDE)",
+			value)
+	}
+
+	return Coordinates{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// ParseDistance parses a distance in km, optionally followed by its unit ("km" or "m"), e.g. "10km" or "500m".
+func ParseDistance(distanceValue string) (float64, error) {
+	value := strings.TrimSpace(strings.ToLower(distanceValue))
+	factor := 1.0
+	if strings.HasSuffix(value, "km") {
+		value = strings.TrimSuffix(value, "km")
+	} else if strings.HasSuffix(value, "m") {
+		value = strings.TrimSuffix(value, "m")
+		factor = 0.001
+	}
+
+	distance, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || distance < 0 {
+		return 0, fmt.Errorf("geo.ParseDistance(): invalid distance \"%s\" (expected e.g. 10km or 500m)", distanceValue)
+	}
+
+	return distance * factor, nil
+}
+
+// Distance returns the great-circle distance between a and b in km.
+func Distance(a Coordinates, b Coordinates) float64 {
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+
+	latitudeDelta := toRadians(b.Latitude - a.Latitude)
+	longitudeDelta := toRadians(b.Longitude - a.Longitude)
+	h := math.Sin(latitudeDelta/2)*math.Sin(latitudeDelta/2) +
+		math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*
+			math.Sin(longitudeDelta/2)*math.Sin(longitudeDelta/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// Geocode returns the location best matching address (a full address, a city or a postcode).
+func (g *Geocoder) Geocode(address string) (*Location, error) {
+	searchUrl := fmt.Sprintf("%s/search/?q=%s&limit=1", g.apiUrl, url.QueryEscape(address))
+
+	resp, err := g.httpClient.Get(searchUrl)
+	if err != nil {
+		return nil, fmt.Errorf("geo.Geocode(): cannot do request %s: %w", searchUrl, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geo.Geocode(): unexpected response status code (%d) for %s", resp.StatusCode,
+			searchUrl)
+	}
+
+	responseBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("geo.Geocode(): cannot read response of request %s: %w", searchUrl, err)
+	}
+
+	var response addressApiResponse
+	if err := json.Unmarshal(responseBytes, &response); err != nil {
+		return nil, fmt.Errorf("geo.Geocode(): cannot unmarshal response of request %s: %w", searchUrl, err)
+	}
+	if len(response.Features) == 0 || len(response.Features[0].Geometry.Coordinates) != 2 {
+		return nil, errors.New("geo.Geocode(): address not found: " + address)
+	}
+
+	feature := response.Features[0]
+	return &Location{
+		Label: feature.Properties.Label,
+		Coordinates: Coordinates{
+			Latitude:  feature.Geometry.Coordinates[1],
+			Longitude: feature.Geometry.Coordinates[0],
+		},
+	}, nil
+}
+
+// NewGeocoder creates a geocoder using the address API at apiUrl (e.g. AddressApiUrl).
+func NewGeocoder(apiUrl string, requestsTimeout time.Duration) *Geocoder {
+	return &Geocoder{
+		apiUrl:     strings.TrimSuffix(apiUrl, "/"),
+		httpClient: &http.Client{Timeout: requestsTimeout},
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package geo
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeocode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/search/" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("limit"); got != "1" {
+			t.Errorf("limit = %q, want %q", got, "1")
+		}
+		switch r.URL.Query().Get("q") {
+		case "1 place de l'Hôtel de Ville, Saint-Étienne":
+			_, _ = io.WriteString(w, `{"features": [{
+				"geometry": {"type": "Point", "coordinates": [4.386, 45.4397]},
+				"properties": {"label": "1 Place de l'Hôtel de Ville 42000 Saint-Étienne"}
+			}]}`)
+		case "nowhere":
+			_, _ = io.WriteString(w, `{"features": []}`)
+		case "invalid":
+			_, _ = io.WriteString(w, `{"features": [{"geometry": {"coordinates": [4.386]}}]}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	geocoder := NewGeocoder(server.URL+"/", 5*time.Second)
+
+	location, err := geocoder.Geocode("1 place de l'Hôtel de Ville, Saint-Étienne")
+	if err != nil {
+		t.Fatalf("Geocode() error = %v", err)
+	}
+	want := Location{
+		Label:       "1 Place de l'Hôtel de Ville 42000 Saint-Étienne",
+		Coordinates: Coordinates{Latitude: 45.4397, Longitude: 4.386},
+	}
+	if *location != want {
+		t.Errorf("Geocode() = %+v, want %+v", *location, want)
+	}
+
+	for _, address := range []string{"nowhere", "invalid", "bad request"} {
+		if _, err := geocoder.Geocode(address); err == nil {
+			t.Errorf("Geocode(%q) error = nil, want an error", address)
+		}
+	}
+	if _, err := geocoder.Geocode("nowhere"); err == nil || !strings.Contains(err.Error(), "address not found") {
+		t.Errorf("Geocode(%q) error = %v, want address not found", "nowhere", err)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	paris := Coordinates{Latitude: 48.8566, Longitude: 2.3522}
+	lyon := Coordinates{Latitude: 45.764, Longitude: 4.8357}
+	tests := []struct {
+		name string
+		a    Coordinates
+		b    Coordinates
+		want float64
+	}{
+		{name: "same point", a: paris, b: paris, want: 0},
+		{name: "Paris to Lyon", a: paris, b: lyon, want: 392},
+		{name: "Lyon to Paris", a: lyon, b: paris, want: 392},
+		{name: "one degree of latitude", a: Coordinates{}, b: Coordinates{Latitude: 1}, want: 111.2},
+		{name: "antipodes", a: Coordinates{}, b: Coordinates{Longitude: 180}, want: math.Pi * earthRadiusKm},
+	}
+
+	for _, test := range tests {
+		if got := Distance(test.a, test.b); math.Abs(got-test.want) > 1 {
+			t.Errorf("%s: Distance() = %.1f km, want %.1f km", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+// Package metrics implements the few Prometheus metric types govaccine needs and exposes them in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type collector interface {
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+type labeledValues struct {
+	mutex       sync.Mutex
+	labelNames  []string
+	labelValues map[string][]string
+	values      map[string]float64
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	values labeledValues
+}
+
+type GaugeVec struct {
+	name   string
+	help   string
+	values labeledValues
+}
+
+type GaugeFunc struct {
+	name     string
+	help     string
+	function func() float64
+}
+
+type histogramValue struct {
+	labelValues  []string
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+type HistogramVec struct {
+	mutex      sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	values     map[string]*histogramValue
+}
+
+// DefaultBuckets are suited to HTTP request durations, in seconds.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var DefaultRegistry = &Registry{}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, c := range r.collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+		DefaultRegistry.Write(w)
+	})
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+	helpReplacer       = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpReplacer.Replace(help), name, metricType)
+}
+
+func formatLabels(labelNames []string, labelValues []string, extraName string, extraValue string) string {
+	var labels []string
+	for i, labelName := range labelNames {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if extraName != "" {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", extraName, escapeLabelValue(extraValue)))
+	}
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func labelsKey(labelNames []string, labelValues []string) string {
+	if len(labelValues) != len(labelNames) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(labelNames), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, "\x00")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (l *labeledValues) add(delta float64, labelValues []string) {
+	key := labelsKey(l.labelNames, labelValues)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.labelValues[key]; !ok {
+		l.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	l.values[key] += delta
+}
+
+func (l *labeledValues) set(value float64, labelValues []string) {
+	key := labelsKey(l.labelNames, labelValues)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.labelValues[key]; !ok {
+		l.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	l.values[key] = value
+}
+
+func (l *labeledValues) write(w io.Writer, name string, help string, metricType string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	writeHeader(w, name, help, metricType)
+	for _, key := range sortedKeys(l.labelValues) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(l.labelNames, l.labelValues[key], "", ""),
+			formatValue(l.values[key]))
+	}
+}
+
+func newLabeledValues(labelNames []string) labeledValues {
+	return labeledValues{
+		labelNames:  labelNames,
+		labelValues: make(map[string][]string),
+		values:      make(map[string]float64),
+	}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.values.add(1, labelValues)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+
+	c.values.add(delta, labelValues)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.values.write(w, c.name, c.help, "counter")
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.values.set(value, labelValues)
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.values.add(delta, labelValues)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.values.write(w, g.name, g.help, "gauge")
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	_, _ = fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.function()))
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelsKey(h.labelNames, labelValues)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	histogram, ok := h.values[key]
+	if !ok {
+		histogram = &histogramValue{
+			labelValues:  append([]string(nil), labelValues...),
+			bucketCounts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = histogram
+	}
+
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			histogram.bucketCounts[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range keys {
+		histogram := h.values[key]
+		for i, bucket := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(h.labelNames, histogram.labelValues, "le", formatValue(bucket)), histogram.bucketCounts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(h.labelNames, histogram.labelValues, "le", "+Inf"), histogram.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labelNames, histogram.labelValues, "", ""),
+			formatValue(histogram.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, histogram.labelValues, "", ""),
+			histogram.count)
+	}
+}
+
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	counter := &CounterVec{name: name, help: help, values: newLabeledValues(labelNames)}
+	DefaultRegistry.register(counter)
+
+	return counter
+}
+
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	gauge := &GaugeVec{name: name, help: help, values: newLabeledValues(labelNames)}
+	DefaultRegistry.register(gauge)
+
+	return gauge
+}
+
+func NewGaugeFunc(name string, help string, function func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{name: name, help: help, function: function}
+	DefaultRegistry.register(gauge)
+
+	return gauge
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds. The +Inf bucket is always exposed and doesn't need
+// to be part of buckets.
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sortedBuckets := make([]float64, 0, len(buckets))
+	for _, bucket := range buckets {
+		if !math.IsInf(bucket, 1) {
+			sortedBuckets = append(sortedBuckets, bucket)
+		}
+	}
+	sort.Float64s(sortedBuckets)
+
+	histogram := &HistogramVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    sortedBuckets,
+		values:     make(map[string]*histogramValue),
+	}
+	DefaultRegistry.register(histogram)
+
+	return histogram
+}
@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 Guillaume Truchot
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func written(c collector) string {
+	var buffer bytes.Buffer
+	c.write(&buffer)
+
+	return buffer.String()
+}
+
+func TestHistogramExposition(t *testing.T) {
+	histogram := NewHistogramVec("test_duration_seconds", "Duration of the test.", []float64{1, 0.1, math.Inf(1)},
+		"method")
+	histogram.Observe(0.05, "GET")
+	histogram.Observe(0.1, "GET")
+	histogram.Observe(0.5, "GET")
+	histogram.Observe(3, "GET")
+	histogram.Observe(2, "POST")
+
+	want := `# HELP test_duration_seconds Duration of the test.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 2
+test_duration_seconds_bucket{method="GET",le="1"} 3
+test_duration_seconds_bucket{method="GET",le="+Inf"} 4
+test_duration_seconds_sum{method="GET"} 3.65
+test_duration_seconds_count{method="GET"} 4
+test_duration_seconds_bucket{method="POST",le="0.1"} 0
+test_duration_seconds_bucket{method="POST",le="1"} 0
+test_duration_seconds_bucket{method="POST",le="+Inf"} 1
+test_duration_seconds_sum{method="POST"} 2
+test_duration_seconds_count{method="POST"} 1
+`
+	if got := written(histogram); got != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	histogram := NewHistogramVec("test_size_bytes", "Size.", []float64{10})
+	histogram.Observe(20)
+
+	want := `# HELP test_size_bytes Size.
+# TYPE test_size_bytes histogram
+test_size_bytes_bucket{le="10"} 0
+test_size_bytes_bucket{le="+Inf"} 1
+test_size_bytes_sum 20
+test_size_bytes_count 1
+`
+	if got := written(histogram); got != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "plain", want: `plain`},
+		{value: `back\slash`, want: `back\\slash`},
+		{value: `"quoted"`, want: `\"quoted\"`},
+		{value: "new\nline", want: `new\nline`},
+		{value: "Centre d'Été", want: `Centre d'Été`},
+	}
+
+	for _, test := range tests {
+		counter := NewCounterVec("test_escaping_total", "Escaping.", "center")
+		counter.Inc(test.value)
+
+		want := "test_escaping_total{center=\"" + test.want + "\"} 1\n"
+		if got := written(counter); !strings.HasSuffix(got, want) {
+			t.Errorf("label value %q: got %q, want suffix %q", test.value, got, want)
+		}
+	}
+}
+
+func TestHelpEscaping(t *testing.T) {
+	gauge := NewGaugeFunc("test_gauge", "First line\nsecond \\ line", func() float64 { return math.Inf(1) })
+
+	want := `# HELP test_gauge First line\nsecond \\ line
+# TYPE test_gauge gauge
+test_gauge +Inf
+`
+	if got := written(gauge); got != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	counter := NewCounterVec("test_requests_total", "Requests.", "code", "method")
+	counter.Inc("200", "GET")
+	counter.Add(2.5, "200", "GET")
+	counter.Inc("500", "GET")
+
+	labelValues := []string{"paris"}
+	gauge := NewGaugeVec("test_backlog", "Backlog.", "city")
+	gauge.Set(3, labelValues...)
+	labelValues[0] = "lyon"
+	gauge.Add(-1, "paris")
+
+	wantCounter := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200",method="GET"} 3.5
+test_requests_total{code="500",method="GET"} 1
+`
+	if got := written(counter); got != wantCounter {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, wantCounter)
+	}
+	wantGauge := `# HELP test_backlog Backlog.
+# TYPE test_backlog gauge
+test_backlog{city="paris"} 2
+`
+	if got := written(gauge); got != wantGauge {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, wantGauge)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewCounterVec("test_handler_total", "Handler.").Inc()
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	contentType := recorder.Header().Get("content-type")
+	if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	if !strings.Contains(string(body), "# TYPE test_handler_total counter\ntest_handler_total 1\n") {
+		t.Errorf("counter missing from the exposition:\n%s", body)
+	}
+}